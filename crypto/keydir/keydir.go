@@ -0,0 +1,239 @@
+package keydir
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// File names in the key directory. Previous signing keys are named by the time they retire
+const (
+	PrivateKeyFile    = "private.pem"
+	PublicKeyFile     = "public.pem"
+	previousKeyPrefix = "previous-"
+	lockFile          = ".lock"
+)
+
+// PreviousKey - Public key of a rotated signing key, kept for verification until RetireAt
+type PreviousKey struct {
+	PublicKey crypto.PublicKey
+	RetireAt  time.Time
+}
+
+// LoadOrGenerate - Load the signing key from dir, or generate and write one with alg if the directory has none.
+// The directory is locked while loading, so instances sharing the directory never generate two different keys
+func LoadOrGenerate(dir, alg string, generate func(alg string) (crypto.Signer, error)) (crypto.Signer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Key directory: %s could not be created: %v", dir, err)
+	}
+	unlock, err := lock(filepath.Join(dir, lockFile))
+	if err != nil {
+		return nil, fmt.Errorf("Key directory: %s could not be locked: %v", dir, err)
+	}
+	defer unlock()
+
+	privPath := filepath.Join(dir, PrivateKeyFile)
+	if _, err := os.Stat(privPath); err == nil {
+		key, err := signing.ParsePrivateKey(privPath, "")
+		if err != nil {
+			return nil, err
+		}
+		if keyAlg, _ := signing.Algorithm(key.Public()); keyAlg != alg {
+			return nil, fmt.Errorf("Key directory: %s has a %s key, not %s", dir, keyAlg, alg)
+		}
+		logger.Info.Printf("Signing key loaded from: %s", privPath)
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Private key: %s could not be read: %v", privPath, err)
+	}
+
+	logger.Info.Printf("No signing key in: %s, generating new %s key", dir, alg)
+	key, err := generate(alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := write(dir, key); err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Signing key written to: %s", privPath)
+	return key, nil
+}
+
+// Rotate - Write key as the new signing key. The public key of the current signing key is kept as a
+// previous key until retireAt, so tokens it signed are still accepted after a restart. apply is called
+// with the directory still locked once the key is written, to start using it. If apply fails, the
+// directory is restored, so it never records another signing key than the one in use
+func Rotate(dir string, key crypto.Signer, retireAt time.Time, apply func() error) error {
+	unlock, err := lock(filepath.Join(dir, lockFile))
+	if err != nil {
+		return fmt.Errorf("Key directory: %s could not be locked: %v", dir, err)
+	}
+	defer unlock()
+
+	pubPath := filepath.Join(dir, PublicKeyFile)
+	currentPub, err := ioutil.ReadFile(pubPath)
+	if err != nil {
+		return fmt.Errorf("Public key: %s could not be read: %v", pubPath, err)
+	}
+	privPath := filepath.Join(dir, PrivateKeyFile)
+	currentPriv, err := ioutil.ReadFile(privPath)
+	if err != nil {
+		return fmt.Errorf("Private key: %s could not be read: %v", privPath, err)
+	}
+	previous := filepath.Join(dir, fmt.Sprintf("%s%d.pem", previousKeyPrefix, retireAt.UnixNano()))
+	if err := writeFileAtomic(previous, currentPub); err != nil {
+		return err
+	}
+	if err := write(dir, key); err != nil {
+		restore(dir, previous, currentPub, currentPriv)
+		return err
+	}
+	if err := apply(); err != nil {
+		restore(dir, previous, currentPub, currentPriv)
+		return err
+	}
+	logger.Info.Printf("Signing key written to: %s", privPath)
+	return nil
+}
+
+// restore - Put the signing key files back, and remove the previous key file of a failed rotation.
+// Caller must hold the lock
+func restore(dir, previous string, pub, priv []byte) {
+	if err := writeFileAtomic(filepath.Join(dir, PublicKeyFile), pub); err != nil {
+		logger.Error.Printf("Signing key in: %s could not be restored: %v", dir, err)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(dir, PrivateKeyFile), priv); err != nil {
+		logger.Error.Printf("Signing key in: %s could not be restored: %v", dir, err)
+		return
+	}
+	if err := os.Remove(previous); err != nil {
+		logger.Warning.Printf("Key file: %s could not be removed: %v", previous, err)
+	}
+}
+
+// LoadPrevious - Previous signing keys that are not retired. Files of retired keys are removed
+func LoadPrevious(dir string) ([]PreviousKey, error) {
+	unlock, err := lock(filepath.Join(dir, lockFile))
+	if err != nil {
+		return nil, fmt.Errorf("Key directory: %s could not be locked: %v", dir, err)
+	}
+	defer unlock()
+
+	paths, retireAt, err := previousFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	keys := []PreviousKey{}
+	for i, path := range paths {
+		if !now.Before(retireAt[i]) {
+			if err := os.Remove(path); err != nil {
+				logger.Warning.Printf("Retired key: %s could not be removed: %v", path, err)
+			}
+			continue
+		}
+		pub, err := signing.ParsePublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, PreviousKey{PublicKey: pub, RetireAt: retireAt[i]})
+	}
+	return keys, nil
+}
+
+// RemovePrevious - Remove the previous signing key with the kid, so it is not loaded again
+func RemovePrevious(dir, kid string) error {
+	unlock, err := lock(filepath.Join(dir, lockFile))
+	if err != nil {
+		return fmt.Errorf("Key directory: %s could not be locked: %v", dir, err)
+	}
+	defer unlock()
+
+	paths, _, err := previousFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		pub, err := signing.ParsePublicKey(path)
+		if err != nil {
+			return err
+		}
+		if id, _ := signing.Thumbprint(pub); id == kid {
+			return os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// previousFiles - Previous key files, and the time each retires. Caller must hold the lock
+func previousFiles(dir string) ([]string, []time.Time, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, previousKeyPrefix+"*.pem"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Key directory: %s could not be read: %v", dir, err)
+	}
+	res := []string{}
+	retireAt := []time.Time{}
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), previousKeyPrefix), ".pem")
+		nanos, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			logger.Warning.Printf("Key file: %s ignored, the name has no retirement time", path)
+			continue
+		}
+		res = append(res, path)
+		retireAt = append(retireAt, time.Unix(0, nanos))
+	}
+	return res, retireAt, nil
+}
+
+// write - Write the private key as PKCS#8 and the public key as PKIX. The public key is written first,
+// so a private key file is never left without its public half
+func write(dir string, key crypto.Signer) error {
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("Private key could not be encoded: %v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return fmt.Errorf("Public key could not be encoded: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, PublicKeyFile), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, PrivateKeyFile), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}))
+}
+
+// writeFileAtomic - Write to a temporary file with 0600 permissions, and rename it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Key file: %s could not be written: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Key file: %s could not be written: %v", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Key file: %s could not be written: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Key file: %s could not be written: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Key file: %s could not be written: %v", path, err)
+	}
+	return nil
+}
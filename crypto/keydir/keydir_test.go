@@ -0,0 +1,196 @@
+package keydir
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// Important to not get nullpointer on logger!
+func init() {
+	logger.TestInit()
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "keydir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// countingGenerate - signing.GenerateKey, counting the calls
+func countingGenerate(count *int32) func(alg string) (crypto.Signer, error) {
+	return func(alg string) (crypto.Signer, error) {
+		atomic.AddInt32(count, 1)
+		return signing.GenerateKey(alg)
+	}
+}
+
+func TestLoadOrGenerate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	keyDir := filepath.Join(dir, "keys")
+	var count int32
+
+	first, err := LoadOrGenerate(keyDir, signing.ES256, countingGenerate(&count))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, name := range []string{PrivateKeyFile, PublicKeyFile} {
+		fi, err := os.Stat(filepath.Join(keyDir, name))
+		if err != nil {
+			t.Fatalf("Expected %s to be written: %v", name, err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("%s, Expected permissions 0600, Got: %v", name, fi.Mode().Perm())
+		}
+	}
+
+	second, err := LoadOrGenerate(keyDir, signing.ES256, countingGenerate(&count))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected key to be generated once, Got: %d", count)
+	}
+	exp, _ := signing.Thumbprint(first.Public())
+	res, _ := signing.Thumbprint(second.Public())
+	if res != exp {
+		t.Errorf("Expected the generated key to be loaded, Got kid: %s, Expected: %s", res, exp)
+	}
+	pub, err := signing.ParsePublicKey(filepath.Join(keyDir, PublicKeyFile))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res, _ := signing.Thumbprint(pub); res != exp {
+		t.Errorf("Expected public key to match private key, Got kid: %s, Expected: %s", res, exp)
+	}
+
+	// The algorithm can not change for an existing key
+	if _, err := LoadOrGenerate(keyDir, signing.EdDSA, countingGenerate(&count)); err == nil {
+		t.Error("Expected error loading ES256 key as EdDSA")
+	}
+}
+
+func TestLoadOrGenerateConcurrent(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	var count int32
+
+	// Every instance sharing the directory gets the same key
+	kids := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range kids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key, err := LoadOrGenerate(dir, signing.EdDSA, countingGenerate(&count))
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			kids[i], _ = signing.Thumbprint(key.Public())
+		}(i)
+	}
+	wg.Wait()
+
+	if count != 1 {
+		t.Errorf("Expected key to be generated once, Got: %d", count)
+	}
+	for _, kid := range kids {
+		if kid != kids[0] {
+			t.Errorf("Expected all instances to load the same key, Got: %v", kids)
+			break
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	var count int32
+	first, err := LoadOrGenerate(dir, signing.ES256, countingGenerate(&count))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	firstKid, _ := signing.Thumbprint(first.Public())
+
+	second, _ := signing.GenerateKey(signing.ES256)
+	if err := Rotate(dir, second, time.Now().Add(time.Hour), func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	third, _ := signing.GenerateKey(signing.ES256)
+	if err := Rotate(dir, third, time.Now().Add(-time.Second), func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The last rotated key is loaded as signing key
+	loaded, err := LoadOrGenerate(dir, signing.ES256, countingGenerate(&count))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp, _ := signing.Thumbprint(third.Public())
+	if res, _ := signing.Thumbprint(loaded.Public()); res != exp {
+		t.Errorf("Expected rotated key to be loaded, Got kid: %s, Expected: %s", res, exp)
+	}
+	// The second key is already retired, and its file is removed
+	previous, err := LoadPrevious(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(previous) != 1 {
+		t.Fatalf("Expected 1 previous key, Got: %d", len(previous))
+	}
+	if kid, _ := signing.Thumbprint(previous[0].PublicKey); kid != firstKid {
+		t.Errorf("Expected first key as previous key, Got kid: %s", kid)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, previousKeyPrefix+"*")); len(files) != 1 {
+		t.Errorf("Expected retired key file removed, Got: %v", files)
+	}
+
+	if err := RemovePrevious(dir, firstKid); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if previous, _ := LoadPrevious(dir); len(previous) != 0 {
+		t.Errorf("Expected no previous keys after remove, Got: %d", len(previous))
+	}
+}
+
+func TestRotateApplyFails(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	var count int32
+	if _, err := LoadOrGenerate(dir, signing.ES256, countingGenerate(&count)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pub, _ := ioutil.ReadFile(filepath.Join(dir, PublicKeyFile))
+	priv, _ := ioutil.ReadFile(filepath.Join(dir, PrivateKeyFile))
+
+	// The key directory is restored when the new key can not be used
+	second, _ := signing.GenerateKey(signing.ES256)
+	applyErr := errors.New("apply failed")
+	if err := Rotate(dir, second, time.Now().Add(time.Hour), func() error { return applyErr }); err != applyErr {
+		t.Fatalf("Expected: %v, Got: %v", applyErr, err)
+	}
+	if res, _ := ioutil.ReadFile(filepath.Join(dir, PublicKeyFile)); !bytes.Equal(res, pub) {
+		t.Error("Expected public key to be restored")
+	}
+	if res, _ := ioutil.ReadFile(filepath.Join(dir, PrivateKeyFile)); !bytes.Equal(res, priv) {
+		t.Error("Expected private key to be restored")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, previousKeyPrefix+"*")); len(files) != 0 {
+		t.Errorf("Expected no previous key files, Got: %v", files)
+	}
+}
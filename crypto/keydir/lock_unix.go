@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package keydir
+
+import (
+	"os"
+	"syscall"
+)
+
+// lock - Take an exclusive flock on path, blocking until it is available.
+// The lock is released by the returned func, or when the process exits
+func lock(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,35 @@
+//go:build windows
+// +build windows
+
+package keydir
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// lockTimeout - A lock file older than this is left by a crashed process, and is taken over
+const lockTimeout = 2 * time.Minute
+
+// lock - Create path exclusively, polling until it is available. Windows has no flock,
+// so the lock file is removed by the returned func
+func lock(path string) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > lockTimeout {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, errors.New("Stale lock file could not be removed: " + err.Error())
+			}
+			continue
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,179 @@
+package keyring
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jafossum/go-auth-server/crypto/signing"
+)
+
+// Key - Signing or verification key, identified by its kid
+type Key struct {
+	ID string
+	// Alg - JWS signing algorithm, given by the key type
+	Alg string
+	// PrivateKey - Nil for verification-only keys loaded from a public key
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+	// RetireAt - Time the key is removed from the ring. Zero if not scheduled for retirement
+	RetireAt time.Time
+}
+
+// retired - True if the key is past its retirement time
+func (k *Key) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// KeyRing - One active signing key, and any number of verification-only keys.
+// All keys that are not retired are published in the JWKS and accepted when validating tokens
+type KeyRing struct {
+	mu      sync.RWMutex
+	signing *Key
+	verify  []*Key
+}
+
+// New - Create a key ring with privateKey as the active signing key
+func New(privateKey crypto.Signer) (*KeyRing, error) {
+	k, err := newKey(privateKey, privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &KeyRing{signing: k}, nil
+}
+
+func newKey(privateKey crypto.Signer, publicKey crypto.PublicKey) (*Key, error) {
+	alg, err := signing.Algorithm(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := signing.Thumbprint(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: kid, Alg: alg, PrivateKey: privateKey, PublicKey: publicKey}, nil
+}
+
+// Signing - The active signing key
+func (r *KeyRing) Signing() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.signing
+}
+
+// Get - Find a key by kid, for validation. Returns nil if the key is unknown or retired
+func (r *KeyRing) Get(kid string) *Key {
+	for _, k := range r.Keys() {
+		if k.ID == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// Keys - The signing key followed by all verification keys that are not retired, sorted by kid
+func (r *KeyRing) Keys() []*Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	res := []*Key{r.signing}
+	for _, k := range r.verify {
+		if !k.retired(now) {
+			res = append(res, k)
+		}
+	}
+	v := res[1:]
+	sort.Slice(v, func(i, j int) bool { return v[i].ID < v[j].ID })
+	return res
+}
+
+// AddVerificationKey - Add a verification-only public key, e.g. the public half of a previous signing key
+func (r *KeyRing) AddVerificationKey(publicKey crypto.PublicKey) (*Key, error) {
+	k, err := newKey(nil, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.find(k.ID) != nil {
+		return nil, fmt.Errorf("Key: %s is already in the key ring", k.ID)
+	}
+	r.verify = append(r.verify, k)
+	return k, nil
+}
+
+// AddPreviousKey - Add the public key of a previous signing key, kept for verification until retireAt
+func (r *KeyRing) AddPreviousKey(publicKey crypto.PublicKey, retireAt time.Time) (*Key, error) {
+	k, err := r.AddVerificationKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k.RetireAt = retireAt
+	return k, nil
+}
+
+// Rotate - Promote privateKey to the active signing key. The previous signing key is kept
+// for verification until the grace period is over, so tokens it signed stay valid until they expire
+func (r *KeyRing) Rotate(privateKey crypto.Signer, grace time.Duration) (*Key, error) {
+	k, err := newKey(privateKey, privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.find(k.ID) != nil {
+		return nil, fmt.Errorf("Key: %s is already in the key ring", k.ID)
+	}
+	r.purge()
+	prev := *r.signing
+	prev.RetireAt = time.Now().Add(grace)
+	r.verify = append(r.verify, &prev)
+	r.signing = k
+	return k, nil
+}
+
+// Retire - Remove a verification key from the ring immediately. The signing key can not be retired
+func (r *KeyRing) Retire(kid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.signing.ID == kid {
+		return errors.New("The active signing key can not be retired")
+	}
+	for i, k := range r.verify {
+		if k.ID == kid {
+			r.verify = append(r.verify[:i], r.verify[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Key: %s not found", kid)
+}
+
+// find - Caller must hold the lock
+func (r *KeyRing) find(kid string) *Key {
+	if r.signing.ID == kid {
+		return r.signing
+	}
+	for _, k := range r.verify {
+		if k.ID == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// purge - Remove retired verification keys. Caller must hold the write lock
+func (r *KeyRing) purge() {
+	now := time.Now()
+	keys := r.verify[:0]
+	for _, k := range r.verify {
+		if !k.retired(now) {
+			keys = append(keys, k)
+		}
+	}
+	r.verify = keys
+}
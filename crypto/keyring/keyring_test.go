@@ -0,0 +1,127 @@
+package keyring
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	rsaa "github.com/jafossum/go-auth-server/crypto/rsa"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// Important to not get nullpointer on logger!
+func init() {
+	logger.TestInit()
+}
+
+func genKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func keyIDs(keys []*Key) []string {
+	ids := []string{}
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	return ids
+}
+
+func TestNew(t *testing.T) {
+	key, _ := rsaa.ParseRsaKeys("../../test-resources/private.pem", "", "../../test-resources/public.pem")
+	r, err := New(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp := "iu/doGX/WCNvVzSzGspKSJ0/ekM"
+	if r.Signing().ID != exp {
+		t.Errorf("Signing().ID, Expected: %v, Got: %v", exp, r.Signing().ID)
+	}
+	if len(r.Keys()) != 1 {
+		t.Errorf("Keys(), Expected 1 key, Got: %v", keyIDs(r.Keys()))
+	}
+	if r.Get(exp) == nil {
+		t.Errorf("Get(%s), Expected key", exp)
+	}
+	if r.Get("unknown") != nil {
+		t.Error("Get(unknown), Expected nil")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	r, _ := New(genKey(t))
+	first := r.Signing()
+
+	second, err := r.Rotate(genKey(t), time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r.Signing().ID != second.ID {
+		t.Errorf("Signing().ID, Expected: %v, Got: %v", second.ID, r.Signing().ID)
+	}
+	old := r.Get(first.ID)
+	if old == nil {
+		t.Fatal("Expected previous signing key to be kept for verification")
+	}
+	if old.RetireAt.IsZero() {
+		t.Error("Expected previous signing key to be scheduled for retirement")
+	}
+	if len(r.Keys()) != 2 {
+		t.Errorf("Keys(), Expected 2 keys, Got: %v", keyIDs(r.Keys()))
+	}
+	if _, err := r.Rotate(second.PrivateKey, time.Hour); err == nil {
+		t.Error("Expected error rotating to a key already in the ring")
+	}
+}
+
+func TestRotateGracePeriodOver(t *testing.T) {
+	r, _ := New(genKey(t))
+	first := r.Signing()
+
+	if _, err := r.Rotate(genKey(t), -time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r.Get(first.ID) != nil {
+		t.Error("Expected retired key to be removed")
+	}
+	if len(r.Keys()) != 1 {
+		t.Errorf("Keys(), Expected 1 key, Got: %v", keyIDs(r.Keys()))
+	}
+}
+
+func TestAddVerificationKeyAndRetire(t *testing.T) {
+	r, _ := New(genKey(t))
+	pub := genKey(t).PublicKey
+
+	k, err := r.AddVerificationKey(&pub)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if k.PrivateKey != nil || !k.RetireAt.IsZero() {
+		t.Errorf("Expected verification-only key without retirement, Got: %v", k)
+	}
+	if _, err := r.AddVerificationKey(&pub); err == nil {
+		t.Error("Expected error adding the same key twice")
+	}
+	if r.Get(k.ID) == nil {
+		t.Errorf("Get(%s), Expected key", k.ID)
+	}
+
+	if err := r.Retire(r.Signing().ID); err == nil {
+		t.Error("Expected error retiring the signing key")
+	}
+	if err := r.Retire(k.ID); err != nil {
+		t.Errorf("Retire(%s), Unexpected error: %v", k.ID, err)
+	}
+	if r.Get(k.ID) != nil {
+		t.Error("Expected retired key to be removed")
+	}
+	if err := r.Retire(k.ID); err == nil {
+		t.Error("Expected error retiring unknown key")
+	}
+}
@@ -1,31 +1,148 @@
 package passwd
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 const pwdMinLen = 6
 
-// HashAndSalt - Hash and salt password using bcrypt
+// Supported hash algorithms
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+	Scrypt   = "scrypt"
+)
+
+// Algorithms - Supported hash algorithms
+var Algorithms = []string{Bcrypt, Argon2id, Scrypt}
+
+// ErrMismatch - Password does not match the hash
+var ErrMismatch = errors.New("Password does not match the hash")
+
+// Config - Algorithm and cost of new hashes
+type Config struct {
+	Algorithm string
+	// Cost - bcrypt cost, argon2id iterations or scrypt log2(N). The algorithm default if 0
+	Cost int
+}
+
+// defaultConfig - Used by HashAndSalt and NeedsRehash
+var defaultConfig = Config{Algorithm: Bcrypt, Cost: bcrypt.DefaultCost}
+
+// SetDefault - Set the algorithm and cost of new hashes. Empty algorithm is bcrypt
+func SetDefault(c Config) error {
+	c, err := c.normalize()
+	if err != nil {
+		return err
+	}
+	defaultConfig = c
+	return nil
+}
+
+// Default - Algorithm and cost of new hashes
+func Default() Config {
+	return defaultConfig
+}
+
+// normalize - Fill in defaults, and check the cost is in range for the algorithm
+func (c Config) normalize() (Config, error) {
+	min, max, def := 0, 0, 0
+	switch c.Algorithm {
+	case "", Bcrypt:
+		c.Algorithm = Bcrypt
+		min, max, def = bcrypt.MinCost, bcrypt.MaxCost, bcrypt.DefaultCost
+	case Argon2id:
+		min, max, def = 1, argon2MaxTime, argon2DefaultTime
+	case Scrypt:
+		min, max, def = scryptMinLogN, scryptMaxLogN, scryptDefaultLogN
+	default:
+		return c, fmt.Errorf("Password hash algorithm: %s not supported, use one of: %s", c.Algorithm, strings.Join(Algorithms, ", "))
+	}
+	if c.Cost == 0 {
+		c.Cost = def
+	}
+	if c.Cost < min || c.Cost > max {
+		return c, fmt.Errorf("Password hash cost: %d out of range for %s, must be %d-%d", c.Cost, c.Algorithm, min, max)
+	}
+	return c, nil
+}
+
+// HashAndSalt - Hash and salt password with the default algorithm
 func HashAndSalt(pwd string) (string, error) {
-	// Use GenerateFromPassword to hash & salt pwd.
-	// MinCost is just an integer constant provided by the bcrypt
-	// package along with DefaultCost & MaxCost.
-	// The cost can be any value you want provided it isn't lower
-	// than the MinCost (4)
 	if len(pwd) < pwdMinLen {
 		return "", fmt.Errorf("Password provided needs to be at leat %d characters long", pwdMinLen)
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.DefaultCost)
+	return Hash(pwd, defaultConfig)
+}
+
+// Hash - Hash and salt password with the given algorithm and cost
+func Hash(pwd string, c Config) (string, error) {
+	c, err := c.normalize()
+	if err != nil {
+		return "", err
+	}
+	switch c.Algorithm {
+	case Argon2id:
+		return hashArgon2id(pwd, c.Cost)
+	case Scrypt:
+		return hashScrypt(pwd, c.Cost)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pwd), c.Cost)
 	if err != nil {
 		return "", err
 	}
 	return string(hash), nil
 }
 
-// ComparePasswords - Validate password and hash
+// ComparePasswords - Validate password and hash. The algorithm is given by the hash prefix,
+// $argon2id$ and $scrypt$ PHC strings, or bcrypt
 func ComparePasswords(plainPwd, hashedPwd string) error {
+	switch {
+	case strings.HasPrefix(hashedPwd, "$"+Argon2id+"$"):
+		return compareArgon2id(plainPwd, hashedPwd)
+	case strings.HasPrefix(hashedPwd, "$"+Scrypt+"$"):
+		return compareScrypt(plainPwd, hashedPwd)
+	}
 	return bcrypt.CompareHashAndPassword([]byte(hashedPwd), []byte(plainPwd))
 }
+
+// NeedsRehash - True if the hash does not use the default algorithm and parameters.
+// Hashes that can not be parsed can not be upgraded, and give false
+func NeedsRehash(hashedPwd string) bool {
+	c, ok := hashConfig(hashedPwd)
+	return ok && c != defaultConfig
+}
+
+// hashConfig - Algorithm and cost of a hash. Hashes with other parameters than this
+// package generates are given cost -1, so they are always upgraded
+func hashConfig(hashedPwd string) (Config, bool) {
+	switch {
+	case strings.HasPrefix(hashedPwd, "$"+Argon2id+"$"):
+		p, err := parseArgon2id(hashedPwd)
+		if err != nil {
+			return Config{}, false
+		}
+		if p.memory != argon2Memory || p.threads != argon2Threads || len(p.hash) != hashLen {
+			return Config{Algorithm: Argon2id, Cost: -1}, true
+		}
+		return Config{Algorithm: Argon2id, Cost: int(p.time)}, true
+	case strings.HasPrefix(hashedPwd, "$"+Scrypt+"$"):
+		p, err := parseScrypt(hashedPwd)
+		if err != nil {
+			return Config{}, false
+		}
+		if p.r != scryptR || p.p != scryptP || len(p.hash) != hashLen {
+			return Config{Algorithm: Scrypt, Cost: -1}, true
+		}
+		return Config{Algorithm: Scrypt, Cost: p.logN}, true
+	}
+	cost, err := bcrypt.Cost([]byte(hashedPwd))
+	if err != nil {
+		return Config{}, false
+	}
+	return Config{Algorithm: Bcrypt, Cost: cost}, true
+}
@@ -1,6 +1,7 @@
 package passwd
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/jafossum/go-auth-server/utils/logger"
@@ -60,3 +61,127 @@ func TestComparePasswords(t *testing.T) {
 		})
 	}
 }
+
+// Written by Python hashlib.scrypt, for compatibility with other implementations
+const scryptMagickHash = "$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$NhMCJlv20wDdWjyUjtu+j34fJdJQgNxLhKtRQjaLUFQ"
+
+func TestHashAlgorithms(t *testing.T) {
+	var testResp = []struct {
+		config Config // input
+		prefix string // expected hash prefix
+	}{
+		{Config{Algorithm: Bcrypt, Cost: 4}, "$2a$04$"},
+		{Config{Algorithm: Argon2id, Cost: 1}, "$argon2id$v=19$m=65536,t=1,p=2$"},
+		{Config{Algorithm: Scrypt, Cost: 10}, "$scrypt$ln=10,r=8,p=1$"},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.config.Algorithm, func(t *testing.T) {
+			t.Parallel()
+			hash, err := Hash("SomeOtheerPassWdThing", tc.config)
+			if err != nil {
+				t.Fatalf("Hash(%v), Got uinexpected error: %v", tc.config, err)
+			}
+			if !strings.HasPrefix(hash, tc.prefix) {
+				t.Errorf("Hash(%v), Expected prefix: %s, Got: %s", tc.config, tc.prefix, hash)
+			}
+			if err := ComparePasswords("SomeOtheerPassWdThing", hash); err != nil {
+				t.Errorf("ComparePasswords(%s), Got uinexpected error: %v", hash, err)
+			}
+			if err := ComparePasswords("SomeOtheerPassWdThinG", hash); err == nil {
+				t.Errorf("ComparePasswords(%s), Expected error for wrong password", hash)
+			}
+			again, _ := Hash("SomeOtheerPassWdThing", tc.config)
+			if again == hash {
+				t.Errorf("Hash(%v), Expected a new salt for every hash", tc.config)
+			}
+		})
+	}
+}
+
+func TestComparePasswordsPHC(t *testing.T) {
+	var testResp = []struct {
+		passwd string // input
+		hash   string // hashed passwd
+		err    bool   // expect error
+	}{
+		{"MagickHash", scryptMagickHash, false},
+		{"MagickHasH", scryptMagickHash, true},
+		{"MagickHash", "$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg", true},
+		{"MagickHash", "$scrypt$ln=99,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$NhMCJlv20wDdWjyUjtu+j34fJdJQgNxLhKtRQjaLUFQ", true},
+		{"MagickHash", "$argon2id$v=19$m=65536,t=1,p=2$not*base64$NhMCJlv20wDdWjyUjtu+j34fJdJQgNxLhKtRQjaLUFQ", true},
+		{"MagickHash", "$argon2id$v=16$m=65536,t=1,p=2$MDEyMzQ1Njc4OWFiY2RlZg$NhMCJlv20wDdWjyUjtu+j34fJdJQgNxLhKtRQjaLUFQ", true},
+		{"MagickHash", "$argon2id$v=19$m=65536,t=0,p=2$MDEyMzQ1Njc4OWFiY2RlZg$NhMCJlv20wDdWjyUjtu+j34fJdJQgNxLhKtRQjaLUFQ", true},
+	}
+	for _, tc := range testResp {
+		err := ComparePasswords(tc.passwd, tc.hash)
+		if err == nil && tc.err {
+			t.Errorf("ComparePasswords(%s, %s), Not getting expected error", tc.passwd, tc.hash)
+		}
+		if err != nil && !tc.err {
+			t.Errorf("ComparePasswords(%s, %s), Got uinexpected error: %v", tc.passwd, tc.hash, err)
+		}
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	defer SetDefault(Config{})
+
+	var testResp = []struct {
+		config Config // input
+		exp    Config // expected default
+		err    bool   // expect error
+	}{
+		{Config{}, Config{Algorithm: Bcrypt, Cost: 10}, false},
+		{Config{Algorithm: Argon2id}, Config{Algorithm: Argon2id, Cost: 3}, false},
+		{Config{Algorithm: Scrypt, Cost: 16}, Config{Algorithm: Scrypt, Cost: 16}, false},
+		{Config{Algorithm: "md5"}, Config{}, true},
+		{Config{Algorithm: Bcrypt, Cost: 3}, Config{}, true},
+		{Config{Algorithm: Scrypt, Cost: 30}, Config{}, true},
+		{Config{Algorithm: Argon2id, Cost: -1}, Config{}, true},
+	}
+	for _, tc := range testResp {
+		err := SetDefault(tc.config)
+		if err == nil && tc.err {
+			t.Errorf("SetDefault(%v), Not getting expected error", tc.config)
+		}
+		if err != nil && !tc.err {
+			t.Errorf("SetDefault(%v), Got uinexpected error: %v", tc.config, err)
+		}
+		if err == nil && Default() != tc.exp {
+			t.Errorf("SetDefault(%v), Expected: %v, Got: %v", tc.config, tc.exp, Default())
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defer SetDefault(Config{})
+
+	bcrypt10 := "$2a$10$B3Fu0P.r0KRmW4YIx22OAO1opL95XyjpHQF4MbFnVgcpS.BpQGpuS"
+	argon1, _ := Hash("Passwd", Config{Algorithm: Argon2id, Cost: 1})
+	var testResp = []struct {
+		config Config // default
+		hash   string // input
+		exp    bool   // expected result
+	}{
+		{Config{}, bcrypt10, false},
+		{Config{Algorithm: Bcrypt, Cost: 12}, bcrypt10, true},
+		{Config{Algorithm: Argon2id, Cost: 1}, bcrypt10, true},
+		{Config{Algorithm: Argon2id, Cost: 1}, argon1, false},
+		{Config{Algorithm: Argon2id, Cost: 2}, argon1, true},
+		{Config{Algorithm: Scrypt, Cost: 10}, scryptMagickHash, false},
+		{Config{Algorithm: Scrypt, Cost: 15}, scryptMagickHash, true},
+		// Other parameters than generated by this package
+		{Config{Algorithm: Argon2id, Cost: 1}, strings.Replace(argon1, "p=2", "p=4", 1), true},
+		// Can not be parsed, and can not be upgraded
+		{Config{Algorithm: Argon2id, Cost: 1}, "not a hash", false},
+	}
+	for _, tc := range testResp {
+		if err := SetDefault(tc.config); err != nil {
+			t.Fatalf("Got uinexpected error: %v", err)
+		}
+		if res := NeedsRehash(tc.hash); res != tc.exp {
+			t.Errorf("NeedsRehash(%s) with %v, Expected: %v, Got: %v", tc.hash, tc.config, tc.exp, res)
+		}
+	}
+}
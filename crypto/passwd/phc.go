@@ -0,0 +1,164 @@
+package passwd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PHC string format hashes (https://github.com/P-H-C/phc-string-format), as written by
+// the reference argon2 implementation and passlib:
+//   $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//   $scrypt$ln=15,r=8,p=1$<salt>$<hash>
+// Salt and hash are base64 without padding
+
+const (
+	saltLen = 16
+	hashLen = 32
+
+	// argon2id - RFC 9106 second recommended option, 64 MiB memory
+	argon2Memory      = 64 * 1024
+	argon2Threads     = 2
+	argon2DefaultTime = 3
+	argon2MaxTime     = 32
+
+	// scrypt - N = 2^ln, 128 * r * N bytes of memory. The default uses 32 MiB
+	scryptR           = 8
+	scryptP           = 1
+	scryptMinLogN     = 10
+	scryptDefaultLogN = 15
+	scryptMaxLogN     = 20
+)
+
+var errFormat = errors.New("Password hash format not valid")
+
+var phcEncoding = base64.RawStdEncoding
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+type scryptParams struct {
+	logN int
+	r    int
+	p    int
+	salt []byte
+	hash []byte
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func hashArgon2id(pwd string, time int) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(pwd), salt, uint32(time), argon2Memory, argon2Threads, hashLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, argon2Memory, time, argon2Threads,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(hash)), nil
+}
+
+func parseArgon2id(hashedPwd string) (*argon2Params, error) {
+	parts := strings.Split(hashedPwd, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return nil, errFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("Argon2 version: %s not supported", parts[2])
+	}
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, errFormat
+	}
+	if p.time == 0 || p.threads == 0 || p.memory < 8*uint32(p.threads) {
+		return nil, errFormat
+	}
+	var err error
+	if p.salt, err = phcEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errFormat
+	}
+	if p.hash, err = phcEncoding.DecodeString(parts[5]); err != nil || len(p.hash) == 0 {
+		return nil, errFormat
+	}
+	return p, nil
+}
+
+func compareArgon2id(plainPwd, hashedPwd string) error {
+	p, err := parseArgon2id(hashedPwd)
+	if err != nil {
+		return err
+	}
+	hash := argon2.IDKey([]byte(plainPwd), p.salt, p.time, p.memory, p.threads, uint32(len(p.hash)))
+	if subtle.ConstantTimeCompare(hash, p.hash) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func hashScrypt(pwd string, logN int) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	hash, err := scrypt.Key([]byte(pwd), salt, 1<<uint(logN), scryptR, scryptP, hashLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", Scrypt, logN, scryptR, scryptP,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(hash)), nil
+}
+
+func parseScrypt(hashedPwd string) (*scryptParams, error) {
+	parts := strings.Split(hashedPwd, "$")
+	if len(parts) != 5 || parts[1] != Scrypt {
+		return nil, errFormat
+	}
+	p := &scryptParams{}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &p.logN, &p.r, &p.p); err != nil {
+		return nil, errFormat
+	}
+	// Larger values would use more memory and time than a login should be allowed to
+	if p.logN < 1 || p.logN > scryptMaxLogN || p.r < 1 || p.r > 32 || p.p < 1 || p.p > 16 {
+		return nil, errFormat
+	}
+	var err error
+	if p.salt, err = phcEncoding.DecodeString(parts[3]); err != nil {
+		return nil, errFormat
+	}
+	if p.hash, err = phcEncoding.DecodeString(parts[4]); err != nil || len(p.hash) == 0 {
+		return nil, errFormat
+	}
+	return p, nil
+}
+
+func compareScrypt(plainPwd, hashedPwd string) error {
+	p, err := parseScrypt(hashedPwd)
+	if err != nil {
+		return err
+	}
+	hash, err := scrypt.Key([]byte(plainPwd), p.salt, 1<<uint(p.logN), p.r, p.p, len(p.hash))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(hash, p.hash) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
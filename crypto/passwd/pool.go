@@ -0,0 +1,206 @@
+package passwd
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrBusy - The verification queue is full
+	ErrBusy = errors.New("Password verification queue is full")
+	// ErrTimeout - No worker was free within the queue timeout
+	ErrTimeout = errors.New("Password verification timed out in the queue")
+)
+
+// Defaults of PoolConfig
+const (
+	defaultPoolQueue   = 64
+	defaultPoolTimeout = 2 * time.Second
+)
+
+// DurationBuckets - Upper bounds of the verification latency histogram
+var DurationBuckets = []time.Duration{
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond,
+	250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+// PoolConfig - Size of the verification pool. Zero values are defaults
+type PoolConfig struct {
+	// Workers - Verifications running at the same time. Defaults to all CPUs but one
+	Workers int
+	// Queue - Verifications waiting for a worker. Further verifications fail with ErrBusy
+	Queue int
+	// Timeout - Longest wait in the queue, before failing with ErrTimeout
+	Timeout time.Duration
+}
+
+// Stats - Pool metrics
+type Stats struct {
+	Workers    int
+	QueueLimit int
+	// QueueDepth - Verifications waiting for a worker
+	QueueDepth int64
+	// Active - Verifications running
+	Active int64
+	// Verified - Completed verifications, matching or not, and rehashes
+	Verified int64
+	Rejected int64
+	TimedOut int64
+	// WaitTime - Total time verifications waited in the queue
+	WaitTime time.Duration
+	// VerifyTime - Total time of completed verifications
+	VerifyTime time.Duration
+	// Buckets - Completed verifications no slower than each of DurationBuckets
+	Buckets []int64
+}
+
+// Pool - Runs password verifications and rehashes on a bounded number of workers, so a burst
+// of requests can not take every CPU
+type Pool struct {
+	workers int
+	timeout time.Duration
+	jobs    chan *job
+	compare func(plainPwd, hashedPwd string) error
+	hash    func(pwd string, c Config) (string, error)
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// job states
+const (
+	jobQueued int32 = iota
+	jobRunning
+	jobCancelled
+)
+
+type job struct {
+	run    func() error
+	queued time.Time
+	state  int32
+	done   chan error
+}
+
+// NewPool - Start a verification pool
+func NewPool(c PoolConfig) *Pool {
+	if c.Workers <= 0 {
+		c.Workers = runtime.NumCPU() - 1
+		if c.Workers < 1 {
+			c.Workers = 1
+		}
+	}
+	if c.Queue <= 0 {
+		c.Queue = defaultPoolQueue
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultPoolTimeout
+	}
+	p := &Pool{
+		workers: c.Workers,
+		timeout: c.Timeout,
+		jobs:    make(chan *job, c.Queue),
+		compare: ComparePasswords,
+		hash:    Hash,
+		stats:   Stats{Workers: c.Workers, QueueLimit: c.Queue, Buckets: make([]int64, len(DurationBuckets))},
+	}
+	for i := 0; i < c.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Compare - ComparePasswords on a pool worker. Fails with ErrBusy if the queue is full,
+// and ErrTimeout if no worker is free in time. A nil pool compares on the calling goroutine
+func (p *Pool) Compare(plainPwd, hashedPwd string) error {
+	if p == nil {
+		return ComparePasswords(plainPwd, hashedPwd)
+	}
+	return p.run(func() error { return p.compare(plainPwd, hashedPwd) })
+}
+
+// Hash - Hash on a pool worker. Fails with ErrBusy if the queue is full, and ErrTimeout
+// if no worker is free in time. A nil pool hashes on the calling goroutine
+func (p *Pool) Hash(pwd string, c Config) (string, error) {
+	if p == nil {
+		return Hash(pwd, c)
+	}
+	var hash string
+	err := p.run(func() error {
+		var err error
+		hash, err = p.hash(pwd, c)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// run - Queue f, and wait for a worker to run it
+func (p *Pool) run(f func() error) error {
+	j := &job{run: f, queued: time.Now(), done: make(chan error, 1)}
+	p.update(func(s *Stats) { s.QueueDepth++ })
+	select {
+	case p.jobs <- j:
+	default:
+		p.update(func(s *Stats) { s.QueueDepth--; s.Rejected++ })
+		return ErrBusy
+	}
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-j.done:
+		return err
+	case <-timer.C:
+		if atomic.CompareAndSwapInt32(&j.state, jobQueued, jobCancelled) {
+			p.update(func(s *Stats) { s.TimedOut++ })
+			return ErrTimeout
+		}
+		// Already running, and done soon
+		return <-j.done
+	}
+}
+
+// work - Run queued jobs. Cancelled jobs are skipped
+func (p *Pool) work() {
+	for j := range p.jobs {
+		wait := time.Since(j.queued)
+		p.update(func(s *Stats) { s.QueueDepth-- })
+		if !atomic.CompareAndSwapInt32(&j.state, jobQueued, jobRunning) {
+			continue
+		}
+		p.update(func(s *Stats) { s.Active++; s.WaitTime += wait })
+		start := time.Now()
+		err := j.run()
+		d := time.Since(start)
+		p.update(func(s *Stats) {
+			s.Active--
+			s.Verified++
+			s.VerifyTime += d
+			for i, b := range DurationBuckets {
+				if d <= b {
+					s.Buckets[i]++
+				}
+			}
+		})
+		j.done <- err
+	}
+}
+
+func (p *Pool) update(f func(s *Stats)) {
+	p.mu.Lock()
+	f(&p.stats)
+	p.mu.Unlock()
+}
+
+// Stats - Current metrics of the pool
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.Buckets = append([]int64(nil), p.stats.Buckets...)
+	return s
+}
@@ -0,0 +1,122 @@
+package passwd
+
+import (
+	"testing"
+	"time"
+)
+
+// waitStats - Poll the pool until cond is true for its stats
+func waitStats(t *testing.T, p *Pool, cond func(s Stats) bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond(p.Stats()) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Pool stats not as expected: %+v", p.Stats())
+}
+
+func TestPoolCompare(t *testing.T) {
+	p := NewPool(PoolConfig{Workers: 2})
+	var testResp = []struct {
+		passwd   string // input
+		hash     string // hashed passwd
+		expected bool   // expected error
+	}{
+		{"MagickHash", "$2a$10$EOmsDTSMWZK6/HqnsybxP.bQ9PFl8peMI65RwsjWkmHx/edkNkEFO", false},
+		{"WrongHash", "$2a$10$EOmsDTSMWZK6/HqnsybxP.bQ9PFl8peMI65RwsjWkmHx/edkNkEFO", true},
+		{"MagickHash", scryptMagickHash, false},
+	}
+	for _, tc := range testResp {
+		if err := p.Compare(tc.passwd, tc.hash); (err != nil) != tc.expected {
+			t.Errorf("Compare(%s, %s), Expected error: %v, Got: %v", tc.passwd, tc.hash, tc.expected, err)
+		}
+	}
+	s := p.Stats()
+	if s.Verified != 3 || s.QueueDepth != 0 || s.Active != 0 || s.Workers != 2 || s.QueueLimit != defaultPoolQueue {
+		t.Errorf("Stats not as expected: %+v", s)
+	}
+	if s.VerifyTime <= 0 || s.Buckets[len(s.Buckets)-1] != 3 {
+		t.Errorf("Latency not recorded: %+v", s)
+	}
+}
+
+func TestPoolOverload(t *testing.T) {
+	p := NewPool(PoolConfig{Workers: 1, Queue: 1, Timeout: 50 * time.Millisecond})
+	release := make(chan struct{})
+	p.compare = func(plainPwd, hashedPwd string) error {
+		<-release
+		return nil
+	}
+
+	running := make(chan error, 1)
+	go func() { running <- p.Compare("a", "b") }()
+	waitStats(t, p, func(s Stats) bool { return s.Active == 1 })
+	queued := make(chan error, 1)
+	go func() { queued <- p.Compare("a", "b") }()
+	waitStats(t, p, func(s Stats) bool { return s.QueueDepth == 1 })
+
+	if err := p.Compare("a", "b"); err != ErrBusy {
+		t.Errorf("Queue full, Expected: %v, Got: %v", ErrBusy, err)
+	}
+	if err := <-queued; err != ErrTimeout {
+		t.Errorf("Queue timeout, Expected: %v, Got: %v", ErrTimeout, err)
+	}
+	close(release)
+	if err := <-running; err != nil {
+		t.Errorf("Got uinexpected error: %v", err)
+	}
+	// The timed out verification is skipped by the worker
+	waitStats(t, p, func(s Stats) bool { return s.QueueDepth == 0 })
+	s := p.Stats()
+	if s.Verified != 1 || s.Rejected != 1 || s.TimedOut != 1 || s.Active != 0 {
+		t.Errorf("Stats not as expected: %+v", s)
+	}
+}
+
+func TestPoolHash(t *testing.T) {
+	p := NewPool(PoolConfig{Workers: 1, Queue: 1, Timeout: 50 * time.Millisecond})
+	hash, err := p.Hash("MagickHash", Config{Algorithm: Scrypt, Cost: scryptMinLogN})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if err := ComparePasswords("MagickHash", hash); err != nil {
+		t.Errorf("Hash does not match the password: %v", err)
+	}
+	if _, err := p.Hash("MagickHash", Config{Algorithm: "md5"}); err == nil {
+		t.Error("Expected error for unsupported algorithm")
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	p.compare = func(plainPwd, hashedPwd string) error {
+		<-release
+		return nil
+	}
+	go p.Compare("a", "b")
+	waitStats(t, p, func(s Stats) bool { return s.Active == 1 })
+	go p.Compare("a", "b")
+	waitStats(t, p, func(s Stats) bool { return s.QueueDepth == 1 })
+	if _, err := p.Hash("MagickHash", Config{}); err != ErrBusy {
+		t.Errorf("Queue full, Expected: %v, Got: %v", ErrBusy, err)
+	}
+}
+
+func TestPoolNil(t *testing.T) {
+	var p *Pool
+	hash := "$2a$10$EOmsDTSMWZK6/HqnsybxP.bQ9PFl8peMI65RwsjWkmHx/edkNkEFO"
+	if err := p.Compare("MagickHash", hash); err != nil {
+		t.Errorf("Without pool, Got uinexpected error: %v", err)
+	}
+	if err := p.Compare("WrongHash", hash); err == nil {
+		t.Error("Without pool, Expected error for wrong password")
+	}
+	newHash, err := p.Hash("MagickHash", Config{})
+	if err != nil {
+		t.Fatalf("Without pool, Got uinexpected error: %v", err)
+	}
+	if err := ComparePasswords("MagickHash", newHash); err != nil {
+		t.Errorf("Without pool, Got uinexpected error: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package pkcs8
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jafossum/go-auth-server/utils/logger"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encrypted PKCS#8 private keys (RFC 5958) with PBES2 (RFC 8018), as written by
+// `openssl pkcs8 -topk8 -v2 ...` and `openssl genpkey -aes256`
+
+// ErrDecryption - Wrong passphrase or corrupt key
+var ErrDecryption = errors.New("Private key decryption failed, wrong passphrase?")
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// IsEncrypted - True for encrypted PKCS#8 blocks and legacy encrypted PEM blocks (Proc-Type: 4,ENCRYPTED)
+func IsEncrypted(block *pem.Block) bool {
+	return block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block)
+}
+
+// KeyBytes - DER bytes of a private key PEM block, decrypted with the passphrase if the block is encrypted
+func KeyBytes(block *pem.Block, passphrase string) ([]byte, error) {
+	if !IsEncrypted(block) {
+		if passphrase != "" {
+			logger.Warning.Println("Private key is not encrypted, passphrase ignored")
+		}
+		return block.Bytes, nil
+	}
+	if passphrase == "" {
+		return nil, errors.New("Private key is encrypted, but no passphrase given")
+	}
+	return DecryptPEMBlock(block, []byte(passphrase))
+}
+
+// DecryptPEMBlock - Decrypt an encrypted PEM block with the passphrase. Returns the DER encoded key,
+// PKCS#8 for "ENCRYPTED PRIVATE KEY" blocks, and the format given by the block type for legacy blocks
+func DecryptPEMBlock(block *pem.Block, passphrase []byte) ([]byte, error) {
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return Decrypt(block.Bytes, passphrase)
+	}
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err := x509.DecryptPEMBlock(block, passphrase)
+		if err == x509.IncorrectPasswordError {
+			return nil, ErrDecryption
+		}
+		return der, err
+	}
+	return nil, fmt.Errorf("PEM block: %s is not encrypted", block.Type)
+}
+
+// Decrypt - Decrypt a DER encoded EncryptedPrivateKeyInfo. Returns the DER encoded PKCS#8 key
+func Decrypt(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("Encrypted private key could not be parsed: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("Encryption scheme: %v not supported, only PBES2", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("PBES2 parameters could not be parsed: %v", err)
+	}
+
+	block, keyLen, iv, err := blockCipher(params.EncryptionScheme)
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(params.KeyDerivationFunc, passphrase, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	c, err := block(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%c.BlockSize() != 0 || len(iv) != c.BlockSize() {
+		return nil, ErrDecryption
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(out, data)
+	out, err = unpad(out, c.BlockSize())
+	if err != nil {
+		return nil, err
+	}
+	// Padding can be valid by chance with a wrong passphrase. Make sure the result is a key
+	var check struct {
+		Version int
+		Algo    pkix.AlgorithmIdentifier
+		Key     []byte
+	}
+	if rest, err := asn1.Unmarshal(out, &check); err != nil || len(rest) != 0 {
+		return nil, ErrDecryption
+	}
+	return out, nil
+}
+
+// blockCipher - Cipher constructor, key length and IV of the encryption scheme
+func blockCipher(scheme pkix.AlgorithmIdentifier) (func([]byte) (cipher.Block, error), int, []byte, error) {
+	var iv []byte
+	if _, err := asn1.Unmarshal(scheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, 0, nil, fmt.Errorf("Cipher IV could not be parsed: %v", err)
+	}
+	switch {
+	case scheme.Algorithm.Equal(oidAES128CBC):
+		return aes.NewCipher, 16, iv, nil
+	case scheme.Algorithm.Equal(oidAES192CBC):
+		return aes.NewCipher, 24, iv, nil
+	case scheme.Algorithm.Equal(oidAES256CBC):
+		return aes.NewCipher, 32, iv, nil
+	case scheme.Algorithm.Equal(oidDESEDE3CBC):
+		return des.NewTripleDESCipher, 24, iv, nil
+	}
+	return nil, 0, nil, fmt.Errorf("Cipher: %v not supported", scheme.Algorithm)
+}
+
+// deriveKey - Derive the encryption key from the passphrase with PBKDF2
+func deriveKey(kdf pkix.AlgorithmIdentifier, passphrase []byte, keyLen int) ([]byte, error) {
+	if !kdf.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("Key derivation function: %v not supported, only PBKDF2", kdf.Algorithm)
+	}
+	var params pbkdf2Params
+	if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("PBKDF2 parameters could not be parsed: %v", err)
+	}
+	if params.KeyLength != 0 && params.KeyLength != keyLen {
+		return nil, fmt.Errorf("PBKDF2 key length: %d does not match cipher", params.KeyLength)
+	}
+	var h func() hash.Hash
+	switch prf := params.PRF.Algorithm; {
+	case len(prf) == 0 || prf.Equal(oidHMACWithSHA1):
+		h = sha1.New
+	case prf.Equal(oidHMACWithSHA256):
+		h = sha256.New
+	case prf.Equal(oidHMACWithSHA384):
+		h = sha512.New384
+	case prf.Equal(oidHMACWithSHA512):
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("PBKDF2 PRF: %v not supported", prf)
+	}
+	return pbkdf2.Key(passphrase, params.Salt, params.IterationCount, keyLen, h), nil
+}
+
+// unpad - Remove PKCS#7 padding
+func unpad(b []byte, blockSize int) ([]byte, error) {
+	n := int(b[len(b)-1])
+	if n == 0 || n > blockSize || n > len(b) {
+		return nil, ErrDecryption
+	}
+	for _, p := range b[len(b)-n:] {
+		if int(p) != n {
+			return nil, ErrDecryption
+		}
+	}
+	return b[:len(b)-n], nil
+}
+
+// ReadPassphrase - Read a passphrase from the first line of a file, or of stdin if path is "-"
+func ReadPassphrase(path string, stdin io.Reader) (string, error) {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("Passphrase file could not be read: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("Passphrase could not be read: %v", err)
+	}
+	pass := strings.TrimRight(line, "\r\n")
+	if pass == "" {
+		return "", errors.New("Passphrase is empty")
+	}
+	return pass, nil
+}
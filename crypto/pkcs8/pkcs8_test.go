@@ -0,0 +1,132 @@
+package pkcs8
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// Important to not get nullpointer on logger!
+func init() {
+	logger.TestInit()
+}
+
+func readBlock(t *testing.T, path string) *pem.Block {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatalf("No PEM block in: %s", path)
+	}
+	return block
+}
+
+func TestKeyBytes(t *testing.T) {
+	plain := readBlock(t, "../../test-resources/private.pem")
+	exp, _ := x509.ParsePKCS1PrivateKey(plain.Bytes)
+
+	var testResp = []struct {
+		file string // encrypted test key
+		pass string // input
+		err  bool   // expect error
+	}{
+		{"private.pem", "", false},
+		{"private.pem", "testpass", false},
+		{"private_pkcs8_aes256.pem", "testpass", false},
+		{"private_pkcs8_aes128_sha1.pem", "testpass", false},
+		{"private_pkcs8_des3.pem", "testpass", false},
+		{"private_legacy_aes256.pem", "testpass", false},
+		{"private_pkcs8_aes256.pem", "wrongpass", true},
+		{"private_pkcs8_des3.pem", "wrongpass", true},
+		{"private_legacy_aes256.pem", "wrongpass", true},
+		{"private_pkcs8_aes256.pem", "", true},
+		{"private_legacy_aes256.pem", "", true},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.file+"/"+tc.pass, func(t *testing.T) {
+			block := readBlock(t, "../../test-resources/"+tc.file)
+			der, err := KeyBytes(block, tc.pass)
+			if err == nil && tc.err {
+				t.Fatal("Not getting expected error")
+			}
+			if err != nil && !tc.err {
+				t.Fatalf("Error not expected error; %v", err)
+			}
+			if err != nil {
+				return
+			}
+			var key interface{}
+			if key, err = x509.ParsePKCS1PrivateKey(der); err != nil {
+				if key, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+					t.Fatalf("Decrypted key could not be parsed: %v", err)
+				}
+			}
+			if rsaKey, ok := key.(*rsa.PrivateKey); !ok || rsaKey.N.Cmp(exp.N) != 0 {
+				t.Error("Expected key not decrypted")
+			}
+		})
+	}
+}
+
+func TestDecryptCorrupt(t *testing.T) {
+	block := readBlock(t, "../../test-resources/private_pkcs8_aes256.pem")
+	// Flip a bit in the last cipher block, which holds the padding
+	der := append([]byte{}, block.Bytes...)
+	der[len(der)-1] ^= 0x01
+	if _, err := Decrypt(der, []byte("testpass")); err == nil {
+		t.Error("Expected error decrypting corrupt key")
+	}
+	if _, err := Decrypt([]byte("garbage"), []byte("testpass")); err == nil {
+		t.Error("Expected error decrypting garbage")
+	}
+}
+
+func TestReadPassphrase(t *testing.T) {
+	f, err := ioutil.TempFile("", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("file pass\nsecond line\n")
+	f.Close()
+
+	var testResp = []struct {
+		name  string // test name
+		path  string // input
+		stdin string // input
+		exp   string // expected result
+		err   bool   // expect error
+	}{
+		{"file", f.Name(), "", "file pass", false},
+		{"stdin", "-", "stdin pass\n", "stdin pass", false},
+		{"stdin crlf", "-", "stdin pass\r\n", "stdin pass", false},
+		{"stdin no newline", "-", "stdin pass", "stdin pass", false},
+		{"stdin empty", "-", "", "", true},
+		{"missing file", f.Name() + ".missing", "", "", true},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := ReadPassphrase(tc.path, strings.NewReader(tc.stdin))
+			if err == nil && tc.err {
+				t.Error("Not getting expected error")
+			}
+			if err != nil && !tc.err {
+				t.Errorf("Error not expected error; %v", err)
+			}
+			if res != tc.exp {
+				t.Errorf("Expected: %q, Got: %q", tc.exp, res)
+			}
+		})
+	}
+}
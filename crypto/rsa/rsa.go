@@ -6,11 +6,13 @@ import (
 	"crypto/sha1"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"strings"
 
 	"github.com/jafossum/go-auth-server/crypto/base64"
+	"github.com/jafossum/go-auth-server/crypto/pkcs8"
 	"github.com/jafossum/go-auth-server/utils/logger"
 )
 
@@ -38,61 +40,109 @@ func GetPublicKey(key *rsa.PublicKey) (string, error) {
 // RSA handling inspired by this GIST - Modified to work :)
 // https://gist.github.com/jshap70/259a87a7146393aab5819873a193b88c
 
-// ParseRsaKeys - Parse keys and validate, or generate a temporary pair
+// errDecrypt - Decryption errors are never recovered by generating a temporary key
+var errDecrypt = errors.New("could not be decrypted")
+
+// ParseRsaKeys - Parse keys and validate, or generate a temporary pair. Only for development,
+// use LoadRsaKeys to fail on any key problem. An encrypted private key that can not be decrypted is always an error
 func ParseRsaKeys(rsaPrivKey, rsaPrivPass, rsaPubKey string) (*rsa.PrivateKey, error) {
 	if rsaPrivKey == "" {
 		logger.Warning.Println("No RSA Key given, generating temp one")
 		return genRsaKey()
 	}
-	priv, err := ioutil.ReadFile(rsaPrivKey)
+	privateKey, err := LoadRsaKeys(rsaPrivKey, rsaPrivPass, rsaPubKey)
+	if errors.Is(err, errDecrypt) {
+		return nil, err
+	}
 	if err != nil {
-		logger.Warning.Println("No RSA private key found, generating temp one", nil)
+		logger.Error.Println(err)
+		logger.Warning.Println("Generating a temp RSA key")
 		return genRsaKey()
 	}
+	return privateKey, nil
+}
+
+// LoadRsaKeys - Parse and validate keys. Any problem is an error. The public key is optional,
+// but must match the private key if given
+func LoadRsaKeys(rsaPrivKey, rsaPrivPass, rsaPubKey string) (*rsa.PrivateKey, error) {
+	if rsaPrivKey == "" {
+		return nil, errors.New("No RSA private key given")
+	}
+	priv, err := ioutil.ReadFile(rsaPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("RSA private key: %s could not be read: %v", rsaPrivKey, err)
+	}
 	privPem, _ := pem.Decode(priv)
+	if privPem == nil {
+		return nil, fmt.Errorf("RSA private key: %s is not in pem format", rsaPrivKey)
+	}
 	if !strings.Contains(privPem.Type, "PRIVATE KEY") {
-		logger.Warning.Println("RSA private key is of the wrong type: ", privPem.Type)
+		return nil, fmt.Errorf("RSA private key: %s is of the wrong type: %s", rsaPrivKey, privPem.Type)
+	}
+	der, err := pkcs8.KeyBytes(privPem, rsaPrivPass)
+	if err != nil {
+		return nil, fmt.Errorf("RSA private key: %s %w: %v", rsaPrivKey, errDecrypt, err)
 	}
 	var parsedKey interface{}
-	if parsedKey, err = x509.ParsePKCS1PrivateKey(privPem.Bytes); err != nil {
-		if parsedKey, err = x509.ParsePKCS8PrivateKey(privPem.Bytes); err != nil { // note this returns type `interface{}`
-			logger.Error.Println("Unable to parse RSA private key, generating a temp one", err)
-			return genRsaKey()
+	if parsedKey, err = x509.ParsePKCS1PrivateKey(der); err != nil {
+		if parsedKey, err = x509.ParsePKCS8PrivateKey(der); err != nil { // note this returns type `interface{}`
+			return nil, fmt.Errorf("Unable to parse RSA private key: %s: %v", rsaPrivKey, err)
 		}
 	}
 	privateKey, ok := parsedKey.(*rsa.PrivateKey)
 	if !ok {
-		logger.Error.Println("Unable to parse RSA private key, generating a temp one", err)
-		return genRsaKey()
+		return nil, fmt.Errorf("Private key: %s is a %T, not an RSA key", rsaPrivKey, parsedKey)
+	}
+	if err := privateKey.Validate(); err != nil {
+		return nil, fmt.Errorf("RSA private key: %s is not valid: %v", rsaPrivKey, err)
 	}
 
+	if rsaPubKey == "" {
+		return privateKey, nil
+	}
+	pubKey, err := parseRsaPublicKey(rsaPubKey)
+	if err != nil {
+		return nil, err
+	}
+	if pubKey.N.Cmp(privateKey.N) != 0 || pubKey.E != privateKey.E {
+		return nil, fmt.Errorf("RSA public key: %s does not match private key: %s", rsaPubKey, rsaPrivKey)
+	}
+	return privateKey, nil
+}
+
+// parseRsaPublicKey - Read a PKIX or PKCS#1 PEM encoded RSA public key
+func parseRsaPublicKey(rsaPubKey string) (*rsa.PublicKey, error) {
 	pub, err := ioutil.ReadFile(rsaPubKey)
 	if err != nil {
-		logger.Warning.Println("No RSA public key found, generating temp one", nil)
-		return genRsaKey()
+		return nil, fmt.Errorf("RSA public key: %s could not be read: %v", rsaPubKey, err)
 	}
 	pubPem, _ := pem.Decode(pub)
 	if pubPem == nil {
-		logger.Error.Println("Use `ssh-keygen -f id_rsa.pub -e -m pem > id_rsa.pem` to generate the pem encoding of your RSA public key",
-			fmt.Errorf("RSA public key not in pem format: %s", rsaPubKey))
-		return genRsaKey()
+		return nil, fmt.Errorf("RSA public key not in pem format: %s. "+
+			"Use `ssh-keygen -f id_rsa.pub -e -m pem > id_rsa.pem` to generate the pem encoding of your RSA public key", rsaPubKey)
 	}
-	if !strings.Contains(pubPem.Type, "PUBLIC KEY") {
-		logger.Warning.Println("RSA public key is of the wrong type: ", pubPem.Type)
-		return genRsaKey()
+	var parsedKey interface{}
+	switch pubPem.Type {
+	case "PUBLIC KEY":
+		parsedKey, err = x509.ParsePKIXPublicKey(pubPem.Bytes)
+	case "RSA PUBLIC KEY":
+		parsedKey, err = x509.ParsePKCS1PublicKey(pubPem.Bytes)
+	default:
+		return nil, fmt.Errorf("RSA public key: %s is of the wrong type: %s", rsaPubKey, pubPem.Type)
 	}
-	if parsedKey, err = x509.ParsePKIXPublicKey(pubPem.Bytes); err != nil {
-		logger.Error.Println("Unable to parse RSA public key, generating a temp one", err)
-		return genRsaKey()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse RSA public key: %s: %v", rsaPubKey, err)
 	}
 	pubKey, ok := parsedKey.(*rsa.PublicKey)
 	if !ok {
-		logger.Error.Println("Unable to parse RSA public key, generating a temp one", err)
-		return genRsaKey()
+		return nil, fmt.Errorf("Public key: %s is a %T, not an RSA key", rsaPubKey, parsedKey)
 	}
+	return pubKey, nil
+}
 
-	privateKey.PublicKey = *pubKey
-	return privateKey, nil
+// GenerateRsaKey - Generate a new RSA KeyPair
+func GenerateRsaKey() (*rsa.PrivateKey, error) {
+	return genRsaKey()
 }
 
 // genRsaKey - Generate a RSA KeyPair
@@ -52,3 +52,91 @@ func TestGetPublicKey(t *testing.T) {
 		t.Errorf("GetPublicKey(KEY): expected %v, actual %v", exp, res)
 	}
 }
+
+func TestParseRsaKeysEncrypted(t *testing.T) {
+	exp, _ := ParseRsaKeys("../../test-resources/private.pem", "", "../../test-resources/public.pem")
+	var testResp = []struct {
+		file string // private key
+		pass string // input
+		err  bool   // expect error
+	}{
+		{"private_pkcs8_aes256.pem", "testpass", false},
+		{"private_legacy_aes256.pem", "testpass", false},
+		{"private_pkcs8_aes256.pem", "wrongpass", true},
+		{"private_legacy_aes256.pem", "wrongpass", true},
+		{"private_pkcs8_aes256.pem", "", true},
+	}
+	for _, tc := range testResp {
+		key, err := ParseRsaKeys("../../test-resources/"+tc.file, tc.pass, "../../test-resources/public.pem")
+		if err == nil && tc.err {
+			t.Errorf("ParseRsaKeys(%s, %s), Not getting expected error", tc.file, tc.pass)
+		}
+		if err != nil && !tc.err {
+			t.Errorf("ParseRsaKeys(%s, %s), Error not expected error; %v", tc.file, tc.pass, err)
+		}
+		// A failed decryption must never fall back to a generated key
+		if tc.err && key != nil {
+			t.Errorf("ParseRsaKeys(%s, %s), Expected no key", tc.file, tc.pass)
+		}
+		if !tc.err && err == nil && key.N.Cmp(exp.N) != 0 {
+			t.Errorf("ParseRsaKeys(%s, %s), Expected key not loaded", tc.file, tc.pass)
+		}
+	}
+}
+
+func TestLoadRsaKeys(t *testing.T) {
+	exp, _ := ParseRsaKeys("../../test-resources/private.pem", "", "../../test-resources/public.pem")
+	var testResp = []struct {
+		name string // test name
+		priv string // input
+		pub  string // input
+		err  bool   // expect error
+	}{
+		{"valid", "private.pem", "public.pem", false},
+		{"no public key", "private.pem", "", false},
+		{"pkcs1 public key", "private.pem", "public_pkcs1.pem", false},
+		{"no private key", "", "public.pem", true},
+		{"missing private key", "missing.pem", "public.pem", true},
+		{"private key not pem", "README.md", "public.pem", true},
+		{"public key as private key", "public.pem", "public.pem", true},
+		{"missing public key", "private.pem", "missing.pem", true},
+		{"public key not pem", "private.pem", "README.md", true},
+		{"private key as public key", "private.pem", "private.pem", true},
+		{"public key does not match", "private.pem", "other_public.pem", true},
+		{"ec private key", "ec_pkcs8_aes256.pem", "", true},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			priv, pub := tc.priv, tc.pub
+			if priv != "" {
+				priv = "../../test-resources/" + priv
+			}
+			if pub != "" {
+				pub = "../../test-resources/" + pub
+			}
+			key, err := LoadRsaKeys(priv, "testpass", pub)
+			if err == nil && tc.err {
+				t.Error("Not getting expected error")
+			}
+			if err != nil && !tc.err {
+				t.Errorf("Error not expected error; %v", err)
+			}
+			if err == nil && key.N.Cmp(exp.N) != 0 {
+				t.Error("Expected key not loaded")
+			}
+		})
+	}
+}
+
+func TestParseRsaKeysFallback(t *testing.T) {
+	exp, _ := ParseRsaKeys("../../test-resources/private.pem", "", "../../test-resources/public.pem")
+	// Development mode generates a temporary key instead of failing
+	key, err := ParseRsaKeys("../../test-resources/private.pem", "", "../../test-resources/other_public.pem")
+	if err != nil || key == nil {
+		t.Fatalf("Expected a key, Got error: %v", err)
+	}
+	if key.N.Cmp(exp.N) == 0 {
+		t.Error("Expected a temporary key when the public key does not match")
+	}
+}
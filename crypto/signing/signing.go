@@ -0,0 +1,151 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/base64"
+	"github.com/jafossum/go-auth-server/crypto/pkcs8"
+	rsaa "github.com/jafossum/go-auth-server/crypto/rsa"
+)
+
+// Supported JWS signing algorithms
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	ES384 = "ES384"
+	EdDSA = "EdDSA"
+)
+
+// Algorithms - Supported signing algorithms
+var Algorithms = []string{RS256, ES256, ES384, EdDSA}
+
+// Method - The jwt signing method for alg
+func Method(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case RS256:
+		return jwt.SigningMethodRS256, nil
+	case ES256:
+		return jwt.SigningMethodES256, nil
+	case ES384:
+		return jwt.SigningMethodES384, nil
+	case EdDSA:
+		return SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("Signing algorithm: %s not supported", alg)
+}
+
+// Algorithm - The signing algorithm used with a public key. The EC curve selects the algorithm
+func Algorithm(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return RS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return ES256, nil
+		case elliptic.P384():
+			return ES384, nil
+		}
+		return "", fmt.Errorf("EC curve: %s not supported", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return EdDSA, nil
+	}
+	return "", fmt.Errorf("Key type: %T not supported", pub)
+}
+
+// GenerateKey - Generate a new key for alg
+func GenerateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case RS256:
+		return rsaa.GenerateRsaKey()
+	case ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ES384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case EdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+	return nil, fmt.Errorf("Signing algorithm: %s not supported", alg)
+}
+
+// Thumbprint - Base64 encoded SHA-1 of the PKIX encoded public key. Used as kid.
+// Gives the same value as rsa.GetSha1Thumbprint for RSA keys
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("Thumbprint error %v", err)
+	}
+	sum := sha1.Sum(der)
+	return base64.EncodeToString(sum[:]), nil
+}
+
+// ParsePrivateKey - Read a PEM encoded PKCS#1, PKCS#8 or SEC 1 (EC) private key.
+// Encrypted keys are decrypted with pass
+func ParsePrivateKey(path, pass string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Private key: %s could not be read: %v", path, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("Private key not in pem format: %s", path)
+	}
+	der, err := pkcs8.KeyBytes(block, pass)
+	if err != nil {
+		return nil, fmt.Errorf("Private key: %s could not be decrypted: %v", path, err)
+	}
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("Private key: %s is of the wrong type: %s", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse private key: %s: %v", path, err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Private key: %s of type %T can not be used for signing", path, parsed)
+	}
+	if _, err := Algorithm(key.Public()); err != nil {
+		return nil, fmt.Errorf("Private key: %s: %v", path, err)
+	}
+	return key, nil
+}
+
+// ParsePublicKey - Read a PEM encoded PKIX public key
+func ParsePublicKey(path string) (crypto.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Public key: %s could not be read: %v", path, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("Public key not in pem format: %s", path)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse public key: %s: %v", path, err)
+	}
+	if _, err := Algorithm(pub); err != nil {
+		return nil, fmt.Errorf("Public key: %s: %v", path, err)
+	}
+	return pub, nil
+}
@@ -0,0 +1,181 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	rsaa "github.com/jafossum/go-auth-server/crypto/rsa"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// Important to not get nullpointer on logger!
+func init() {
+	logger.TestInit()
+}
+
+// writePem - Write a PEM block to a file in dir
+func writePem(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, alg := range []string{ES256, ES384, EdDSA} {
+		alg := alg // rebind
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateKey(alg)
+			if err != nil {
+				t.Fatalf("GenerateKey(%s), Unexpected error: %v", alg, err)
+			}
+			res, err := Algorithm(key.Public())
+			if err != nil || res != alg {
+				t.Fatalf("Algorithm(), Expected: %v, Got: %v, %v", alg, res, err)
+			}
+			method, err := Method(alg)
+			if err != nil {
+				t.Fatalf("Method(%s), Unexpected error: %v", alg, err)
+			}
+
+			s, err := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "test"}).SignedString(key)
+			if err != nil {
+				t.Fatalf("Sign, Unexpected error: %v", err)
+			}
+			token, err := jwt.Parse(s, func(*jwt.Token) (interface{}, error) { return key.Public(), nil })
+			if err != nil || !token.Valid {
+				t.Errorf("Verify, Unexpected error: %v", err)
+			}
+			if token.Header["alg"] != alg {
+				t.Errorf("alg header, Expected: %v, Got: %v", alg, token.Header["alg"])
+			}
+
+			// Signature from another key of the same type
+			other, _ := GenerateKey(alg)
+			if _, err := jwt.Parse(s, func(*jwt.Token) (interface{}, error) { return other.Public(), nil }); err == nil {
+				t.Error("Expected verification with another key to fail")
+			}
+		})
+	}
+}
+
+func TestMethodUnsupported(t *testing.T) {
+	for _, alg := range []string{"", "HS256", "none", "ES512"} {
+		if _, err := Method(alg); err == nil {
+			t.Errorf("Method(%s), Expected error", alg)
+		}
+		if _, err := GenerateKey(alg); err == nil {
+			t.Errorf("GenerateKey(%s), Expected error", alg)
+		}
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	key, _ := rsaa.ParseRsaKeys("../../test-resources/private.pem", "", "../../test-resources/public.pem")
+	res, err := Thumbprint(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Same kid as before EC and Ed25519 support
+	exp := "iu/doGX/WCNvVzSzGspKSJ0/ekM"
+	if res != exp {
+		t.Errorf("Thumbprint(), Expected: %v, Got: %v", exp, res)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var testResp = []struct {
+		alg  string // key algorithm
+		typ  string // PEM type of the private key
+		sec1 bool   // EC key in SEC 1 format instead of PKCS#8
+	}{
+		{ES256, "EC PRIVATE KEY", true},
+		{ES384, "PRIVATE KEY", false},
+		{EdDSA, "PRIVATE KEY", false},
+	}
+	for _, tc := range testResp {
+		key, _ := GenerateKey(tc.alg)
+		var der []byte
+		if tc.sec1 {
+			der, err = x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+		} else {
+			der, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		privPath := writePem(t, dir, tc.alg+".pem", tc.typ, der)
+		pubDer, _ := x509.MarshalPKIXPublicKey(key.Public())
+		pubPath := writePem(t, dir, tc.alg+".pub.pem", "PUBLIC KEY", pubDer)
+
+		priv, err := ParsePrivateKey(privPath, "")
+		if err != nil {
+			t.Errorf("ParsePrivateKey(%s), Unexpected error: %v", tc.alg, err)
+			continue
+		}
+		pub, err := ParsePublicKey(pubPath)
+		if err != nil {
+			t.Errorf("ParsePublicKey(%s), Unexpected error: %v", tc.alg, err)
+			continue
+		}
+		if !publicKeysEqual(priv.Public(), pub) || !publicKeysEqual(key.Public(), pub) {
+			t.Errorf("ParsePrivateKey(%s), Expected key not loaded", tc.alg)
+		}
+	}
+
+	// Wrong PEM types and missing files
+	for _, path := range []string{"../../test-resources/public.pem", filepath.Join(dir, "missing.pem")} {
+		if _, err := ParsePrivateKey(path, ""); err == nil {
+			t.Errorf("ParsePrivateKey(%s), Expected error", path)
+		}
+	}
+	if _, err := ParsePublicKey("../../test-resources/private.pem"); err == nil {
+		t.Error("ParsePublicKey(private.pem), Expected error")
+	}
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	ta, _ := Thumbprint(a)
+	tb, _ := Thumbprint(b)
+	return ta != "" && ta == tb
+}
+
+func TestParsePrivateKeyEncrypted(t *testing.T) {
+	var testResp = []struct {
+		pass string // input
+		err  bool   // expect error
+	}{
+		{"testpass", false},
+		{"wrongpass", true},
+		{"", true},
+	}
+	for _, tc := range testResp {
+		key, err := ParsePrivateKey("../../test-resources/ec_pkcs8_aes256.pem", tc.pass)
+		if err == nil && tc.err {
+			t.Errorf("ParsePrivateKey(%s), Not getting expected error", tc.pass)
+		}
+		if err != nil && !tc.err {
+			t.Errorf("ParsePrivateKey(%s), Error not expected error; %v", tc.pass, err)
+		}
+		if err == nil {
+			if alg, _ := Algorithm(key.Public()); alg != ES256 {
+				t.Errorf("ParsePrivateKey(%s), Expected %s key, Got: %s", tc.pass, ES256, alg)
+			}
+		}
+	}
+}
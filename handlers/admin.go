@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jafossum/go-auth-server/crypto/keydir"
+	"github.com/jafossum/go-auth-server/crypto/keyring"
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+//go:generate mockgen -destination=../mocks/admin_handler_mock.go -package=mocks github.com/jafossum/go-auth-server/handlers IAdminHandler
+
+// IAdminHandler : AdminHandler Interace
+type IAdminHandler interface {
+	SetKeyRing(keys *keyring.KeyRing)
+	SetAuthorization(authorization *models.Authorization)
+	SetKeyDir(dir string)
+	SetClientCertificateAuth(roots *x509.CertPool)
+	SetReplayCache(replays store.ReplayCache)
+	SetVerifyPool(pool *passwd.Pool)
+	SetSecretUpdater(u SecretUpdater)
+	HandleRotate(w http.ResponseWriter, r *http.Request)
+	HandleRetire(w http.ResponseWriter, r *http.Request)
+}
+
+// AdminHandler - Key management handler. Only confidential admin clients have access
+var AdminHandler IAdminHandler = &adminHandler{generateKey: signing.GenerateKey}
+
+type adminHandler struct {
+	clientAuthenticator
+	keys          *keyring.KeyRing
+	authorization authorizationSnapshot
+	keyDir        string
+	generateKey   func(alg string) (crypto.Signer, error)
+	// keysMu - Serializes key changes, so the key ring and the key directory always have the same signing key
+	keysMu sync.Mutex
+}
+
+// SetKeyRing - Initialize with signing and verification keys
+func (h *adminHandler) SetKeyRing(keys *keyring.KeyRing) {
+	h.keys = keys
+}
+
+// SetAuthorization - Initialize with authorization data
+func (h *adminHandler) SetAuthorization(authorization *models.Authorization) {
+	h.authorization.Store(authorization)
+}
+
+// SetKeyDir - Initialize with the directory the signing key is kept in. Keys can only be
+// rotated if it is set, so the new key is still used after a restart
+func (h *adminHandler) SetKeyDir(dir string) {
+	h.keyDir = dir
+}
+
+// HandleRotate - Generate a new signing key, with the same algorithm as the current one.
+// The previous key is published until all tokens it signed have expired, using the longest client token lifetime.
+// The new key is written to the key directory before it is used, and the directory is restored if it can not be used
+func (h *adminHandler) HandleRotate(w http.ResponseWriter, r *http.Request) {
+	setNoCache(w)
+	authorization := h.authorization.Load()
+	if err := h.authenticateAdmin(authorization, r); err != nil {
+		writeError(w, err)
+		return
+	}
+	if h.keyDir == "" {
+		writeError(w, newOAuthError(errCodeInvalidRequest, http.StatusConflict,
+			"Signing key can only be rotated when it is kept in key_dir"))
+		return
+	}
+	h.keysMu.Lock()
+	defer h.keysMu.Unlock()
+	privateKey, err := h.generateKey(h.keys.Signing().Alg)
+	if err != nil {
+		writeError(w, errServerError("Key generation failed: %s", err))
+		return
+	}
+	grace := maxTokenLifetime(authorization)
+	var key *keyring.Key
+	err = keydir.Rotate(h.keyDir, privateKey, time.Now().Add(grace), func() error {
+		var err error
+		key, err = h.keys.Rotate(privateKey, grace)
+		return err
+	})
+	if err != nil {
+		writeError(w, errServerError("Key rotation failed: %s", err))
+		return
+	}
+	logger.Info.Printf("Signing key rotated, new kid: %s", key.ID)
+	jwk, err := createJwk(key)
+	if err != nil {
+		writeError(w, errServerError("JWK could not be created: %s", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jwk)
+}
+
+// HandleRetire - Remove a verification key before its grace period is over.
+// Tokens signed with the key are no longer accepted. The kid is given as a query parameter,
+// as thumbprints can contain '/'
+func (h *adminHandler) HandleRetire(w http.ResponseWriter, r *http.Request) {
+	setNoCache(w)
+	authorization := h.authorization.Load()
+	if err := h.authenticateAdmin(authorization, r); err != nil {
+		writeError(w, err)
+		return
+	}
+	kid := r.URL.Query().Get("kid")
+	if kid == "" {
+		writeError(w, errInvalidRequest("kid is required"))
+		return
+	}
+	h.keysMu.Lock()
+	defer h.keysMu.Unlock()
+	if err := h.keys.Retire(kid); err != nil {
+		writeError(w, errInvalidRequest("Key could not be retired: %s", err))
+		return
+	}
+	if h.keyDir != "" {
+		if err := keydir.RemovePrevious(h.keyDir, kid); err != nil {
+			logger.Error.Printf("Key: %s could not be removed from: %s: %s", kid, h.keyDir, err)
+		}
+	}
+	logger.Info.Printf("Key: %s retired", kid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticateAdmin - Client credentials are only accepted with HTTP Basic authentication
+func (h *adminHandler) authenticateAdmin(authorization *models.Authorization, r *http.Request) error {
+	if r.Header.Get("Authorization") == "" {
+		return errInvalidClient("Admin endpoints require client authentication")
+	}
+	req := &models.TokenRequest{}
+	if err := applyBasicAuth(r, req); err != nil {
+		return err
+	}
+	client, err := h.authenticateClient(authorization, req)
+	if err != nil {
+		return err
+	}
+	if !client.GetIsAdmin() || client.GetPublic() {
+		return newOAuthError(errCodeUnauthorizedClient, http.StatusForbidden,
+			"Client: %s is not an admin client", client.GetClientId())
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jafossum/go-auth-server/crypto/keydir"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+var adminAuth = &models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+	&models.Client{ClientId: "cl1", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G"},
+	&models.Client{ClientId: "cl3", ClientSecret: "$2a$10$0sxSR6FKk8msHgPSBN0Au.sGW3HQxRughWXsAZMq8GAVDcrTfFeLm", IsAdmin: true},
+	&models.Client{ClientId: "pub", IsAdmin: true, Public: true}}}
+
+// newAdminTestHandlers - Handlers sharing a key ring, with the signing key written to a temporary
+// key directory. The caller removes the directory
+func newAdminTestHandlers(t *testing.T) (*adminHandler, *tokenHandler, string) {
+	t.Helper()
+	keys := testKeyRing()
+	th := &tokenHandler{}
+	th.SetKeyRing(keys)
+	th.SetAuthorization(adminAuth)
+
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey := keys.Signing()
+	if _, err := keydir.LoadOrGenerate(dir, signingKey.Alg, func(string) (crypto.Signer, error) { return signingKey.PrivateKey, nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h := &adminHandler{generateKey: func(string) (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 1024) }}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(adminAuth)
+	h.SetKeyDir(dir)
+	return h, th, dir
+}
+
+func doAdmin(h *adminHandler, method, path, user, pass string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/keys/rotate", h.HandleRotate).Methods("POST")
+	r.HandleFunc("/admin/keys", h.HandleRetire).Methods("DELETE")
+	req, _ := http.NewRequest(method, path, nil)
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	h, _, dir := newAdminTestHandlers(t)
+	defer os.RemoveAll(dir)
+	var testResp = []struct {
+		name   string // test name
+		user   string // input
+		pass   string // input
+		status int    // expected status
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong secret", "cl3", "secret1", http.StatusUnauthorized},
+		{"not admin", "cl1", "secret1", http.StatusForbidden},
+		{"public admin", "pub", "", http.StatusForbidden},
+		{"admin", "cl3", "secret3", http.StatusOK},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doAdmin(h, "POST", "/admin/keys/rotate", tc.user, tc.pass)
+			if rr.Code != tc.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.status)
+			}
+		})
+	}
+}
+
+func TestAdminRotateAndRetire(t *testing.T) {
+	h, th, dir := newAdminTestHandlers(t)
+	defer os.RemoveAll(dir)
+	old := h.keys.Signing().ID
+
+	// Token signed with the key that is rotated out
+	tr, err := th.handleClientCredentials(th.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rr := doAdmin(h, "POST", "/admin/keys/rotate", "cl3", "secret3")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	jwk := &models.JSONWebKeys{}
+	json.NewDecoder(rr.Body).Decode(jwk)
+	if jwk.Kid == old || jwk.Kid != h.keys.Signing().ID {
+		t.Errorf("Expected new signing key, Got kid: %s", jwk.Kid)
+	}
+	if len(h.keys.Keys()) != 2 {
+		t.Errorf("Expected old key to be published until the grace period is over, Got %d keys", len(h.keys.Keys()))
+	}
+	if _, err := parseAccessToken(h.keys, "Test-Issuer", nil, tr.AccessToken); err != nil {
+		t.Errorf("Expected token signed with the old key to be valid: %v", err)
+	}
+	// The new key is the signing key in the key directory, and the old key a previous key
+	if kid := keyDirSigningKid(t, dir); kid != jwk.Kid {
+		t.Errorf("Expected new key in key directory, Got kid: %s", kid)
+	}
+	if previous, err := keydir.LoadPrevious(dir); err != nil || len(previous) != 1 {
+		t.Errorf("Expected the old key as previous key, Got: %d, %v", len(previous), err)
+	}
+
+	// The signing key can not be retired
+	if rr := doAdmin(h, "DELETE", "/admin/keys?kid="+url.QueryEscape(jwk.Kid), "cl3", "secret3"); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doAdmin(h, "DELETE", "/admin/keys?kid="+url.QueryEscape(old), "cl1", "secret1"); rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := doAdmin(h, "DELETE", "/admin/keys?kid="+url.QueryEscape(old), "cl3", "secret3"); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if _, err := parseAccessToken(h.keys, "Test-Issuer", nil, tr.AccessToken); err == nil {
+		t.Error("Expected token signed with a retired key to be rejected")
+	}
+	if previous, err := keydir.LoadPrevious(dir); err != nil || len(previous) != 0 {
+		t.Errorf("Expected retired key removed from key directory, Got: %d, %v", len(previous), err)
+	}
+	if rr := doAdmin(h, "DELETE", "/admin/keys?kid="+url.QueryEscape(old), "cl3", "secret3"); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminRotateWithoutKeyDir(t *testing.T) {
+	h, _, dir := newAdminTestHandlers(t)
+	defer os.RemoveAll(dir)
+	h.SetKeyDir("")
+	old := h.keys.Signing().ID
+	if rr := doAdmin(h, "POST", "/admin/keys/rotate", "cl3", "secret3"); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if h.keys.Signing().ID != old {
+		t.Error("Expected signing key not to change")
+	}
+}
+
+func TestAdminRotateFailure(t *testing.T) {
+	h, _, dir := newAdminTestHandlers(t)
+	defer os.RemoveAll(dir)
+	old := h.keys.Signing()
+
+	// The key ring rejects the current key, and the key directory is restored
+	h.generateKey = func(string) (crypto.Signer, error) { return old.PrivateKey, nil }
+	if rr := doAdmin(h, "POST", "/admin/keys/rotate", "cl3", "secret3"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+	if h.keys.Signing().ID != old.ID {
+		t.Error("Expected signing key not to change")
+	}
+	if kid := keyDirSigningKid(t, dir); kid != old.ID {
+		t.Errorf("Expected signing key in key directory to be restored, Got kid: %s", kid)
+	}
+	if previous, err := keydir.LoadPrevious(dir); err != nil || len(previous) != 0 {
+		t.Errorf("Expected no previous keys, Got: %d, %v", len(previous), err)
+	}
+}
+
+func TestAdminRotateConcurrent(t *testing.T) {
+	h, _, dir := newAdminTestHandlers(t)
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rr := doAdmin(h, "POST", "/admin/keys/rotate", "cl3", "secret3"); rr.Code != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+	// The key directory has the key the ring signs with, so it is still used after a restart
+	if kid := keyDirSigningKid(t, dir); kid != h.keys.Signing().ID {
+		t.Errorf("Key directory signing kid: %s, Expected: %s", kid, h.keys.Signing().ID)
+	}
+}
+
+// keyDirSigningKid - kid of the signing key in the key directory
+func keyDirSigningKid(t *testing.T, dir string) string {
+	t.Helper()
+	key, err := signing.ParsePrivateKey(filepath.Join(dir, keydir.PrivateKeyFile), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	kid, _ := signing.Thumbprint(key.Public())
+	return kid
+}
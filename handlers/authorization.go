@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// authorizationSnapshot - Authorization data that can be replaced while requests are being served.
+// A reload stores a new snapshot, and requests already running keep the one they loaded
+type authorizationSnapshot struct {
+	v atomic.Value
+}
+
+// Load - The current authorization data, or nil if not set
+func (s *authorizationSnapshot) Load() *models.Authorization {
+	a, _ := s.v.Load().(*models.Authorization)
+	return a
+}
+
+// Store - Replace the authorization data
+func (s *authorizationSnapshot) Store(authorization *models.Authorization) {
+	s.v.Store(authorization)
+}
+
+// ValidateAuthorization - Check the authorization data for errors the JSON parser does not catch.
+// Called before authorization data is given to the handlers, on startup and reload
+func ValidateAuthorization(a *models.Authorization) error {
+	if u := a.GetTokenEndpointUrl(); u != "" {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("token_endpoint_url: %s is not an absolute URL", u)
+		}
+	}
+	clients := map[string]bool{}
+	for _, c := range a.GetClients() {
+		if c.GetClientId() == "" {
+			return errors.New("Client without client_id")
+		}
+		if clients[c.GetClientId()] {
+			return fmt.Errorf("Duplicate client_id: %s", c.GetClientId())
+		}
+		clients[c.GetClientId()] = true
+		if err := validateClientKeys(c); err != nil {
+			return err
+		}
+		if err := validateClientCertificateAuth(c); err != nil {
+			return err
+		}
+		if !c.GetPublic() && c.GetClientSecret() == "" && !hasClientKeys(c) && !hasClientCertificateAuth(c) && !assertionOnly(c) {
+			return fmt.Errorf("Client: %s has no client_secret, keys or certificate, and is not public", c.GetClientId())
+		}
+		for _, scope := range c.GetScopes() {
+			if scope == "" || strings.ContainsAny(scope, " \t\r\n") {
+				return fmt.Errorf("Client: %s scope: %q is not valid, list scopes separately", c.GetClientId(), scope)
+			}
+		}
+		for name := range c.GetCustomClaims().GetFields() {
+			if reservedClaim(name) {
+				return fmt.Errorf("Client: %s custom claim: %s is reserved", c.GetClientId(), name)
+			}
+		}
+		if c.GetTokenLifetimeSeconds() < 0 {
+			return fmt.Errorf("Client: %s has a negative token_lifetime_seconds", c.GetClientId())
+		}
+		if !allowsAudience(c, c.GetDefaultAudience()) {
+			return fmt.Errorf("Client: %s default_audience: %s is not in allowed_audiences", c.GetClientId(), c.GetDefaultAudience())
+		}
+		if c.GetTokenExchange().GetMaxDelegationDepth() < 0 {
+			return fmt.Errorf("Client: %s has a negative token_exchange max_delegation_depth", c.GetClientId())
+		}
+		for _, aud := range c.GetTokenExchange().GetAudiences() {
+			if !allowsAudience(c, aud) {
+				return fmt.Errorf("Client: %s token_exchange audience: %s is not in allowed_audiences", c.GetClientId(), aud)
+			}
+		}
+	}
+	issuers := map[string]bool{}
+	for _, ti := range a.GetTrustedIssuers() {
+		if err := validateTrustedIssuer(a, ti); err != nil {
+			return err
+		}
+		if issuers[ti.GetIssuer()] {
+			return fmt.Errorf("Duplicate trusted issuer: %s", ti.GetIssuer())
+		}
+		issuers[ti.GetIssuer()] = true
+	}
+	users := map[string]bool{}
+	for _, u := range a.GetUsers() {
+		if u.GetUsername() == "" {
+			return errors.New("User without username")
+		}
+		if users[u.GetUsername()] {
+			return fmt.Errorf("Duplicate username: %s", u.GetUsername())
+		}
+		users[u.GetUsername()] = true
+	}
+	return nil
+}
+
+// validateTrustedIssuer - The issuer keys must load, and every rule must map to a client
+// allowed to use the jwt-bearer grant. Rules must match on the subject or a claim
+func validateTrustedIssuer(a *models.Authorization, ti *models.TrustedIssuer) error {
+	if ti.GetIssuer() == "" {
+		return errors.New("Trusted issuer without issuer")
+	}
+	if err := validateTrustedIssuerKeys(ti); err != nil {
+		return err
+	}
+	for _, rule := range ti.GetRules() {
+		if rule.GetSubject() == "" && len(rule.GetClaims()) == 0 {
+			return fmt.Errorf("Trusted issuer: %s has a rule without subject or claims, matching any token", ti.GetIssuer())
+		}
+		client := findClient(a, rule.GetClientId())
+		if client == nil {
+			return fmt.Errorf("Trusted issuer: %s rule maps to unknown client: %s", ti.GetIssuer(), rule.GetClientId())
+		}
+		if !containsString(client.GetGrantTypes(), GrantTypeJWTBearer) {
+			return fmt.Errorf("Trusted issuer: %s rule maps to client: %s, which does not have grant type: %s",
+				ti.GetIssuer(), rule.GetClientId(), GrantTypeJWTBearer)
+		}
+	}
+	return nil
+}
+
+// assertionOnly - Client only gets tokens with the jwt-bearer grant, and needs no credentials
+func assertionOnly(c *models.Client) bool {
+	for _, gt := range c.GetGrantTypes() {
+		if gt != GrantTypeJWTBearer {
+			return false
+		}
+	}
+	return len(c.GetGrantTypes()) > 0
+}
+
+// hasClientKeys - Client can authenticate with private_key_jwt
+func hasClientKeys(c *models.Client) bool {
+	return c.GetJwks() != nil || c.GetJwksFile() != "" || c.GetPublicKeyFile() != ""
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+// handleAuthorizationCode - Exchange an authorization code from the /authorize endpoint
+// for tokens (RFC 6749 4.1.3). The code verifier is checked if the code has a PKCE challenge
+func (h *tokenHandler) handleAuthorizationCode(authorization *models.Authorization, req *models.TokenRequest) (*models.TokenResponse, error) {
+	client, err := h.authenticate(authorization, req)
+	if err != nil {
+		return nil, err
+	}
+	if !allowsGrant(client, "authorization_code") || h.codeStore == nil {
+		return nil, errUnauthorizedClient("Client: %s is not allowed to use grant_type: authorization_code", client.GetClientId())
+	}
+	if req.Code == "" {
+		return nil, errInvalidRequest("code is required")
+	}
+
+	code, err := h.codeStore.Consume(hashToken(req.Code))
+	if err == store.ErrNotFound {
+		return nil, errInvalidGrant("Authorization code is invalid, expired or already used")
+	}
+	if err != nil {
+		return nil, errServerError("Authorization code lookup failed: %s", err)
+	}
+	if code.ClientID != client.GetClientId() {
+		return nil, errInvalidGrant("Authorization code was not issued to client: %s", client.GetClientId())
+	}
+	if code.RedirectURI != req.RedirectURI {
+		return nil, errInvalidGrant("redirect_uri does not match the authorization request")
+	}
+	if code.CodeChallenge != "" && !verifyCodeChallenge(req.CodeVerifier, code.CodeChallenge) {
+		return nil, errInvalidGrant("code_verifier does not match the code_challenge")
+	}
+	if code.CodeChallenge == "" && req.CodeVerifier != "" {
+		return nil, errInvalidGrant("code_verifier given, but the authorization request had no code_challenge")
+	}
+
+	user := findUser(authorization, code.Username)
+	if user == nil || user.GetDisabled() {
+		return nil, errInvalidGrant("User: %s is not allowed to log in", code.Username)
+	}
+	return h.issueTokens(&tokenGrant{authorization: authorization, client: client, user: user, audience: code.Audience, scope: strings.Fields(code.Scope),
+		cnf: h.tokenConfirmation(client, req)}, "")
+}
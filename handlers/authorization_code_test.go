@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func TestHandleAuthorizationCodeErrors(t *testing.T) {
+	var testResp = []struct {
+		name string               // test name
+		req  *models.TokenRequest // request, code is set by the test
+		code string               // expected error code
+	}{
+		{"wrong verifier", &models.TokenRequest{ClientID: "app", RedirectURI: "https://app.example.com/cb", CodeVerifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXl"}, errCodeInvalidGrant},
+		{"missing verifier", &models.TokenRequest{ClientID: "app", RedirectURI: "https://app.example.com/cb"}, errCodeInvalidGrant},
+		{"wrong redirect_uri", &models.TokenRequest{ClientID: "app", RedirectURI: "app://cb", CodeVerifier: testVerifier}, errCodeInvalidGrant},
+		{"other client", &models.TokenRequest{ClientID: "web", ClientSecret: "secret1", RedirectURI: "https://app.example.com/cb", CodeVerifier: testVerifier}, errCodeInvalidGrant},
+		{"public client with secret", &models.TokenRequest{ClientID: "app", ClientSecret: "secret1", RedirectURI: "https://app.example.com/cb", CodeVerifier: testVerifier}, errCodeInvalidClient},
+		{"client without grant", &models.TokenRequest{ClientID: "m2m", ClientSecret: "secret2", RedirectURI: "https://app.example.com/cb", CodeVerifier: testVerifier}, errCodeUnauthorizedClient},
+	}
+
+	for _, tc := range testResp {
+		t.Run(tc.name, func(t *testing.T) {
+			a, h := newCodeTestHandlers()
+			tc.req.Code = authorizeCode(t, a, appParams())
+			_, err := h.handleAuthorizationCode(h.authorization.Load(), tc.req)
+			expectOAuthError(t, err, tc.code)
+		})
+	}
+}
+
+func TestHandleAuthorizationCodeUnknown(t *testing.T) {
+	_, h := newCodeTestHandlers()
+
+	_, err := h.handleAuthorizationCode(h.authorization.Load(), &models.TokenRequest{ClientID: "app"})
+	expectOAuthError(t, err, errCodeInvalidRequest)
+	_, err = h.handleAuthorizationCode(h.authorization.Load(), &models.TokenRequest{ClientID: "app", Code: "unknown"})
+	expectOAuthError(t, err, errCodeInvalidGrant)
+}
+
+func TestPublicClientCanNotUseClientCredentials(t *testing.T) {
+	_, h := newCodeTestHandlers()
+
+	_, err := h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "app"})
+	expectOAuthError(t, err, errCodeUnauthorizedClient)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_struct "github.com/golang/protobuf/ptypes/struct"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func TestValidateAuthorization(t *testing.T) {
+	var testResp = []struct {
+		name string
+		auth *models.Authorization
+		err  bool // expect error
+	}{
+		{"valid", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash"}, {ClientId: "cl2", Public: true}},
+			Users:   []*models.User{{Username: "user1"}},
+		}, false},
+		{"empty", &models.Authorization{}, false},
+		{"token endpoint url", &models.Authorization{TokenEndpointUrl: "https://auth.example.com/oauth/token"}, false},
+		{"relative token endpoint url", &models.Authorization{TokenEndpointUrl: "/oauth/token"}, true},
+		{"no client_id", &models.Authorization{Clients: []*models.Client{{ClientSecret: "hash"}}}, true},
+		{"duplicate client_id", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash"}, {ClientId: "cl1", ClientSecret: "hash"}},
+		}, true},
+		{"confidential without secret", &models.Authorization{Clients: []*models.Client{{ClientId: "cl1"}}}, true},
+		{"scopes", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", Scopes: []string{"read", "write"}, Scope: "old style"}},
+		}, false},
+		{"scope with space", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", Scopes: []string{"read write"}}},
+		}, true},
+		{"custom claims", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", CustomClaims: &_struct.Struct{Fields: map[string]*_struct.Value{
+				"tenant": {Kind: &_struct.Value_StringValue{StringValue: "t1"}},
+			}}}},
+		}, false},
+		{"reserved custom claim", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", CustomClaims: &_struct.Struct{Fields: map[string]*_struct.Value{
+				"sub": {Kind: &_struct.Value_StringValue{StringValue: "someone"}},
+			}}}},
+		}, true},
+		{"negative lifetime", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", TokenLifetimeSeconds: -1}},
+		}, true},
+		{"default audience allowed", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", AllowedAudiences: []string{"api1", "api2"}, DefaultAudience: "api2"}},
+		}, false},
+		{"default audience not allowed", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", AllowedAudiences: []string{"api1"}, DefaultAudience: "api2"}},
+		}, true},
+		{"negative delegation depth", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", TokenExchange: &models.TokenExchangePolicy{MaxDelegationDepth: -1}}},
+		}, true},
+		{"exchange audience not allowed", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash", AllowedAudiences: []string{"api1"},
+				TokenExchange: &models.TokenExchangePolicy{Audiences: []string{"api2"}}}},
+		}, true},
+		{"jwks_file not found", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", JwksFile: "does-not-exist.json"}},
+		}, true},
+		{"more than one key source", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", JwksFile: "jwks.json", PublicKeyFile: "key.pem"}},
+		}, true},
+		{"certificate client without secret", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", TlsClientAuthSubjectDn: "CN=client1,O=Example"}},
+		}, false},
+		{"certificate client with two match values", &models.Authorization{
+			Clients: []*models.Client{{ClientId: "cl1", TlsClientAuthSubjectDn: "CN=client1", TlsClientAuthSanDns: "client1.example.com"}},
+		}, true},
+		{"no username", &models.Authorization{Users: []*models.User{{}}}, true},
+		{"duplicate username", &models.Authorization{Users: []*models.User{{Username: "user1"}, {Username: "user1"}}}, true},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateAuthorization(tc.auth)
+			if err == nil && tc.err {
+				t.Error("Not getting expected error")
+			}
+			if err != nil && !tc.err {
+				t.Errorf("Got uinexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateTrustedIssuers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jwksFile := filepath.Join(dir, "ci.jwks.json")
+	jwks := `{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`
+	if err := ioutil.WriteFile(jwksFile, []byte(jwks), 0600); err != nil {
+		t.Fatal(err)
+	}
+	clients := []*models.Client{
+		{ClientId: "ci", GrantTypes: []string{"urn:ietf:params:oauth:grant-type:jwt-bearer"}},
+		{ClientId: "cl1", ClientSecret: "hash"},
+	}
+
+	var testResp = []struct {
+		name   string
+		issuer *models.TrustedIssuer
+		err    bool // expect error
+	}{
+		{"valid", &models.TrustedIssuer{Issuer: "https://ci.example.com", JwksFile: jwksFile,
+			Rules: []*models.TrustedSubjectRule{{Subject: "repo:org/*", ClientId: "ci"}, {Claims: map[string]string{"env": "prod"}, ClientId: "ci"}}}, false},
+		{"no issuer", &models.TrustedIssuer{JwksFile: jwksFile}, true},
+		{"jwks_file not found", &models.TrustedIssuer{Issuer: "https://ci.example.com", JwksFile: filepath.Join(dir, "none.json")}, true},
+		{"rule matching any token", &models.TrustedIssuer{Issuer: "https://ci.example.com", JwksFile: jwksFile,
+			Rules: []*models.TrustedSubjectRule{{ClientId: "ci"}}}, true},
+		{"unknown client", &models.TrustedIssuer{Issuer: "https://ci.example.com", JwksFile: jwksFile,
+			Rules: []*models.TrustedSubjectRule{{Subject: "job", ClientId: "cl9"}}}, true},
+		{"client without grant type", &models.TrustedIssuer{Issuer: "https://ci.example.com", JwksFile: jwksFile,
+			Rules: []*models.TrustedSubjectRule{{Subject: "job", ClientId: "cl1"}}}, true},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateAuthorization(&models.Authorization{Clients: clients, TrustedIssuers: []*models.TrustedIssuer{tc.issuer}})
+			if err == nil && tc.err {
+				t.Error("Not getting expected error")
+			}
+			if err != nil && !tc.err {
+				t.Errorf("Got uinexpected error: %v", err)
+			}
+		})
+	}
+	dup := &models.TrustedIssuer{Issuer: "https://ci.example.com", JwksFile: jwksFile}
+	if err := ValidateAuthorization(&models.Authorization{TrustedIssuers: []*models.TrustedIssuer{dup, dup}}); err == nil {
+		t.Error("Duplicate trusted issuer, not getting expected error")
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+//go:generate mockgen -destination=../mocks/authorize_handler_mock.go -package=mocks github.com/jafossum/go-auth-server/handlers IAuthorizeHandler
+
+// authorizationCodeLifetime - Codes must be exchanged shortly after they are issued
+const authorizationCodeLifetime = time.Minute
+
+// csrfField - Name of the login form field and cookie carrying the CSRF token
+const csrfField = "csrf_token"
+
+// IAuthorizeHandler : AuthorizeHandler Interace
+type IAuthorizeHandler interface {
+	SetAuthorization(authorization *models.Authorization)
+	SetAuthorizationCodeStore(codeStore store.AuthorizationCodeStore)
+	SetRateLimits(limits *RateLimits)
+	SetVerifyPool(pool *passwd.Pool)
+	Handle(w http.ResponseWriter, r *http.Request)
+}
+
+// AuthorizeHandler - Authorization endpoint handler
+var AuthorizeHandler IAuthorizeHandler = &authorizeHandler{}
+
+type authorizeHandler struct {
+	authorization authorizationSnapshot
+	codeStore     store.AuthorizationCodeStore
+	// limits - Rate limits and lockouts. Nil if disabled
+	limits *RateLimits
+	// pool - Runs password verifications. Nil runs them on the request goroutine
+	pool *passwd.Pool
+}
+
+// authorizeRequest - Authorization request parameters (RFC 6749 4.1.1, RFC 7636 4.3)
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	State               string
+	Audience            string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// SetAuthorization - Initialize with authorization data
+func (h *authorizeHandler) SetAuthorization(authorization *models.Authorization) {
+	h.authorization.Store(authorization)
+}
+
+// SetAuthorizationCodeStore - Initialize with authorization code storage
+func (h *authorizeHandler) SetAuthorizationCodeStore(codeStore store.AuthorizationCodeStore) {
+	h.codeStore = codeStore
+}
+
+// SetRateLimits - Enable rate limiting and lockout. Nil disables them
+func (h *authorizeHandler) SetRateLimits(limits *RateLimits) {
+	h.limits = limits
+}
+
+// SetVerifyPool - Verify user passwords on a bounded pool, so a burst of logins can not take every CPU
+func (h *authorizeHandler) SetVerifyPool(pool *passwd.Pool) {
+	h.pool = pool
+}
+
+// Handle - Authorization Endpoint handler. GET shows the login page, and POST
+// authenticates the user and redirects back to the client with an authorization code
+func (h *authorizeHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, errInvalidRequest("Request could not be parsed: %s", err))
+		return
+	}
+	req := parseAuthorizeRequest(r.Form)
+	authorization := h.authorization.Load()
+
+	// Errors are only redirected to the client once the redirect URI is known to be registered
+	client, redirectURI, err := h.validateClient(authorization, req)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+	if err := validateAuthorizeRequest(client, req); err != nil {
+		redirectError(w, r, redirectURI, req.State, err)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.renderLogin(authorization, w, r, req, nil)
+		return
+	}
+	if !validCSRFToken(r) {
+		logger.Warning.Printf("Login for client: %s without a valid CSRF token", client.GetClientId())
+		h.renderLogin(authorization, w, r, req, newOAuthError(errCodeInvalidRequest, http.StatusForbidden, "The sign in form has expired, please try again"))
+		return
+	}
+	user, err := h.login(authorization, r, client)
+	if err != nil {
+		logger.Warning.Printf("Login failed for client: %s: %s", client.GetClientId(), err)
+		h.renderLogin(authorization, w, r, req, err)
+		return
+	}
+	code, err := h.generateCode(client, user, req)
+	if err != nil {
+		redirectError(w, r, redirectURI, req.State, errServerError("Authorization code could not be generated: %s", err))
+		return
+	}
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	redirect(w, r, redirectURI, params)
+}
+
+func parseAuthorizeRequest(v url.Values) *authorizeRequest {
+	return &authorizeRequest{
+		ResponseType:        v.Get("response_type"),
+		ClientID:            v.Get("client_id"),
+		RedirectURI:         v.Get("redirect_uri"),
+		State:               v.Get("state"),
+		Audience:            v.Get("audience"),
+		Scope:               v.Get("scope"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+	}
+}
+
+// validateClient - Find the client and resolve the redirect URI. The redirect URI must match
+// a registered one exactly, and can only be left out if the client has exactly one registered
+func (h *authorizeHandler) validateClient(authorization *models.Authorization, req *authorizeRequest) (*models.Client, string, error) {
+	client := findClient(authorization, req.ClientID)
+	if client == nil {
+		return nil, "", errInvalidRequest("Unknown client_id: %s", req.ClientID)
+	}
+	registered := client.GetRedirectUris()
+	if req.RedirectURI == "" {
+		if len(registered) != 1 {
+			return nil, "", errInvalidRequest("redirect_uri is required")
+		}
+		return client, registered[0], nil
+	}
+	for _, uri := range registered {
+		if uri == req.RedirectURI {
+			return client, uri, nil
+		}
+	}
+	return nil, "", errInvalidRequest("redirect_uri: %s is not registered for client: %s", req.RedirectURI, req.ClientID)
+}
+
+// validateAuthorizeRequest - Validate the request for a known client, and resolve the audience and scope.
+// PKCE is mandatory for public clients
+func validateAuthorizeRequest(client *models.Client, req *authorizeRequest) error {
+	if req.ResponseType != "code" {
+		return errUnsupportedResponseType("response_type: %s not supported", req.ResponseType)
+	}
+	if !allowsGrant(client, "authorization_code") {
+		return errUnauthorizedClient("Client: %s is not allowed to use grant_type: authorization_code", client.GetClientId())
+	}
+	audience, err := resolveAudience(client, req.Audience)
+	if err != nil {
+		return err
+	}
+	req.Audience = audience
+	scope, err := resolveScope(clientScopes(client), req.Scope)
+	if err != nil {
+		return err
+	}
+	req.Scope = strings.Join(scope, " ")
+	if req.CodeChallenge == "" {
+		if client.GetPublic() {
+			return errInvalidRequest("code_challenge is required for public clients")
+		}
+		return nil
+	}
+	if req.CodeChallengeMethod != codeChallengeMethodS256 {
+		return errInvalidRequest("code_challenge_method must be %s", codeChallengeMethodS256)
+	}
+	if !codeChallengeFormat.MatchString(req.CodeChallenge) {
+		return errInvalidRequest("code_challenge is not a valid S256 challenge")
+	}
+	return nil
+}
+
+// generateCode - Create and store a new single use authorization code
+func (h *authorizeHandler) generateCode(client *models.Client, user *models.User, req *authorizeRequest) (string, error) {
+	code, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	err = h.codeStore.Save(&models.AuthorizationCode{
+		ID:                  hashToken(code),
+		ClientID:            client.GetClientId(),
+		Username:            user.GetUsername(),
+		Audience:            req.Audience,
+		Scope:               req.Scope,
+		RedirectURI:         req.RedirectURI,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		ExpiresAt:           time.Now().Add(authorizationCodeLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// login - Authenticate the user of the login form. Failures are limited and locked out like the
+// password grant, so the form can not be used to guess passwords past the token endpoint limits
+func (h *authorizeHandler) login(authorization *models.Authorization, r *http.Request, client *models.Client) (*models.User, error) {
+	req := &models.TokenRequest{GrantType: "password", ClientID: client.GetClientId(), Username: r.PostForm.Get("username")}
+	limits := h.limits
+	var ip string
+	if limits != nil {
+		ip = limits.clientIP(r)
+		if wait := limits.allow(ip, req); wait > 0 {
+			return nil, errTooManyRequests(wait, "Too many failed sign in attempts, please try again later")
+		}
+	}
+	user, err := authenticateUser(h.pool, authorization, req.Username, r.PostForm.Get("password"))
+	if limits != nil {
+		limits.record(ip, req, err)
+	}
+	if err != nil && toOAuthError(err).Code == errCodeInvalidGrant {
+		return nil, newOAuthError(errCodeInvalidGrant, http.StatusUnauthorized, "Invalid username or password")
+	}
+	return user, err
+}
+
+// validCSRFToken - The login form must post back the CSRF token of the cookie set when it was rendered
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfField)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get(csrfField))) == 1
+}
+
+// renderLogin - Show the login page, carrying the authorization request in hidden fields. Every
+// render sets a new CSRF token in a cookie and in the form
+func (h *authorizeHandler) renderLogin(authorization *models.Authorization, w http.ResponseWriter, r *http.Request, req *authorizeRequest, loginErr error) {
+	params := map[string]string{}
+	for k, v := range map[string]string{
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"state":                 req.State,
+		"audience":              req.Audience,
+		"scope":                 req.Scope,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	} {
+		if v != "" {
+			params[k] = v
+		}
+	}
+	token, err := randomToken()
+	if err != nil {
+		renderError(w, errServerError("CSRF token could not be generated: %s", err))
+		return
+	}
+	params[csrfField] = token
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfField,
+		Value:    token,
+		Path:     r.URL.Path,
+		Secure:   r.TLS != nil || strings.HasPrefix(issuerURL(authorization.GetIssuer()), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	setPageHeaders(w)
+	page := &loginPage{ClientID: req.ClientID, Action: r.URL.Path, Params: params}
+	if loginErr != nil {
+		oe := toOAuthError(loginErr)
+		page.Error = oe.Description
+		if oe.Code == errCodeServerError {
+			page.Error = "Sign in failed, please try again"
+		}
+		setRetryAfter(w, oe.RetryAfter)
+		w.WriteHeader(oe.Status)
+	}
+	if err := loginTemplate.Execute(w, page); err != nil {
+		logger.Error.Printf("Login page could not be rendered: %s", err)
+	}
+}
+
+// renderError - Show an error page for requests that can not be redirected back to the client
+func renderError(w http.ResponseWriter, err error) {
+	oe := toOAuthError(err)
+	logger.Warning.Println(oe)
+	page := *oe
+	if page.Code == errCodeServerError {
+		page.Description = ""
+	}
+	setPageHeaders(w)
+	w.WriteHeader(oe.Status)
+	if err := errorTemplate.Execute(w, page); err != nil {
+		logger.Error.Printf("Error page could not be rendered: %s", err)
+	}
+}
+
+// redirectError - Redirect an error back to the client (RFC 6749 4.1.2.1)
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI, state string, err error) {
+	oe := toOAuthError(err)
+	logger.Warning.Println(oe)
+	params := url.Values{"error": {oe.Code}}
+	if oe.Code != errCodeServerError {
+		params.Set("error_description", oe.Description)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	redirect(w, r, redirectURI, params)
+}
+
+// redirect - Redirect to the redirect URI with params added to any existing query
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		renderError(w, errServerError("Registered redirect_uri: %s could not be parsed", redirectURI))
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+var codeAuth = &models.Authorization{Issuer: "Test-Issuer",
+	Clients: []*models.Client{
+		&models.Client{ClientId: "app", Public: true, GrantTypes: []string{"authorization_code"}, RedirectUris: []string{"https://app.example.com/cb", "app://cb"}, AllowRefreshToken: true},
+		&models.Client{ClientId: "web", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G", GrantTypes: []string{"authorization_code"}, RedirectUris: []string{"https://web.example.com/cb?x=1"}},
+		&models.Client{ClientId: "m2m", ClientSecret: "$2a$10$a/JANxkdgbJtc0i36ZEk.eVxoUaMdvMhr/k4fpjL5kTbAeZJFpeIm", RedirectUris: []string{"https://m2m.example.com/cb"}}},
+	Users: []*models.User{
+		&models.User{Username: "alice", Password: "$2a$10$ceIdYU59NMUnoaw0MEafm.qNiWHAb8gWdBC1Fr2bUVI1KqPAaVdZO", Roles: []string{"reader"}}}}
+
+func newCodeTestHandlers() (*authorizeHandler, *tokenHandler) {
+	codeStore := store.NewMemoryAuthorizationCodeStore()
+	a := &authorizeHandler{}
+	a.SetAuthorization(codeAuth)
+	a.SetAuthorizationCodeStore(codeStore)
+
+	keys := testKeyRing()
+	h := &tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(codeAuth)
+	h.SetRefreshTokenStore(store.NewMemoryRefreshTokenStore())
+	h.SetAuthorizationCodeStore(codeStore)
+	return a, h
+}
+
+// doAuthorize - Send an authorization request. Posts carry the CSRF token of a rendered login page
+func doAuthorize(a *authorizeHandler, method string, params url.Values) *httptest.ResponseRecorder {
+	if method == "GET" {
+		req, _ := http.NewRequest("GET", "/authorize?"+params.Encode(), nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(a.Handle).ServeHTTP(rr, req)
+		return rr
+	}
+	var token string
+	for _, c := range doAuthorize(a, "GET", params).Result().Cookies() {
+		if c.Name == csrfField {
+			token = c.Value
+		}
+	}
+	return postAuthorize(a, params, token, token)
+}
+
+// postAuthorize - Post the login form with the given CSRF cookie and form field
+func postAuthorize(a *authorizeHandler, params url.Values, cookie, field string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	if field != "" {
+		form.Set(csrfField, field)
+	}
+	req, _ := http.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: csrfField, Value: cookie})
+	}
+	req.RemoteAddr = "203.0.113.7:5000"
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(a.Handle).ServeHTTP(rr, req)
+	return rr
+}
+
+func appParams() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"app"},
+		"redirect_uri":          {"https://app.example.com/cb"},
+		"state":                 {"xyz"},
+		"code_challenge":        {testChallenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func TestAuthorizeLoginPage(t *testing.T) {
+	a, _ := newCodeTestHandlers()
+
+	rr := doAuthorize(a, "GET", appParams())
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	for _, exp := range []string{`name="username"`, `name="password"`, `name="state" value="xyz"`, `name="code_challenge" value="` + testChallenge + `"`} {
+		if !strings.Contains(body, exp) {
+			t.Errorf("Login page missing: %s", exp)
+		}
+	}
+	if rr.Header().Get("X-Frame-Options") != "DENY" {
+		t.Error("Login page must not be framed")
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfField || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("Expected HttpOnly SameSite CSRF cookie, Got: %v", cookies)
+	}
+	if !strings.Contains(body, `name="csrf_token" value="`+cookies[0].Value+`"`) {
+		t.Error("Login page missing the CSRF token of the cookie")
+	}
+}
+
+func TestAuthorizeLoginCSRF(t *testing.T) {
+	a, _ := newCodeTestHandlers()
+	params := appParams()
+	params.Set("username", "alice")
+	params.Set("password", "alicepass")
+
+	var testResp = []struct {
+		name   string // test name
+		cookie string // CSRF cookie
+		field  string // CSRF form field
+	}{
+		{"no token", "", ""},
+		{"no cookie", "", "token1"},
+		{"no field", "token1", ""},
+		{"mismatch", "token1", "token2"},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			rr := postAuthorize(a, params, tc.cookie, tc.field)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+			}
+			if loc := rr.Header().Get("Location"); loc != "" {
+				t.Errorf("Unexpected redirect to: %s", loc)
+			}
+			if !strings.Contains(rr.Body.String(), `name="csrf_token"`) {
+				t.Error("Expected login page with a new CSRF token")
+			}
+		})
+	}
+	if rr := postAuthorize(a, params, "token1", "token1"); rr.Code != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+	}
+}
+
+func TestAuthorizeLoginLockout(t *testing.T) {
+	limits, err := NewRateLimits(&models.RateLimitConfig{LockoutThreshold: 2, LockoutDuration: time.Minute})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	a, h := newCodeTestHandlers()
+	a.SetRateLimits(limits)
+	h.SetRateLimits(limits)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		params := appParams()
+		params.Set("username", "alice")
+		params.Set("password", password)
+		return doAuthorize(a, "POST", params)
+	}
+	for i := 0; i < 2; i++ {
+		if rr := login("wrongpass"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	}
+	// Locked out, also with the right password
+	rr := login("alicepass")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if ra := rr.Header().Get("Retry-After"); ra != "60" {
+		t.Errorf("Retry-After, Expected: 60, Got: %s", ra)
+	}
+	if _, ok := limits.failures["user:alice@203.0.113.7"]; !ok {
+		t.Error("Expected failures counted for the username from the source IP")
+	}
+	// The login form and the password grant share the lockout
+	body := url.Values{"grant_type": {"password"}, "client_id": {"web"}, "client_secret": {"secret1"}, "username": {"alice"}, "password": {"alicepass"}}
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(body.Encode()))
+	req.Header.Set("Content-Type", contentTypeForm)
+	req.RemoteAddr = "203.0.113.7:5000"
+	rr = httptest.NewRecorder()
+	h.Handle(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Password grant, Expected 429, Got: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAuthorizeNotRedirected(t *testing.T) {
+	a, _ := newCodeTestHandlers()
+
+	var testResp = []struct {
+		name   string     // test name
+		params url.Values // request
+	}{
+		{"unknown client", url.Values{"response_type": {"code"}, "client_id": {"nope"}, "redirect_uri": {"https://app.example.com/cb"}}},
+		{"unregistered redirect_uri", url.Values{"response_type": {"code"}, "client_id": {"app"}, "redirect_uri": {"https://evil.example.com/cb"}}},
+		{"missing redirect_uri with several registered", url.Values{"response_type": {"code"}, "client_id": {"app"}}},
+	}
+	for _, tc := range testResp {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doAuthorize(a, "GET", tc.params)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+			}
+			if loc := rr.Header().Get("Location"); loc != "" {
+				t.Errorf("Unexpected redirect to: %s", loc)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRedirectedErrors(t *testing.T) {
+	a, _ := newCodeTestHandlers()
+
+	var testResp = []struct {
+		name   string     // test name
+		params url.Values // request
+		code   string     // expected error code
+	}{
+		{"public client without pkce", url.Values{"response_type": {"code"}, "client_id": {"app"}, "redirect_uri": {"app://cb"}, "state": {"s1"}}, errCodeInvalidRequest},
+		{"plain pkce", url.Values{"response_type": {"code"}, "client_id": {"app"}, "redirect_uri": {"app://cb"}, "state": {"s1"}, "code_challenge": {testVerifier}, "code_challenge_method": {"plain"}}, errCodeInvalidRequest},
+		{"token response type", url.Values{"response_type": {"token"}, "client_id": {"web"}, "state": {"s1"}}, errCodeUnsupportedResponseType},
+		{"client without grant", url.Values{"response_type": {"code"}, "client_id": {"m2m"}, "state": {"s1"}}, errCodeUnauthorizedClient},
+	}
+	for _, tc := range testResp {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doAuthorize(a, "GET", tc.params)
+			if rr.Code != http.StatusFound {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+			}
+			loc, err := url.Parse(rr.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			if loc.Query().Get("error") != tc.code {
+				t.Errorf("Expected error: %v, got: %v", tc.code, loc.Query().Get("error"))
+			}
+			if loc.Query().Get("state") != "s1" {
+				t.Errorf("Expected state: s1, got: %v", loc.Query().Get("state"))
+			}
+		})
+	}
+}
+
+func TestAuthorizeLoginFailed(t *testing.T) {
+	a, _ := newCodeTestHandlers()
+
+	params := appParams()
+	params.Set("username", "alice")
+	params.Set("password", "wrongpass")
+	rr := doAuthorize(a, "POST", params)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if !strings.Contains(rr.Body.String(), "Invalid username or password") {
+		t.Error("Expected login error on page")
+	}
+}
+
+// authorizeCode - Log in and return the issued code
+func authorizeCode(t *testing.T, a *authorizeHandler, params url.Values) string {
+	t.Helper()
+	params.Set("username", "alice")
+	params.Set("password", "alicepass")
+	rr := doAuthorize(a, "POST", params)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+	}
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if params.Get("state") != "" && loc.Query().Get("state") != params.Get("state") {
+		t.Errorf("Expected state: %v, got: %v", params.Get("state"), loc.Query().Get("state"))
+	}
+	code := loc.Query().Get("code")
+	if code == "" {
+		t.Fatalf("Expected code in redirect: %v", loc)
+	}
+	return code
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	a, h := newCodeTestHandlers()
+
+	code := authorizeCode(t, a, appParams())
+	res, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "authorization_code", ClientID: "app", Code: code, RedirectURI: "https://app.example.com/cb", CodeVerifier: testVerifier})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken == "" {
+		t.Errorf("Expected access and refresh token, Got: %v", res)
+	}
+
+	// Codes are single use
+	_, err = h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "authorization_code", ClientID: "app", Code: code, RedirectURI: "https://app.example.com/cb", CodeVerifier: testVerifier})
+	expectOAuthError(t, err, errCodeInvalidGrant)
+}
+
+func TestAuthorizationCodeRedirectQuery(t *testing.T) {
+	a, h := newCodeTestHandlers()
+
+	// Single registered redirect URI can be left out, and its query is kept
+	params := url.Values{"response_type": {"code"}, "client_id": {"web"}}
+	params.Set("username", "alice")
+	params.Set("password", "alicepass")
+	rr := doAuthorize(a, "POST", params)
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if loc.Host != "web.example.com" || loc.Query().Get("x") != "1" {
+		t.Fatalf("Unexpected redirect: %v", loc)
+	}
+	_, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "authorization_code", ClientID: "web", ClientSecret: "secret1", Code: loc.Query().Get("code")})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	_struct "github.com/golang/protobuf/ptypes/struct"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// reservedClaims - Claims set by the server. Registered JWT claims (RFC 7519 4.1), the claims
+// in myClaimsStructure, and confirmation (RFC 7800) and actor (RFC 8693) claims
+var reservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+	"admin": true, "scope": true, "client_id": true, "azp": true, "roles": true,
+	"cnf": true, "act": true,
+}
+
+// reservedClaim - True if the claim is set by the server, and can not be a custom claim
+func reservedClaim(name string) bool {
+	return reservedClaims[name]
+}
+
+// customClaims - Custom claims of a client as JSON values. Reserved claims are left out
+func customClaims(clientID string, s *_struct.Struct) map[string]interface{} {
+	if len(s.GetFields()) == 0 {
+		return nil
+	}
+	res := map[string]interface{}{}
+	for name, v := range s.GetFields() {
+		if reservedClaim(name) {
+			logger.Warning.Printf("Client: %s custom claim: %s is reserved, not added to the token", clientID, name)
+			continue
+		}
+		res[name] = claimValue(v)
+	}
+	return res
+}
+
+// claimValue - Convert a protobuf Value to the matching JSON value
+func claimValue(v *_struct.Value) interface{} {
+	switch k := v.GetKind().(type) {
+	case *_struct.Value_StringValue:
+		return k.StringValue
+	case *_struct.Value_NumberValue:
+		return k.NumberValue
+	case *_struct.Value_BoolValue:
+		return k.BoolValue
+	case *_struct.Value_ListValue:
+		list := make([]interface{}, 0, len(k.ListValue.GetValues()))
+		for _, item := range k.ListValue.GetValues() {
+			list = append(list, claimValue(item))
+		}
+		return list
+	case *_struct.Value_StructValue:
+		m := map[string]interface{}{}
+		for name, item := range k.StructValue.GetFields() {
+			m[name] = claimValue(item)
+		}
+		return m
+	}
+	return nil
+}
+
+// MarshalJSON - The token claims, with the custom claims merged in. Custom claims never replace server claims
+func (c myClaimsStructure) MarshalJSON() ([]byte, error) {
+	type claims myClaimsStructure // without this method
+	b, err := json.Marshal(claims(c))
+	if err != nil || len(c.custom) == 0 {
+		return b, err
+	}
+	m := map[string]interface{}{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&m); err != nil {
+		return nil, fmt.Errorf("Claims could not be merged: %v", err)
+	}
+	for name, v := range c.custom {
+		if _, ok := m[name]; !ok {
+			m[name] = v
+		}
+	}
+	return json.Marshal(m)
+}
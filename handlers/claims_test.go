@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// testClient - Client parsed from JSON, as in the authorization config file
+func testClient(t *testing.T, js string) *models.Client {
+	t.Helper()
+	client := &models.Client{}
+	if err := jsonpb.Unmarshal(strings.NewReader(js), client); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	return client
+}
+
+func TestCustomClaims(t *testing.T) {
+	keys := testKeyRing()
+	h := tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(auth)
+
+	client := testClient(t, `{"client_id": "cl1", "scopes": ["read"], "custom_claims": {
+		"tenant_id": "t-42",
+		"team": "payments",
+		"max_items": 25,
+		"beta": true,
+		"features": ["export", "reports"],
+		"org": {"id": 7, "name": "Example"},
+		"iss": "Evil-Issuer",
+		"scope": "admin"
+	}}`)
+	s, err := h.generateJWT(&tokenGrant{authorization: h.authorization.Load(), client: client, scope: []string{"read"}})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(s, claims, func(*jwt.Token) (interface{}, error) {
+		return keys.Signing().PublicKey, nil
+	}); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+
+	var testResp = []struct {
+		claim string      // claim name
+		exp   interface{} // expected value
+	}{
+		{"tenant_id", "t-42"},
+		{"team", "payments"},
+		{"max_items", float64(25)},
+		{"beta", true},
+		{"features", []interface{}{"export", "reports"}},
+		{"org", map[string]interface{}{"id": float64(7), "name": "Example"}},
+		// Reserved claims are not replaced
+		{"iss", "Test-Issuer"},
+		{"scope", "read"},
+		{"sub", "cl1"},
+	}
+	for _, tc := range testResp {
+		if res := claims[tc.claim]; !reflect.DeepEqual(res, tc.exp) {
+			t.Errorf("Claim: %s, Expected: %v, Got: %v", tc.claim, tc.exp, res)
+		}
+	}
+}
+
+func TestCustomClaimsNone(t *testing.T) {
+	if res := customClaims("cl1", nil); res != nil {
+		t.Errorf("customClaims(nil), Expected: nil, Got: %v", res)
+	}
+	b, err := myClaimsStructure{StandardClaims: &jwt.StandardClaims{Subject: "cl1"}, Admin: "false"}.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	exp := `{"sub":"cl1","admin":"false","scope":""}`
+	if string(b) != exp {
+		t.Errorf("MarshalJSON(), Expected: %s, Got: %s", exp, b)
+	}
+}
+
+func TestReservedClaim(t *testing.T) {
+	for _, name := range []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "scope", "client_id", "roles", "cnf", "act"} {
+		if !reservedClaim(name) {
+			t.Errorf("reservedClaim(%s), Expected: true", name)
+		}
+	}
+	for _, name := range []string{"tenant_id", "team", "Iss"} {
+		if reservedClaim(name) {
+			t.Errorf("reservedClaim(%s), Expected: false", name)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// clientAssertionTypeJWT - The only supported client_assertion_type (RFC 7523 2.2)
+const clientAssertionTypeJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// maxAssertionLifetime - Assertions must expire within this time, so used jti values
+// are not kept for long
+const maxAssertionLifetime = time.Hour
+
+// verificationKey - Public key for assertion signatures
+type verificationKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// validateClientKeys - Check the private_key_jwt keys of the client can be loaded
+func validateClientKeys(client *models.Client) error {
+	set := 0
+	if client.GetJwks() != nil {
+		set++
+	}
+	if client.GetJwksFile() != "" {
+		set++
+	}
+	if client.GetPublicKeyFile() != "" {
+		set++
+	}
+	if set > 1 {
+		return fmt.Errorf("Client: %s can only have one of jwks, jwks_file and public_key_file", client.GetClientId())
+	}
+	_, err := clientKeys(client)
+	return err
+}
+
+// clientKeys - Keys from the client jwks, jwks_file or public_key_file. Files are read on
+// every call, so keys can be rotated without reloading the config. Nil if the client has none
+func clientKeys(client *models.Client) ([]verificationKey, error) {
+	jwks := &models.Jwks{}
+	switch {
+	case client.GetJwks() != nil:
+		js, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(client.GetJwks())
+		if err != nil {
+			return nil, fmt.Errorf("Client: %s jwks not valid: %v", client.GetClientId(), err)
+		}
+		if err := json.Unmarshal([]byte(js), jwks); err != nil {
+			return nil, fmt.Errorf("Client: %s jwks not valid: %v", client.GetClientId(), err)
+		}
+	case client.GetJwksFile() != "":
+		var err error
+		if jwks, err = readJwks(client.GetJwksFile()); err != nil {
+			return nil, fmt.Errorf("Client: %s %v", client.GetClientId(), err)
+		}
+	case client.GetPublicKeyFile() != "":
+		pub, err := signing.ParsePublicKey(client.GetPublicKeyFile())
+		if err != nil {
+			return nil, fmt.Errorf("Client: %s %v", client.GetClientId(), err)
+		}
+		alg, _ := signing.Algorithm(pub)
+		return []verificationKey{{alg: alg, key: pub}}, nil
+	default:
+		return nil, nil
+	}
+	keys, err := jwksKeys(jwks)
+	if err != nil {
+		return nil, fmt.Errorf("Client: %s %v", client.GetClientId(), err)
+	}
+	return keys, nil
+}
+
+// readJwks - Read a JWKS file
+func readJwks(path string) (*models.Jwks, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwks_file could not be read: %v", err)
+	}
+	jwks := &models.Jwks{}
+	if err := json.Unmarshal(b, jwks); err != nil {
+		return nil, fmt.Errorf("jwks_file: %s not valid: %v", path, err)
+	}
+	return jwks, nil
+}
+
+// jwksKeys - Signature verification keys of a JWKS. Keys for other uses are left out
+func jwksKeys(jwks *models.Jwks) ([]verificationKey, error) {
+	keys := []verificationKey{}
+	for i := range jwks.Keys {
+		k := &jwks.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := parseJwk(k)
+		if err != nil {
+			return nil, err
+		}
+		// The algorithm is given by the key type, never by the alg parameter or the assertion
+		alg, err := signing.Algorithm(pub)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, verificationKey{kid: k.Kid, alg: alg, key: pub})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no signing keys")
+	}
+	return keys, nil
+}
+
+// authenticateClientAssertion - Authenticate a client with a JWT signed by one of its
+// registered keys (private_key_jwt, RFC 7523 2.2 and 3)
+func (a *clientAuthenticator) authenticateClientAssertion(authorization *models.Authorization, req *models.TokenRequest) (*models.Client, error) {
+	if req.ClientAssertionType != clientAssertionTypeJWT {
+		return nil, errInvalidRequest("client_assertion_type: %s not supported", req.ClientAssertionType)
+	}
+	if req.ClientAssertion == "" {
+		return nil, errInvalidRequest("Missing client_assertion")
+	}
+	if req.ClientSecret != "" {
+		return nil, errInvalidRequest("Client credentials given with both client_secret and client_assertion")
+	}
+
+	parser := &jwt.Parser{ValidMethods: signing.Algorithms, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	token, _, err := parser.ParseUnverified(req.ClientAssertion, claims)
+	if err != nil {
+		return nil, errInvalidClient("client_assertion could not be parsed: %s", err)
+	}
+	iss, _ := claims["iss"].(string)
+	sub, _ := claims["sub"].(string)
+	if iss == "" || iss != sub {
+		return nil, errInvalidClient("client_assertion iss and sub must both be the client_id")
+	}
+	if req.ClientID != "" && req.ClientID != iss {
+		return nil, errInvalidClient("client_id does not match client_assertion issuer")
+	}
+	client := findClient(authorization, iss)
+	if client == nil || client.GetPublic() {
+		return nil, errInvalidClient("Client authentication failed for client_id: %s", iss)
+	}
+	keys, err := clientKeys(client)
+	if err != nil {
+		logger.Error.Printf("Client keys could not be loaded: %s", err)
+		return nil, errInvalidClient("Client authentication failed for client_id: %s", iss)
+	}
+	if len(keys) == 0 {
+		return nil, errInvalidClient("Client: %s has no keys registered for private_key_jwt", iss)
+	}
+
+	if !verifyAssertion(parser, keys, token, req.ClientAssertion) {
+		return nil, errInvalidClient("client_assertion signature not valid for client_id: %s", iss)
+	}
+	if err := checkAssertionClaims(claims, assertionAudiences(authorization, req.EndpointPath)); err != nil {
+		return nil, errInvalidClient("client_assertion %s", err)
+	}
+	exp := int64Claim(claims, "exp")
+	if exp > time.Now().Add(maxAssertionLifetime).Unix() {
+		return nil, errInvalidClient("client_assertion expires more than %s in the future", maxAssertionLifetime)
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, errInvalidClient("client_assertion has no jti")
+	}
+	if err := a.useID(iss+" "+jti, time.Unix(exp, 0)); err != nil {
+		if err == store.ErrReused {
+			return nil, errInvalidClient("client_assertion jti: %s already used", jti)
+		}
+		return nil, errServerError("client_assertion jti could not be stored: %s", err)
+	}
+	return client, nil
+}
+
+// verifyAssertion - Check the signature with the keys matching the kid and algorithm
+// of the assertion. Keys without kid match any kid
+func verifyAssertion(parser *jwt.Parser, keys []verificationKey, token *jwt.Token, assertion string) bool {
+	kid, _ := token.Header["kid"].(string)
+	for _, k := range keys {
+		if k.alg != token.Method.Alg() || (kid != "" && k.kid != "" && k.kid != kid) {
+			continue
+		}
+		key := k.key
+		if _, err := parser.Parse(assertion, func(*jwt.Token) (interface{}, error) { return key, nil }); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAssertionClaims - Check exp, nbf and aud of an assertion with a valid signature (RFC 7523 3).
+// exp is required, and aud must be one of audiences
+func checkAssertionClaims(claims jwt.MapClaims, audiences []string) error {
+	now := time.Now().Unix()
+	exp := int64Claim(claims, "exp")
+	if exp == 0 {
+		return errors.New("has no exp")
+	}
+	if exp <= now {
+		return errors.New("is expired")
+	}
+	if !claims.VerifyNotBefore(now, false) {
+		return errors.New("is not valid yet")
+	}
+	if !audienceValid(claims["aud"], audiences) {
+		return errors.New("audience does not identify this server")
+	}
+	return nil
+}
+
+// assertionAudiences - Values the assertion aud can have: the issuer, and the endpoint URLs
+func assertionAudiences(authorization *models.Authorization, path string) []string {
+	res := endpointURLs(authorization, path)
+	if issuer := authorization.GetIssuer(); issuer != "" {
+		res = append(res, issuer)
+	}
+	return res
+}
+
+// endpointURLs - The configured token_endpoint_url, and the endpoint path under the issuer if it is
+// an absolute URL. Built from the config only, as the Host header is chosen by the client
+func endpointURLs(authorization *models.Authorization, path string) []string {
+	res := []string{}
+	if u := authorization.GetTokenEndpointUrl(); u != "" {
+		res = append(res, u)
+	}
+	if base := issuerURL(authorization.GetIssuer()); base != "" {
+		res = append(res, base+path)
+	}
+	return res
+}
+
+// tokenEndpointURL - The configured token_endpoint_url, or the endpoint path under the issuer if it is
+// an absolute URL. Empty if neither is configured
+func tokenEndpointURL(authorization *models.Authorization, path string) string {
+	if u := authorization.GetTokenEndpointUrl(); u != "" {
+		return u
+	}
+	if base := issuerURL(authorization.GetIssuer()); base != "" {
+		return base + path
+	}
+	return ""
+}
+
+// audienceValid - aud can be a string or a list of strings (RFC 7519 4.1.3)
+func audienceValid(aud interface{}, accepted []string) bool {
+	var auds []string
+	switch a := aud.(type) {
+	case string:
+		auds = []string{a}
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+	}
+	for _, a := range auds {
+		if a != "" && containsString(accepted, a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/keyring"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+const (
+	testTokenEndpoint = "https://auth.example.com/oauth/token"
+	testTokenPath     = "/oauth/token"
+)
+
+// testAssertionKey - New key for alg, and its public JWK as JSON
+func testAssertionKey(t *testing.T, alg, kid string) (crypto.Signer, string) {
+	t.Helper()
+	priv, err := signing.GenerateKey(alg)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	jwk, err := createJwk(&keyring.Key{ID: kid, Alg: alg, PublicKey: priv.Public()})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	b, _ := json.Marshal(jwk)
+	return priv, string(b)
+}
+
+// testAssertion - Client assertion signed with key. Claims are added to valid defaults
+func testAssertion(t *testing.T, alg, kid string, key crypto.Signer, claims jwt.MapClaims) string {
+	t.Helper()
+	c := jwt.MapClaims{
+		"iss": "cl4",
+		"sub": "cl4",
+		"aud": testTokenEndpoint,
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"jti": time.Now().String(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	method, _ := signing.Method(alg)
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	return s
+}
+
+func TestClientAssertion(t *testing.T) {
+	esKey, esJwk := testAssertionKey(t, signing.ES256, "es1")
+	edKey, edJwk := testAssertionKey(t, signing.EdDSA, "ed1")
+	otherKey, _ := testAssertionKey(t, signing.ES256, "es1")
+	a := &models.Authorization{Issuer: "https://auth.example.com/", Clients: []*models.Client{
+		testClient(t, `{"client_id": "cl4", "jwks": {"keys": [`+esJwk+`, `+edJwk+`]}}`),
+		testClient(t, `{"client_id": "pub1", "public": true, "jwks": {"keys": [`+esJwk+`]}}`),
+	}}
+	c := clientAuthenticator{}
+	c.SetReplayCache(store.NewMemoryReplayCache())
+
+	reused := testAssertion(t, signing.ES256, "es1", esKey, nil)
+	if _, err := c.authenticateClientAssertion(a, &models.TokenRequest{ClientAssertionType: clientAssertionTypeJWT,
+		ClientAssertion: reused, EndpointPath: testTokenPath}); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+
+	var testResp = []struct {
+		name      string
+		req       *models.TokenRequest // request, assertion type and endpoint are added if empty
+		errorCode string               // expected error, empty if none
+	}{
+		{"es256", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, nil)}, ""},
+		{"eddsa without kid", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.EdDSA, "", edKey, nil)}, ""},
+		{"client_id given", &models.TokenRequest{ClientID: "cl4", ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, nil)}, ""},
+		{"issuer audience", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"aud": "https://auth.example.com/"})}, ""},
+		{"audience list", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"aud": []string{"other", testTokenEndpoint}})}, ""},
+		{"reused jti", &models.TokenRequest{ClientAssertion: reused}, errCodeInvalidClient},
+		{"wrong key", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", otherKey, nil)}, errCodeInvalidClient},
+		{"unknown kid", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es2", esKey, nil)}, errCodeInvalidClient},
+		{"other endpoint", &models.TokenRequest{EndpointPath: "/oauth/introspect", ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"aud": "https://auth.example.com/oauth/introspect"})}, ""},
+		{"wrong audience", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"aud": "https://other.example.com/oauth/token"})}, errCodeInvalidClient},
+		{"no audience", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"aud": nil})}, errCodeInvalidClient},
+		{"expired", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"exp": time.Now().Add(-time.Minute).Unix()})}, errCodeInvalidClient},
+		{"no exp", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"exp": nil})}, errCodeInvalidClient},
+		{"exp too far", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"exp": time.Now().Add(2 * time.Hour).Unix()})}, errCodeInvalidClient},
+		{"not valid yet", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"nbf": time.Now().Add(time.Minute).Unix()})}, errCodeInvalidClient},
+		{"no jti", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"jti": nil})}, errCodeInvalidClient},
+		{"sub not client", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"sub": "someone"})}, errCodeInvalidClient},
+		{"client_id mismatch", &models.TokenRequest{ClientID: "cl1", ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, nil)}, errCodeInvalidClient},
+		{"client without keys", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"iss": "cl1", "sub": "cl1"})}, errCodeInvalidClient},
+		{"unknown client", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"iss": "cl9", "sub": "cl9"})}, errCodeInvalidClient},
+		{"public client", &models.TokenRequest{ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, jwt.MapClaims{
+			"iss": "pub1", "sub": "pub1"})}, errCodeInvalidClient},
+		{"hmac", &models.TokenRequest{ClientAssertion: func() string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "cl4", "sub": "cl4", "aud": testTokenEndpoint,
+				"exp": time.Now().Add(time.Minute).Unix(), "jti": "hs"}).SignedString([]byte("secret"))
+			return s
+		}()}, errCodeInvalidClient},
+		{"not a jwt", &models.TokenRequest{ClientAssertion: "abc"}, errCodeInvalidClient},
+		{"wrong type", &models.TokenRequest{ClientAssertionType: "urn:other", ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, nil)}, errCodeInvalidRequest},
+		{"with secret", &models.TokenRequest{ClientSecret: "secret1", ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, nil)}, errCodeInvalidRequest},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.req.ClientAssertionType == "" {
+				tc.req.ClientAssertionType = clientAssertionTypeJWT
+			}
+			if tc.req.EndpointPath == "" {
+				tc.req.EndpointPath = testTokenPath
+			}
+			client, err := c.authenticateClientAssertion(a, tc.req)
+			if tc.errorCode != "" {
+				expectOAuthError(t, err, tc.errorCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			if client.GetClientId() != "cl4" {
+				t.Errorf("Expected: cl4, Got: %s", client.GetClientId())
+			}
+		})
+	}
+
+	// Without a replay cache assertions are never accepted
+	_, err := (&clientAuthenticator{}).authenticateClientAssertion(a, &models.TokenRequest{ClientAssertionType: clientAssertionTypeJWT,
+		ClientAssertion: testAssertion(t, signing.ES256, "es1", esKey, nil), EndpointPath: testTokenPath})
+	expectOAuthError(t, err, errCodeServerError)
+}
+
+func TestClientAssertionHost(t *testing.T) {
+	key, jwk := testAssertionKey(t, signing.ES256, "es1")
+	var testResp = []struct {
+		name             string
+		issuer           string // input
+		tokenEndpointURL string // input
+		aud              string // assertion audience
+		status           int    // expected status
+	}{
+		{"issuer endpoint", "https://auth.example.com", "", testTokenEndpoint, http.StatusOK},
+		{"configured endpoint", "Test-Issuer", testTokenEndpoint, testTokenEndpoint, http.StatusOK},
+		{"spoofed host", "https://auth.example.com", "", "https://evil.example.com/oauth/token", http.StatusUnauthorized},
+		{"spoofed host, issuer not an url", "Test-Issuer", "", "https://evil.example.com/oauth/token", http.StatusUnauthorized},
+		{"spoofed host, configured endpoint", "Test-Issuer", testTokenEndpoint, "https://evil.example.com/oauth/token", http.StatusUnauthorized},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			h := tokenHandler{}
+			h.SetKeyRing(testKeyRing())
+			h.SetReplayCache(store.NewMemoryReplayCache())
+			h.SetAuthorization(&models.Authorization{Issuer: tc.issuer, TokenEndpointUrl: tc.tokenEndpointURL, Clients: []*models.Client{
+				testClient(t, `{"client_id": "cl4", "jwks": {"keys": [`+jwk+`]}}`),
+			}})
+			form := url.Values{"grant_type": {"client_credentials"}, "client_assertion_type": {clientAssertionTypeJWT},
+				"client_assertion": {testAssertion(t, signing.ES256, "es1", key, jwt.MapClaims{"aud": tc.aud})}}
+			// The Host header is chosen by the client
+			req := httptest.NewRequest("POST", "https://evil.example.com/oauth/token", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", contentTypeForm)
+			rr := httptest.NewRecorder()
+			h.Handle(rr, req)
+			if rr.Code != tc.status {
+				t.Errorf("Expected: %d, Got: %d %s", tc.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestClientAssertionToken(t *testing.T) {
+	key, err := signing.GenerateKey(signing.RS256)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "assertion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	der, _ := x509.MarshalPKIXPublicKey(key.Public())
+	path := filepath.Join(dir, "cl4.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetReplayCache(store.NewMemoryReplayCache())
+	h.SetAuthorization(&models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+		{ClientId: "cl4", PublicKeyFile: path, Scopes: []string{"read"}},
+	}})
+
+	res, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "client_credentials", ClientAssertionType: clientAssertionTypeJWT,
+		ClientAssertion: testAssertion(t, signing.RS256, "", key, jwt.MapClaims{"aud": "Test-Issuer"})})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if res.AccessToken == "" || res.Scope != "read" {
+		t.Errorf("Unexpected response: %+v", res)
+	}
+}
+
+func TestValidateClientKeys(t *testing.T) {
+	_, jwk := testAssertionKey(t, signing.ES384, "k1")
+	var testResp = []struct {
+		name string
+		js   string // client
+		err  bool   // expect error
+	}{
+		{"no keys", `{"client_id": "cl1"}`, false},
+		{"jwks", `{"client_id": "cl1", "jwks": {"keys": [` + jwk + `]}}`, false},
+		{"unsupported curve", `{"client_id": "cl1", "jwks": {"keys": [{"kty": "EC", "crv": "P-521", "x": "AA", "y": "AA"}]}}`, true},
+		{"no signing keys", `{"client_id": "cl1", "jwks": {"keys": [{"kty": "OKP", "crv": "Ed25519", "use": "enc", "x": "AA"}]}}`, true},
+		{"bad ed25519", `{"client_id": "cl1", "jwks": {"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AA"}]}}`, true},
+		{"missing file", `{"client_id": "cl1", "public_key_file": "does-not-exist.pem"}`, true},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateClientKeys(testClient(t, tc.js))
+			if err == nil && tc.err {
+				t.Error("Not getting expected error")
+			}
+			if err != nil && !tc.err {
+				t.Errorf("Got uinexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jafossum/go-auth-server/crypto/keyring"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+//go:generate mockgen -destination=../mocks/discovery_handler_mock.go -package=mocks github.com/jafossum/go-auth-server/handlers IDiscoveryHandler
+
+// Route names. Endpoints are only published in the discovery document if a route with the name is registered
+const (
+	RouteJwks       = "jwks"
+	RouteToken      = "token"
+	RouteAuthorize  = "authorize"
+	RouteIntrospect = "introspect"
+	RouteRevoke     = "revoke"
+)
+
+// IDiscoveryHandler : DiscoveryHandler Interace
+type IDiscoveryHandler interface {
+	SetKeyRing(keys *keyring.KeyRing)
+	SetAuthorization(authorization *models.Authorization)
+	SetRouter(router *mux.Router)
+	SetClientCertificateAuth(roots *x509.CertPool)
+	Handle(w http.ResponseWriter, r *http.Request)
+}
+
+// DiscoveryHandler - Authorization server metadata handler (RFC 8414, OpenID Connect Discovery)
+var DiscoveryHandler IDiscoveryHandler = &discoveryHandler{}
+
+type discoveryHandler struct {
+	mtlsConfig
+	keys          *keyring.KeyRing
+	authorization authorizationSnapshot
+	router        *mux.Router
+}
+
+// SetKeyRing - Initialize with signing and verification keys
+func (h *discoveryHandler) SetKeyRing(keys *keyring.KeyRing) {
+	h.keys = keys
+}
+
+// SetAuthorization - Initialize with authorization data
+func (h *discoveryHandler) SetAuthorization(authorization *models.Authorization) {
+	if issuerURL(authorization.GetIssuer()) == "" && authorization.GetTokenEndpointUrl() == "" {
+		logger.Error.Printf("Issuer: %s is not a URL, and no token_endpoint_url is set. Discovery publishes no endpoint URLs", authorization.GetIssuer())
+	}
+	h.authorization.Store(authorization)
+}
+
+// SetRouter - Initialize with the router serving the endpoints
+func (h *discoveryHandler) SetRouter(router *mux.Router) {
+	h.router = router
+}
+
+// Handle - Discovery Endpoint handler
+func (h *discoveryHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.metadata(h.authorization.Load()))
+}
+
+// metadata - Build metadata from the registered routes, so it always matches what is served. Endpoint
+// URLs are built from the issuer URL and token_endpoint_url only, as the Host header is chosen by the client
+func (h *discoveryHandler) metadata(authorization *models.Authorization) *models.ProviderMetadata {
+	base := issuerURL(authorization.GetIssuer())
+	tokenEndpoint := ""
+	if path := h.routePath(RouteToken); path != "" {
+		tokenEndpoint = tokenEndpointURL(authorization, path)
+	}
+	clientAuthMethods := []string{"client_secret_basic", "client_secret_post"}
+	// Client assertions and DPoP proofs are checked against the token endpoint URL, so both need one
+	if tokenEndpoint != "" {
+		clientAuthMethods = append(clientAuthMethods, "private_key_jwt")
+	}
+	if h.mtlsEnabled {
+		if h.clientCAs != nil {
+			clientAuthMethods = append(clientAuthMethods, authMethodTLSClientAuth)
+		}
+		clientAuthMethods = append(clientAuthMethods, authMethodSelfSignedTLSClientAuth)
+	}
+	m := &models.ProviderMetadata{
+		Issuer:                                authorization.GetIssuer(),
+		JwksURI:                               h.endpoint(base, RouteJwks),
+		TokenEndpoint:                         tokenEndpoint,
+		AuthorizationEndpoint:                 h.endpoint(base, RouteAuthorize),
+		IntrospectionEndpoint:                 h.endpoint(base, RouteIntrospect),
+		RevocationEndpoint:                    h.endpoint(base, RouteRevoke),
+		ScopesSupported:                       supportedScopes(authorization),
+		GrantTypesSupported:                   supportedGrantTypes(),
+		SubjectTypesSupported:                 []string{"public"},
+		IDTokenSigningAlgValuesSupported:      h.signingAlgs(),
+		TokenEndpointAuthMethodsSupported:     clientAuthMethods,
+		ResponseTypesSupported:                []string{},
+		TLSClientCertificateBoundAccessTokens: h.mtlsEnabled,
+	}
+	if tokenEndpoint != "" {
+		m.TokenEndpointAuthSigningAlgValuesSupported = signing.Algorithms
+		m.DPoPSigningAlgValuesSupported = signing.Algorithms
+	}
+	if m.AuthorizationEndpoint != "" {
+		m.ResponseTypesSupported = []string{"code"}
+		m.CodeChallengeMethodsSupported = []string{codeChallengeMethodS256}
+		// Public clients
+		m.TokenEndpointAuthMethodsSupported = append(clientAuthMethods, "none")
+	}
+	if m.IntrospectionEndpoint != "" {
+		m.IntrospectionEndpointAuthMethodsSupported = clientAuthMethods
+	}
+	if m.RevocationEndpoint != "" {
+		m.RevocationEndpointAuthMethodsSupported = clientAuthMethods
+	}
+	return m
+}
+
+// signingAlgs - Algorithms of the published keys, the signing key algorithm first
+func (h *discoveryHandler) signingAlgs() []string {
+	algs := []string{}
+	seen := map[string]bool{}
+	for _, k := range h.keys.Keys() {
+		if !seen[k.Alg] {
+			seen[k.Alg] = true
+			algs = append(algs, k.Alg)
+		}
+	}
+	return algs
+}
+
+// endpoint - Absolute URL of a named route under base. Empty if base is empty, or the route is not registered
+func (h *discoveryHandler) endpoint(base, name string) string {
+	path := h.routePath(name)
+	if base == "" || path == "" {
+		return ""
+	}
+	return base + path
+}
+
+// routePath - Path of a named route, or empty if the route is not registered
+func (h *discoveryHandler) routePath(name string) string {
+	route := h.router.Get(name)
+	if route == nil {
+		return ""
+	}
+	u, err := route.URLPath()
+	if err != nil {
+		logger.Error.Printf("Route: %s URL could not be built: %s", name, err)
+		return ""
+	}
+	return u.Path
+}
+
+// issuerURL - The issuer without trailing slash if it is an absolute URL, otherwise empty
+func issuerURL(issuer string) string {
+	if u, err := url.Parse(issuer); err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" {
+		return strings.TrimSuffix(issuer, "/")
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func noopHandler(w http.ResponseWriter, r *http.Request) {}
+
+func TestDiscoveryHandle(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/.well-known/jwks.json", noopHandler).Name(RouteJwks)
+	r.HandleFunc("/oauth/token", noopHandler).Name(RouteToken)
+	r.HandleFunc("/authorize", noopHandler).Name(RouteAuthorize)
+	h := &discoveryHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetAuthorization(&models.Authorization{Issuer: "https://auth.example.com/"})
+	h.SetRouter(r)
+
+	req, err := http.NewRequest("GET", "/.well-known/openid-configuration", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "evil.example.com"
+	req.TLS = &tls.ConnectionState{}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.Handle).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var m = &models.ProviderMetadata{}
+	if err := json.NewDecoder(rr.Body).Decode(m); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+
+	var testResp = []struct {
+		field string // metadata field
+		res   string // actual
+		exp   string // expected
+	}{
+		{"issuer", m.Issuer, "https://auth.example.com/"},
+		{"jwks_uri", m.JwksURI, "https://auth.example.com/.well-known/jwks.json"},
+		{"token_endpoint", m.TokenEndpoint, "https://auth.example.com/oauth/token"},
+		{"authorization_endpoint", m.AuthorizationEndpoint, "https://auth.example.com/authorize"},
+		{"introspection_endpoint", m.IntrospectionEndpoint, ""},
+		{"revocation_endpoint", m.RevocationEndpoint, ""},
+	}
+	for _, tc := range testResp {
+		if tc.res != tc.exp {
+			t.Errorf("%s, Expected: %v, Got: %v", tc.field, tc.exp, tc.res)
+		}
+	}
+	if !reflect.DeepEqual(m.GrantTypesSupported, supportedGrantTypes()) {
+		t.Errorf("grant_types_supported, Expected: %v, Got: %v", supportedGrantTypes(), m.GrantTypesSupported)
+	}
+	if !reflect.DeepEqual(m.IDTokenSigningAlgValuesSupported, []string{"RS256"}) {
+		t.Errorf("id_token_signing_alg_values_supported, Expected: [RS256], Got: %v", m.IDTokenSigningAlgValuesSupported)
+	}
+	if !reflect.DeepEqual(m.CodeChallengeMethodsSupported, []string{"S256"}) {
+		t.Errorf("code_challenge_methods_supported, Expected: [S256], Got: %v", m.CodeChallengeMethodsSupported)
+	}
+}
+
+func TestDiscoveryEndpointURLs(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/.well-known/jwks.json", noopHandler).Name(RouteJwks)
+	r.HandleFunc("/oauth/token", noopHandler).Name(RouteToken)
+
+	var testResp = []struct {
+		name          string
+		authorization *models.Authorization
+		jwks          string // expected jwks_uri
+		token         string // expected token_endpoint
+	}{
+		{"issuer url", &models.Authorization{Issuer: "https://auth.example.com/tenant"},
+			"https://auth.example.com/tenant/.well-known/jwks.json", "https://auth.example.com/tenant/oauth/token"},
+		{"token endpoint url", &models.Authorization{Issuer: "https://auth.example.com", TokenEndpointUrl: "https://login.example.com/oauth/token"},
+			"https://auth.example.com/.well-known/jwks.json", "https://login.example.com/oauth/token"},
+		{"token endpoint url only", &models.Authorization{Issuer: "AuthServerIssuer", TokenEndpointUrl: "https://login.example.com/oauth/token"},
+			"", "https://login.example.com/oauth/token"},
+		{"no url", &models.Authorization{Issuer: "AuthServerIssuer"}, "", ""},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			h := discoveryHandler{}
+			h.SetKeyRing(testKeyRing())
+			h.SetRouter(r)
+			m := h.metadata(tc.authorization)
+			if m.JwksURI != tc.jwks {
+				t.Errorf("jwks_uri, Expected: %q, Got: %q", tc.jwks, m.JwksURI)
+			}
+			if m.TokenEndpoint != tc.token {
+				t.Errorf("token_endpoint, Expected: %q, Got: %q", tc.token, m.TokenEndpoint)
+			}
+			// Assertions and DPoP proofs can only be checked with a token endpoint URL
+			methods := []string{"client_secret_basic", "client_secret_post"}
+			if tc.token != "" {
+				methods = append(methods, "private_key_jwt")
+			}
+			if !reflect.DeepEqual(m.TokenEndpointAuthMethodsSupported, methods) {
+				t.Errorf("token_endpoint_auth_methods_supported, Expected: %v, Got: %v", methods, m.TokenEndpointAuthMethodsSupported)
+			}
+			if dpop := len(m.DPoPSigningAlgValuesSupported) > 0; dpop != (tc.token != "") {
+				t.Errorf("dpop_signing_alg_values_supported, Expected: %v, Got: %v", tc.token != "", m.DPoPSigningAlgValuesSupported)
+			}
+		})
+	}
+}
+
+func TestDiscoveryClientCertificateAuth(t *testing.T) {
+	var testResp = []struct {
+		name  string
+		roots *x509.CertPool // client CA
+		exp   []string       // expected auth methods
+	}{
+		{"self-signed only", nil, []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "self_signed_tls_client_auth"}},
+		{"client ca", x509.NewCertPool(), []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "tls_client_auth", "self_signed_tls_client_auth"}},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			h := discoveryHandler{}
+			h.SetKeyRing(testKeyRing())
+			r := mux.NewRouter()
+			r.HandleFunc("/oauth/token", noopHandler).Name(RouteToken)
+			h.SetRouter(r)
+			h.SetClientCertificateAuth(tc.roots)
+			m := h.metadata(&models.Authorization{Issuer: "https://auth.example.com"})
+			if !reflect.DeepEqual(m.TokenEndpointAuthMethodsSupported, tc.exp) {
+				t.Errorf("token_endpoint_auth_methods_supported, Expected: %v, Got: %v", tc.exp, m.TokenEndpointAuthMethodsSupported)
+			}
+			if !m.TLSClientCertificateBoundAccessTokens {
+				t.Error("tls_client_certificate_bound_access_tokens, Expected: true")
+			}
+		})
+	}
+}
+
+func TestSupportedGrantTypes(t *testing.T) {
+	exp := []string{"authorization_code", "client_credentials", "password", "refresh_token", "urn:ietf:params:oauth:grant-type:jwt-bearer",
+		"urn:ietf:params:oauth:grant-type:token-exchange"}
+	if res := supportedGrantTypes(); !reflect.DeepEqual(res, exp) {
+		t.Errorf("supportedGrantTypes(), Expected: %v, Got: %v", exp, res)
+	}
+}
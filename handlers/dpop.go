@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+// Token type and proof type of DPoP (RFC 9449 4.2 and 5)
+const (
+	tokenTypeDPoP = "DPoP"
+	dpopProofType = "dpop+jwt"
+)
+
+// dpopProofLifetime - Proofs are accepted this long after iat, so used jti values are not kept for long
+const dpopProofLifetime = 5 * time.Minute
+
+// dpopClockSkew - Proofs with iat this far in the future are accepted
+const dpopClockSkew = time.Minute
+
+// dpopNonceLifetime - Server nonces are rotated this often. The previous nonce is still accepted
+const dpopNonceLifetime = 5 * time.Minute
+
+// SetDPoPNonceRequired - Require DPoP proofs to have a nonce given by the server in the DPoP-Nonce header
+func (h *tokenHandler) SetDPoPNonceRequired(required bool) {
+	h.dpopNonces = nil
+	if required {
+		h.dpopNonces = &nonceSource{}
+	}
+}
+
+// nonceSource - Rotating server nonce
+type nonceSource struct {
+	mu       sync.Mutex
+	current  string
+	previous string
+	rotated  time.Time
+}
+
+// nonce - The current nonce, rotated if it is too old
+func (s *nonceSource) nonce() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == "" || time.Since(s.rotated) > dpopNonceLifetime {
+		n, err := randomToken()
+		if err != nil {
+			// Keep the old nonce, rather than failing every request
+			return s.current
+		}
+		s.previous, s.current, s.rotated = s.current, n, time.Now()
+	}
+	return s.current
+}
+
+// valid - Nonce is the current or the previous nonce
+func (s *nonceSource) valid(nonce string) bool {
+	current := s.nonce()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return nonce != "" && (nonce == current || nonce == s.previous)
+}
+
+// verifyDPoPProof - Validate the DPoP proof of a token request, and return the JWK thumbprint
+// of its key (RFC 9449 4.3)
+func (h *tokenHandler) verifyDPoPProof(authorization *models.Authorization, req *models.TokenRequest) (string, error) {
+	parser := &jwt.Parser{ValidMethods: signing.Algorithms, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	token, _, err := parser.ParseUnverified(req.DPoPProof, claims)
+	if err != nil {
+		return "", errInvalidDPoPProof("DPoP proof could not be parsed: %s", err)
+	}
+	if typ, _ := token.Header["typ"].(string); typ != dpopProofType {
+		return "", errInvalidDPoPProof("DPoP proof typ must be %s", dpopProofType)
+	}
+	jwk, err := dpopKey(token.Header["jwk"])
+	if err != nil {
+		return "", errInvalidDPoPProof("DPoP proof %s", err)
+	}
+	pub, err := parseJwk(jwk)
+	if err != nil {
+		return "", errInvalidDPoPProof("DPoP proof jwk not valid: %s", err)
+	}
+	// The algorithm is given by the key type, as for client assertions
+	if alg, err := signing.Algorithm(pub); err != nil || alg != token.Method.Alg() {
+		return "", errInvalidDPoPProof("DPoP proof alg: %s does not match the jwk", token.Method.Alg())
+	}
+	if _, err := parser.Parse(req.DPoPProof, func(*jwt.Token) (interface{}, error) { return pub, nil }); err != nil {
+		return "", errInvalidDPoPProof("DPoP proof signature not valid: %s", err)
+	}
+
+	if htm, _ := claims["htm"].(string); htm != http.MethodPost {
+		return "", errInvalidDPoPProof("DPoP proof htm must be %s", http.MethodPost)
+	}
+	endpoint := tokenEndpointURL(authorization, req.EndpointPath)
+	if endpoint == "" {
+		return "", errServerError("DPoP proof htu can not be checked: set token_endpoint_url, or an issuer URL")
+	}
+	if htu, _ := claims["htu"].(string); !dpopURIValid(htu, endpoint) {
+		return "", errInvalidDPoPProof("DPoP proof htu: %s does not match the token endpoint", htu)
+	}
+	now := time.Now()
+	iat := time.Unix(int64Claim(claims, "iat"), 0)
+	if iat.After(now.Add(dpopClockSkew)) || iat.Before(now.Add(-dpopProofLifetime)) {
+		return "", errInvalidDPoPProof("DPoP proof iat is not recent")
+	}
+	if h.dpopNonces != nil {
+		if nonce, _ := claims["nonce"].(string); !h.dpopNonces.valid(nonce) {
+			return "", errUseDPoPNonce("DPoP proof must have the nonce from the DPoP-Nonce header")
+		}
+	}
+	jkt, err := jwkThumbprint(jwk)
+	if err != nil {
+		return "", errInvalidDPoPProof("DPoP proof jwk not valid: %s", err)
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", errInvalidDPoPProof("DPoP proof has no jti")
+	}
+	if err := h.useID(tokenTypeDPoP+" "+jkt+" "+jti, iat.Add(dpopProofLifetime)); err != nil {
+		if err == store.ErrReused {
+			return "", errInvalidDPoPProof("DPoP proof jti: %s already used", jti)
+		}
+		return "", errServerError("DPoP proof jti could not be stored: %s", err)
+	}
+	return jkt, nil
+}
+
+// dpopKey - The public key in the jwk header. Proofs with a private key are rejected
+func dpopKey(header interface{}) (*models.JSONWebKeys, error) {
+	m, ok := header.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("has no jwk")
+	}
+	if _, private := m["d"]; private {
+		return nil, errors.New("jwk is a private key")
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("jwk not valid: %v", err)
+	}
+	jwk := &models.JSONWebKeys{}
+	if err := json.Unmarshal(b, jwk); err != nil {
+		return nil, fmt.Errorf("jwk not valid: %v", err)
+	}
+	return jwk, nil
+}
+
+// dpopURIValid - htu is the token endpoint URL. Query and fragment are ignored (RFC 9449 4.3)
+func dpopURIValid(htu string, endpoint string) bool {
+	u, err := url.Parse(htu)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	e, err := url.Parse(endpoint)
+	return err == nil && strings.EqualFold(e.Scheme, u.Scheme) && strings.EqualFold(e.Host, u.Host) && e.Path == u.Path
+}
+
+// jwkThumbprint - base64url SHA-256 of the required members of the JWK, in lexicographic order (RFC 7638 3)
+func jwkThumbprint(k *models.JSONWebKeys) (string, error) {
+	var members interface{}
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	default:
+		return "", fmt.Errorf("key type: %s not supported", k.Kty)
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return b64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// tokenConfirmation - Confirmation claim binding tokens to the client certificate and the DPoP
+// key of the request. Nil for bearer tokens
+func (a *clientAuthenticator) tokenConfirmation(client *models.Client, req *models.TokenRequest) *models.Confirmation {
+	cnf := a.certificateConfirmation(client, req)
+	if req.DPoPJkt != "" {
+		if cnf == nil {
+			cnf = &models.Confirmation{}
+		}
+		cnf.Jkt = req.DPoPJkt
+	}
+	return cnf
+}
+
+// checkTokenBinding - Clients with bound tokens must present the certificate or DPoP key the tokens are bound to
+func (a *clientAuthenticator) checkTokenBinding(client *models.Client, req *models.TokenRequest) error {
+	if client.GetTlsClientCertificateBoundAccessTokens() && a.certificateConfirmation(client, req) == nil {
+		return errInvalidClient("Client: %s requires a client certificate for certificate-bound tokens", client.GetClientId())
+	}
+	if client.GetDpopBoundAccessTokens() && req.DPoPJkt == "" {
+		return errInvalidDPoPProof("Client: %s requires a DPoP proof", client.GetClientId())
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"crypto"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+// testDPoPKey - Key for DPoP proofs, and its public JWK as proof header
+func testDPoPKey(t *testing.T, alg string) (crypto.Signer, map[string]interface{}) {
+	t.Helper()
+	key, js := testAssertionKey(t, alg, "")
+	jwk := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(js), &jwk); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	return key, jwk
+}
+
+// testDPoPProof - DPoP proof for the token endpoint. Claims are added to valid defaults
+func testDPoPProof(t *testing.T, alg string, key crypto.Signer, jwk map[string]interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	jti, _ := randomToken()
+	c := jwt.MapClaims{
+		"htm": "POST",
+		"htu": testTokenEndpoint,
+		"iat": time.Now().Unix(),
+		"jti": jti,
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	method, _ := signing.Method(alg)
+	token := jwt.NewWithClaims(method, c)
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = jwk
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	return s
+}
+
+// testJkt - Thumbprint of the JWK
+func testJkt(t *testing.T, jwk map[string]interface{}) string {
+	t.Helper()
+	k, err := dpopKey(jwk)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	jkt, err := jwkThumbprint(k)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	return jkt
+}
+
+func TestDPoPTokenRequest(t *testing.T) {
+	a := &models.Authorization{Issuer: "Test-Issuer", TokenEndpointUrl: testTokenEndpoint, Clients: []*models.Client{
+		auth.Clients[0],
+		testClient(t, `{"client_id": "bound", "client_secret": "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G", "dpop_bound_access_tokens": true}`),
+	}}
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetReplayCache(store.NewMemoryReplayCache())
+	h.SetAuthorization(a)
+
+	ecKey, ecJwk := testDPoPKey(t, signing.ES256)
+	rsaKey, rsaJwk := testDPoPKey(t, signing.RS256)
+	edKey, edJwk := testDPoPKey(t, signing.EdDSA)
+	_, otherJwk := testDPoPKey(t, signing.ES256)
+	privateJwk := map[string]interface{}{"d": "secret"}
+	for k, v := range ecJwk {
+		privateJwk[k] = v
+	}
+	used := testDPoPProof(t, signing.ES256, ecKey, ecJwk, nil)
+	if _, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "client_credentials", ClientID: "cl1", ClientSecret: "secret1",
+		EndpointPath: testTokenPath, DPoPProof: used}); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	untyped := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"htm": "POST", "htu": testTokenEndpoint, "iat": time.Now().Unix(), "jti": "x"})
+	untyped.Header["jwk"] = ecJwk
+	noTyp, _ := untyped.SignedString(ecKey)
+
+	var testResp = []struct {
+		name      string
+		clientID  string // client, cl1 or bound
+		proof     string // DPoP header
+		jkt       string // expected cnf jkt, empty for bearer tokens
+		errorCode string // expected error, empty if none
+	}{
+		{"ES256", "cl1", testDPoPProof(t, signing.ES256, ecKey, ecJwk, nil), testJkt(t, ecJwk), ""},
+		{"RS256", "cl1", testDPoPProof(t, signing.RS256, rsaKey, rsaJwk, nil), testJkt(t, rsaJwk), ""},
+		{"EdDSA", "cl1", testDPoPProof(t, signing.EdDSA, edKey, edJwk, nil), testJkt(t, edJwk), ""},
+		{"htu with query", "cl1", testDPoPProof(t, signing.ES256, ecKey, ecJwk, jwt.MapClaims{"htu": testTokenEndpoint + "?a=b"}), testJkt(t, ecJwk), ""},
+		{"no proof", "cl1", "", "", ""},
+		{"bound client", "bound", testDPoPProof(t, signing.ES256, ecKey, ecJwk, nil), testJkt(t, ecJwk), ""},
+		{"bound client without proof", "bound", "", "", errCodeInvalidDPoPProof},
+		{"replayed", "cl1", used, "", errCodeInvalidDPoPProof},
+		{"no typ", "cl1", noTyp, "", errCodeInvalidDPoPProof},
+		{"no jwk", "cl1", testDPoPProof(t, signing.ES256, ecKey, nil, nil), "", errCodeInvalidDPoPProof},
+		{"private jwk", "cl1", testDPoPProof(t, signing.ES256, ecKey, privateJwk, nil), "", errCodeInvalidDPoPProof},
+		{"other jwk", "cl1", testDPoPProof(t, signing.ES256, ecKey, otherJwk, nil), "", errCodeInvalidDPoPProof},
+		{"alg not matching jwk", "cl1", testDPoPProof(t, signing.ES256, ecKey, rsaJwk, nil), "", errCodeInvalidDPoPProof},
+		{"wrong htm", "cl1", testDPoPProof(t, signing.ES256, ecKey, ecJwk, jwt.MapClaims{"htm": "GET"}), "", errCodeInvalidDPoPProof},
+		{"wrong htu", "cl1", testDPoPProof(t, signing.ES256, ecKey, ecJwk, jwt.MapClaims{"htu": "https://auth.example.com/oauth/revoke"}), "", errCodeInvalidDPoPProof},
+		{"no htu", "cl1", testDPoPProof(t, signing.ES256, ecKey, ecJwk, jwt.MapClaims{"htu": nil}), "", errCodeInvalidDPoPProof},
+		{"old iat", "cl1", testDPoPProof(t, signing.ES256, ecKey, ecJwk, jwt.MapClaims{"iat": time.Now().Add(-10 * time.Minute).Unix()}), "", errCodeInvalidDPoPProof},
+		{"future iat", "cl1", testDPoPProof(t, signing.ES256, ecKey, ecJwk, jwt.MapClaims{"iat": time.Now().Add(10 * time.Minute).Unix()}), "", errCodeInvalidDPoPProof},
+		{"no jti", "cl1", testDPoPProof(t, signing.ES256, ecKey, ecJwk, jwt.MapClaims{"jti": nil}), "", errCodeInvalidDPoPProof},
+		{"not a jwt", "cl1", "abc", "", errCodeInvalidDPoPProof},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "client_credentials", ClientID: tc.clientID, ClientSecret: "secret1",
+				EndpointPath: testTokenPath, DPoPProof: tc.proof})
+			if tc.errorCode != "" {
+				expectOAuthError(t, err, tc.errorCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			expType := "bearer"
+			if tc.jkt != "" {
+				expType = tokenTypeDPoP
+			}
+			if res.TokenType != expType {
+				t.Errorf("token_type, Expected: %s, Got: %s", expType, res.TokenType)
+			}
+			claims, err := parseAccessToken(h.keys, a.Issuer, nil, res.AccessToken)
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			jkt := ""
+			if cnf := confirmationClaim(claims); cnf != nil {
+				jkt = cnf.Jkt
+			}
+			if jkt != tc.jkt {
+				t.Errorf("cnf jkt, Expected: %q, Got: %q", tc.jkt, jkt)
+			}
+		})
+	}
+}
+
+func TestDPoPHost(t *testing.T) {
+	key, jwk := testDPoPKey(t, signing.ES256)
+	var testResp = []struct {
+		name             string
+		issuer           string // input
+		tokenEndpointURL string // input
+		htu              string // proof htu
+		status           int    // expected status
+	}{
+		{"issuer endpoint", "https://auth.example.com", "", testTokenEndpoint, http.StatusOK},
+		{"configured endpoint", "Test-Issuer", testTokenEndpoint, testTokenEndpoint, http.StatusOK},
+		{"spoofed host", "https://auth.example.com", "", "https://evil.example.com/oauth/token", http.StatusBadRequest},
+		{"spoofed host, configured endpoint", "Test-Issuer", testTokenEndpoint, "https://evil.example.com/oauth/token", http.StatusBadRequest},
+		{"issuer endpoint, configured endpoint", "https://auth.example.com", "https://login.example.com/oauth/token", testTokenEndpoint, http.StatusBadRequest},
+		{"no endpoint configured", "Test-Issuer", "", "https://evil.example.com/oauth/token", http.StatusInternalServerError},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			h := tokenHandler{}
+			h.SetKeyRing(testKeyRing())
+			h.SetReplayCache(store.NewMemoryReplayCache())
+			h.SetAuthorization(&models.Authorization{Issuer: tc.issuer, TokenEndpointUrl: tc.tokenEndpointURL, Clients: auth.Clients})
+			body := url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl1"}, "client_secret": {"secret1"}}
+			// The Host header is chosen by the client
+			req := httptest.NewRequest("POST", "https://evil.example.com/oauth/token", strings.NewReader(body.Encode()))
+			req.Header.Set("Content-Type", contentTypeForm)
+			req.Header.Set("DPoP", testDPoPProof(t, signing.ES256, key, jwk, jwt.MapClaims{"htu": tc.htu}))
+			rr := httptest.NewRecorder()
+			h.Handle(rr, req)
+			if rr.Code != tc.status {
+				t.Errorf("Expected: %d, Got: %d %s", tc.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestDPoPNonce(t *testing.T) {
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetReplayCache(store.NewMemoryReplayCache())
+	h.SetDPoPNonceRequired(true)
+	endpoint := "http://auth.example.com/oauth/token"
+	h.SetAuthorization(&models.Authorization{Issuer: auth.Issuer, TokenEndpointUrl: endpoint, Clients: auth.Clients})
+	key, jwk := testDPoPKey(t, signing.ES256)
+
+	post := func(proofs ...string) *httptest.ResponseRecorder {
+		body := url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl1"}, "client_secret": {"secret1"}}
+		req := httptest.NewRequest("POST", endpoint, strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", contentTypeForm)
+		for _, p := range proofs {
+			req.Header.Add("DPoP", p)
+		}
+		rr := httptest.NewRecorder()
+		h.Handle(rr, req)
+		return rr
+	}
+
+	rr := post(testDPoPProof(t, signing.ES256, key, jwk, jwt.MapClaims{"htu": endpoint}))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errCodeUseDPoPNonce) {
+		t.Fatalf("Expected %s, Got: %d %s", errCodeUseDPoPNonce, rr.Code, rr.Body.String())
+	}
+	nonce := rr.Header().Get("DPoP-Nonce")
+	if nonce == "" {
+		t.Fatal("Expected DPoP-Nonce header")
+	}
+	rr = post(testDPoPProof(t, signing.ES256, key, jwk, jwt.MapClaims{"htu": endpoint, "nonce": "other"}))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errCodeUseDPoPNonce) {
+		t.Errorf("Expected %s, Got: %d %s", errCodeUseDPoPNonce, rr.Code, rr.Body.String())
+	}
+	rr = post(testDPoPProof(t, signing.ES256, key, jwk, jwt.MapClaims{"htu": endpoint, "nonce": nonce}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, Got: %d %s", rr.Code, rr.Body.String())
+	}
+	res := &models.TokenResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), res); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if res.TokenType != tokenTypeDPoP {
+		t.Errorf("token_type, Expected: %s, Got: %s", tokenTypeDPoP, res.TokenType)
+	}
+	// The previous nonce is still accepted after a rotation
+	h.dpopNonces.rotated = time.Now().Add(-2 * dpopNonceLifetime)
+	if rr = post(testDPoPProof(t, signing.ES256, key, jwk, jwt.MapClaims{"htu": endpoint, "nonce": nonce})); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200, Got: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("DPoP-Nonce") == nonce {
+		t.Error("Expected a new DPoP-Nonce")
+	}
+
+	proof := testDPoPProof(t, signing.ES256, key, jwk, jwt.MapClaims{"htu": endpoint, "nonce": nonce})
+	if rr = post(proof, proof); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errCodeInvalidDPoPProof) {
+		t.Errorf("Expected %s, Got: %d %s", errCodeInvalidDPoPProof, rr.Code, rr.Body.String())
+	}
+}
+
+func TestDPoPRefreshToken(t *testing.T) {
+	a := &models.Authorization{Issuer: "Test-Issuer", TokenEndpointUrl: testTokenEndpoint, Clients: []*models.Client{
+		testClient(t, `{"client_id": "spa", "public": true, "allow_refresh_token": true, "scopes": ["read"]}`),
+	}}
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetReplayCache(store.NewMemoryReplayCache())
+	h.SetAuthorization(a)
+	h.SetRefreshTokenStore(store.NewMemoryRefreshTokenStore())
+	key, jwk := testDPoPKey(t, signing.ES256)
+	otherKey, otherJwk := testDPoPKey(t, signing.ES256)
+
+	issued, err := h.issueTokens(&tokenGrant{authorization: h.authorization.Load(), client: a.Clients[0], scope: []string{"read"}, cnf: &models.Confirmation{Jkt: testJkt(t, jwk)}}, "")
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	refresh := func(proof string) (*models.TokenResponse, error) {
+		return h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "refresh_token", ClientID: "spa", RefreshToken: issued.RefreshToken,
+			EndpointPath: testTokenPath, DPoPProof: proof})
+	}
+	// Failed attempts do not use the token
+	_, err = refresh("")
+	expectOAuthError(t, err, errCodeInvalidDPoPProof)
+	_, err = refresh(testDPoPProof(t, signing.ES256, otherKey, otherJwk, nil))
+	expectOAuthError(t, err, errCodeInvalidDPoPProof)
+
+	res, err := refresh(testDPoPProof(t, signing.ES256, key, jwk, nil))
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if res.TokenType != tokenTypeDPoP || res.RefreshToken == "" {
+		t.Errorf("Expected DPoP token and refresh token, Got: %s %q", res.TokenType, res.RefreshToken)
+	}
+	rt, err := h.refreshStore.Get(hashToken(res.RefreshToken))
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if rt.Jkt != testJkt(t, jwk) {
+		t.Errorf("Rotated refresh token jkt, Expected: %s, Got: %s", testJkt(t, jwk), rt.Jkt)
+	}
+}
+
+func TestDPoPBoundTokenExchange(t *testing.T) {
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetReplayCache(store.NewMemoryReplayCache())
+	h.SetAuthorization(auth)
+	_, jwk := testDPoPKey(t, signing.ES256)
+	jkt := testJkt(t, jwk)
+	token, err := h.generateJWT(&tokenGrant{authorization: h.authorization.Load(), client: auth.Clients[0], cnf: &models.Confirmation{Jkt: jkt}})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if _, err := h.parseExchangeToken(h.authorization.Load(), token, &models.TokenRequest{DPoPJkt: jkt}); err != nil {
+		t.Errorf("Got uinexpected error: %v", err)
+	}
+	if _, err := h.parseExchangeToken(h.authorization.Load(), token, &models.TokenRequest{}); err == nil {
+		t.Error("Expected error for DPoP-bound token without proof")
+	}
+}
+
+func TestJwkThumbprint(t *testing.T) {
+	// RFC 7638 3.1
+	rsa := &models.JSONWebKeys{Kty: "RSA", Alg: "RS256", Kid: "2011-04-29", E: "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"}
+	var testResp = []struct {
+		key *models.JSONWebKeys // key
+		exp string              // expected thumbprint, empty for an error
+	}{
+		{rsa, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{&models.JSONWebKeys{Kty: "oct"}, ""},
+	}
+	for _, tc := range testResp {
+		res, err := jwkThumbprint(tc.key)
+		if tc.exp == "" {
+			if err == nil {
+				t.Errorf("jwkThumbprint(%s), Expected error", tc.key.Kty)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Got uinexpected error: %v", err)
+		}
+		if res != tc.exp {
+			t.Errorf("jwkThumbprint(%s), Expected: %s, Got: %s", tc.key.Kty, tc.exp, res)
+		}
+	}
+}
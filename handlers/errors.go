@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// RFC 6749 5.2 error codes
+const (
+	errCodeInvalidRequest       = "invalid_request"
+	errCodeInvalidClient        = "invalid_client"
+	errCodeInvalidGrant         = "invalid_grant"
+	errCodeUnauthorizedClient   = "unauthorized_client"
+	errCodeUnsupportedGrantType = "unsupported_grant_type"
+	errCodeInvalidScope         = "invalid_scope"
+	errCodeServerError          = "server_error"
+	// RFC 8707 2 resource indicators
+	errCodeInvalidTarget = "invalid_target"
+	// RFC 9449 5 and 8 DPoP
+	errCodeInvalidDPoPProof = "invalid_dpop_proof"
+	errCodeUseDPoPNonce     = "use_dpop_nonce"
+	// RFC 6749 4.1.2.1 authorization endpoint only
+	errCodeUnsupportedResponseType = "unsupported_response_type"
+	// RFC 6749 4.1.2.1, used for rate limited requests and overload
+	errCodeTemporarilyUnavailable = "temporarily_unavailable"
+)
+
+// authRealm - Realm used in WWW-Authenticate challenges
+const authRealm = "oauth"
+
+// oauthError - Error returned to the client as an RFC 6749 5.2 error response
+type oauthError struct {
+	Code        string
+	Description string
+	Status      int
+	// RetryAfter - Sent in the Retry-After header if set
+	RetryAfter time.Duration
+}
+
+func (e *oauthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func newOAuthError(code string, status int, format string, a ...interface{}) *oauthError {
+	return &oauthError{Code: code, Description: fmt.Sprintf(format, a...), Status: status}
+}
+
+// errInvalidRequest - Request is missing a parameter or is otherwise malformed
+func errInvalidRequest(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeInvalidRequest, http.StatusBadRequest, format, a...)
+}
+
+// errInvalidClient - Client authentication failed
+func errInvalidClient(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeInvalidClient, http.StatusUnauthorized, format, a...)
+}
+
+// errInvalidGrant - Grant or refresh token is invalid, expired or revoked
+func errInvalidGrant(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeInvalidGrant, http.StatusBadRequest, format, a...)
+}
+
+// errUnauthorizedClient - Client is not allowed to use the grant type
+func errUnauthorizedClient(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeUnauthorizedClient, http.StatusBadRequest, format, a...)
+}
+
+// errUnsupportedGrantType - Grant type is not supported by the server
+func errUnsupportedGrantType(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeUnsupportedGrantType, http.StatusBadRequest, format, a...)
+}
+
+// errInvalidScope - Requested scope is invalid or exceeds what the client is granted
+func errInvalidScope(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeInvalidScope, http.StatusBadRequest, format, a...)
+}
+
+// errInvalidTarget - Requested audience is unknown or not granted to the client
+func errInvalidTarget(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeInvalidTarget, http.StatusBadRequest, format, a...)
+}
+
+// errInvalidDPoPProof - DPoP proof is missing or not valid
+func errInvalidDPoPProof(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeInvalidDPoPProof, http.StatusBadRequest, format, a...)
+}
+
+// errUseDPoPNonce - DPoP proof must have the nonce from the DPoP-Nonce response header
+func errUseDPoPNonce(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeUseDPoPNonce, http.StatusBadRequest, format, a...)
+}
+
+// errUnsupportedResponseType - Response type is not supported by the authorization endpoint
+func errUnsupportedResponseType(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeUnsupportedResponseType, http.StatusBadRequest, format, a...)
+}
+
+// errTooManyRequests - Caller is rate limited or locked out, and can retry after retryAfter
+func errTooManyRequests(retryAfter time.Duration, format string, a ...interface{}) *oauthError {
+	oe := newOAuthError(errCodeTemporarilyUnavailable, http.StatusTooManyRequests, format, a...)
+	oe.RetryAfter = retryAfter
+	return oe
+}
+
+// errServiceUnavailable - Server is overloaded. The client can retry shortly
+func errServiceUnavailable(format string, a ...interface{}) *oauthError {
+	oe := newOAuthError(errCodeTemporarilyUnavailable, http.StatusServiceUnavailable, format, a...)
+	oe.RetryAfter = time.Second
+	return oe
+}
+
+// errServerError - Unexpected server side failure. Description is never sent to the client
+func errServerError(format string, a ...interface{}) *oauthError {
+	return newOAuthError(errCodeServerError, http.StatusInternalServerError, format, a...)
+}
+
+// toOAuthError - Errors that are not an oauthError are treated as server errors
+func toOAuthError(err error) *oauthError {
+	if oe, ok := err.(*oauthError); ok {
+		return oe
+	}
+	return errServerError("%s", err)
+}
+
+// writeError - Write err as an RFC 6749 5.2 error response
+func writeError(w http.ResponseWriter, err error) {
+	oe := toOAuthError(err)
+	logger.Warning.Println(oe)
+
+	res := &models.ErrorResponse{Error: oe.Code, ErrorDescription: oe.Description}
+	if oe.Code == errCodeServerError {
+		// Do not leak internal details
+		res.ErrorDescription = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if oe.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, authRealm))
+	}
+	setRetryAfter(w, oe.RetryAfter)
+	w.WriteHeader(oe.Status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// setRetryAfter - Set the Retry-After header if d is set
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d > 0 {
+		// Whole seconds, rounded up so the caller does not retry too early
+		w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+	}
+}
+
+// setNoCache - Token responses must never be cached (RFC 6749 5.1)
+func setNoCache(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func TestWriteError(t *testing.T) {
+	var testResp = []struct {
+		err    error  // input
+		code   string // expected error code
+		desc   string // expected error_description
+		status int    // expected status code
+	}{
+		{errInvalidRequest("grant_type is required"), "invalid_request", "grant_type is required", http.StatusBadRequest},
+		{errInvalidClient("Client %s unknown", "cl1"), "invalid_client", "Client cl1 unknown", http.StatusUnauthorized},
+		{errInvalidGrant("expired"), "invalid_grant", "expired", http.StatusBadRequest},
+		{errUnauthorizedClient("not allowed"), "unauthorized_client", "not allowed", http.StatusBadRequest},
+		{errUnsupportedGrantType("nope"), "unsupported_grant_type", "nope", http.StatusBadRequest},
+		{errInvalidScope("too much"), "invalid_scope", "too much", http.StatusBadRequest},
+		{errServerError("secret internals"), "server_error", "", http.StatusInternalServerError},
+		{errors.New("plain error"), "server_error", "", http.StatusInternalServerError},
+	}
+
+	for _, tc := range testResp {
+		t.Run(tc.code+tc.desc, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			writeError(rr, tc.err)
+
+			if rr.Code != tc.status {
+				t.Errorf("writeError(%v), Expected status: %v, Got: %v", tc.err, tc.status, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("writeError(%v), Expected Content-Type: application/json, Got: %v", tc.err, ct)
+			}
+			wa := rr.Header().Get("WWW-Authenticate")
+			if tc.status == http.StatusUnauthorized && wa == "" {
+				t.Errorf("writeError(%v), Expected WWW-Authenticate header", tc.err)
+			}
+			if tc.status != http.StatusUnauthorized && wa != "" {
+				t.Errorf("writeError(%v), Unexpected WWW-Authenticate header: %v", tc.err, wa)
+			}
+			var res = &models.ErrorResponse{}
+			if err := json.NewDecoder(rr.Body).Decode(res); err != nil {
+				t.Errorf("Got uinexpected error: %v", err)
+			}
+			if res.Error != tc.code {
+				t.Errorf("writeError(%v), Expected error: %v, Got: %v", tc.err, tc.code, res.Error)
+			}
+			if res.ErrorDescription != tc.desc {
+				t.Errorf("writeError(%v), Expected error_description: %v, Got: %v", tc.err, tc.desc, res.ErrorDescription)
+			}
+		})
+	}
+}
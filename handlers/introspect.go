@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+
+	"github.com/jafossum/go-auth-server/crypto/keyring"
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+//go:generate mockgen -destination=../mocks/introspect_handler_mock.go -package=mocks github.com/jafossum/go-auth-server/handlers IIntrospectHandler
+
+// IIntrospectHandler : IntrospectHandler Interace
+type IIntrospectHandler interface {
+	SetKeyRing(keys *keyring.KeyRing)
+	SetAuthorization(authorization *models.Authorization)
+	SetRevocationStore(revocations store.RevocationStore)
+	SetClientCertificateAuth(roots *x509.CertPool)
+	SetReplayCache(replays store.ReplayCache)
+	SetVerifyPool(pool *passwd.Pool)
+	SetSecretUpdater(u SecretUpdater)
+	Handle(w http.ResponseWriter, r *http.Request)
+}
+
+// IntrospectHandler - Token introspection handler (RFC 7662)
+var IntrospectHandler IIntrospectHandler = &introspectHandler{}
+
+type introspectHandler struct {
+	clientAuthenticator
+	keys          *keyring.KeyRing
+	authorization authorizationSnapshot
+	revocations   store.RevocationStore
+}
+
+// SetKeyRing - Initialize with signing and verification keys
+func (h *introspectHandler) SetKeyRing(keys *keyring.KeyRing) {
+	h.keys = keys
+}
+
+// SetAuthorization - Initialize with authorization data
+func (h *introspectHandler) SetAuthorization(authorization *models.Authorization) {
+	h.authorization.Store(authorization)
+}
+
+// SetRevocationStore - Initialize with revoked token storage
+func (h *introspectHandler) SetRevocationStore(revocations store.RevocationStore) {
+	h.revocations = revocations
+}
+
+// Handle - Introspection Endpoint handler. Only clients with allow_introspection can call it
+func (h *introspectHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	setNoCache(w)
+
+	req, err := parseTokenRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	authorization := h.authorization.Load()
+	client, err := h.authenticateClient(authorization, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if client.GetPublic() || !client.GetAllowIntrospection() {
+		writeError(w, newOAuthError(errCodeUnauthorizedClient, http.StatusForbidden,
+			"Client: %s is not allowed to introspect tokens", client.GetClientId()))
+		return
+	}
+	if req.Token == "" {
+		writeError(w, errInvalidRequest("token is required"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.introspect(authorization, req.Token))
+}
+
+// introspect - Inactive tokens give no other information than active: false
+func (h *introspectHandler) introspect(authorization *models.Authorization, token string) *models.IntrospectionResponse {
+	claims, err := parseAccessToken(h.keys, authorization.GetIssuer(), h.revocations, token)
+	if err != nil {
+		logger.Info.Printf("Introspected token is not active: %s", err)
+		return &models.IntrospectionResponse{Active: false}
+	}
+	res := &models.IntrospectionResponse{
+		Active:    true,
+		TokenType: "access_token",
+		Scope:     stringClaim(claims, "scope"),
+		ClientID:  stringClaim(claims, "client_id"),
+		Sub:       stringClaim(claims, "sub"),
+		Aud:       stringClaim(claims, "aud"),
+		Iss:       stringClaim(claims, "iss"),
+		Jti:       stringClaim(claims, "jti"),
+		Exp:       int64Claim(claims, "exp"),
+		Iat:       int64Claim(claims, "iat"),
+		Nbf:       int64Claim(claims, "nbf"),
+		Cnf:       confirmationClaim(claims),
+		Act:       parseActorClaim(claims["act"]),
+	}
+	if res.Cnf != nil && res.Cnf.Jkt != "" {
+		res.TokenType = tokenTypeDPoP
+	}
+	if res.Sub != "" && res.Sub != res.ClientID {
+		// User token
+		res.Username = res.Sub
+	}
+	return res
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// confirmationClaim - The cnf claim of a bound token. Nil for bearer tokens
+func confirmationClaim(claims map[string]interface{}) *models.Confirmation {
+	cnf, ok := claims["cnf"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return &models.Confirmation{X5tS256: stringClaim(cnf, "x5t#S256"), Jkt: stringClaim(cnf, "jkt")}
+}
+
+// int64Claim - Numeric claims are decoded as float64
+func int64Claim(claims map[string]interface{}, name string) int64 {
+	f, _ := claims[name].(float64)
+	return int64(f)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+var introspectAuth = &models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+	&models.Client{ClientId: "cl1", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G", Scope: "sc"},
+	&models.Client{ClientId: "rs", ClientSecret: "$2a$10$a/JANxkdgbJtc0i36ZEk.eVxoUaMdvMhr/k4fpjL5kTbAeZJFpeIm", AllowIntrospection: true},
+	&models.Client{ClientId: "pub", Public: true, AllowIntrospection: true}}}
+
+func doIntrospect(h *introspectHandler, user, pass, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/oauth/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(user, pass)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.Handle).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIntrospectHandle(t *testing.T) {
+	keys := testKeyRing()
+	th := tokenHandler{}
+	th.SetKeyRing(keys)
+	th.SetAuthorization(introspectAuth)
+	tr, err := th.handleClientCredentials(th.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Audience: "Aud"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	h := &introspectHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(introspectAuth)
+
+	var testResp = []struct {
+		name       string // test name
+		user, pass string // client credentials
+		token      string // token to introspect
+		status     int    // expected status code
+		active     bool   // expected active
+	}{
+		{"active", "rs", "secret2", tr.AccessToken, http.StatusOK, true},
+		{"garbage", "rs", "secret2", "garbage", http.StatusOK, false},
+		{"missing token", "rs", "secret2", "", http.StatusBadRequest, false},
+		{"wrong secret", "rs", "secret1", tr.AccessToken, http.StatusUnauthorized, false},
+		{"not allowed", "cl1", "secret1", tr.AccessToken, http.StatusForbidden, false},
+		{"public client", "pub", "", tr.AccessToken, http.StatusForbidden, false},
+	}
+
+	for _, tc := range testResp {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doIntrospect(h, tc.user, tc.pass, tc.token)
+			if rr.Code != tc.status {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.status)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var res = &models.IntrospectionResponse{}
+			if err := json.NewDecoder(rr.Body).Decode(res); err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			if res.Active != tc.active {
+				t.Errorf("Expected active: %v, Got: %v", tc.active, res.Active)
+			}
+			if !res.Active {
+				if res.ClientID != "" || res.Exp != 0 {
+					t.Errorf("Inactive token must not include claims, Got: %v", res)
+				}
+				return
+			}
+			if res.ClientID != "cl1" || res.Sub != "cl1" || res.Aud != "Aud" || res.Iss != "Test-Issuer" || res.Scope != "sc" || res.Exp == 0 || res.Jti == "" {
+				t.Errorf("Unexpected introspection response: %v", res)
+			}
+			if res.Username != "" {
+				t.Errorf("Client token should not have username, Got: %v", res.Username)
+			}
+		})
+	}
+}
+
+func TestIntrospectRevoked(t *testing.T) {
+	keys := testKeyRing()
+	th := tokenHandler{}
+	th.SetKeyRing(keys)
+	th.SetAuthorization(introspectAuth)
+	tr, err := th.handleClientCredentials(th.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	revocations := store.NewMemoryRevocationStore()
+	h := &introspectHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(introspectAuth)
+	h.SetRevocationStore(revocations)
+
+	res := h.introspect(h.authorization.Load(), tr.AccessToken)
+	if !res.Active {
+		t.Fatal("Expected active token before revocation")
+	}
+	revocations.Revoke(res.Jti, time.Unix(res.Exp, 0))
+	if res := h.introspect(h.authorization.Load(), tr.AccessToken); res.Active {
+		t.Error("Expected revoked token to be inactive")
+	}
+}
@@ -1,12 +1,20 @@
 package handlers
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/x509"
+	b64 "encoding/base64"
 	"encoding/json"
+	"fmt"
+	"math/big"
 	"net/http"
 
 	"github.com/jafossum/go-auth-server/crypto/base64"
-	rsaa "github.com/jafossum/go-auth-server/crypto/rsa"
+	"github.com/jafossum/go-auth-server/crypto/keyring"
 	"github.com/jafossum/go-auth-server/models"
 	"github.com/jafossum/go-auth-server/utils/logger"
 )
@@ -15,7 +23,7 @@ import (
 
 // IJwksHandler : JwksHandler Interace
 type IJwksHandler interface {
-	SetCertificate(privateKey *rsa.PrivateKey)
+	SetKeyRing(keys *keyring.KeyRing)
 	Handle(w http.ResponseWriter, r *http.Request)
 }
 
@@ -23,12 +31,12 @@ type IJwksHandler interface {
 var JwksHandler IJwksHandler = &jwksHandler{}
 
 type jwksHandler struct {
-	privateKey *rsa.PrivateKey
+	keys *keyring.KeyRing
 }
 
-// SetCertificate - Initialize with setting certificates
-func (h *jwksHandler) SetCertificate(privateKey *rsa.PrivateKey) {
-	h.privateKey = privateKey
+// SetKeyRing - Initialize with signing and verification keys
+func (h *jwksHandler) SetKeyRing(keys *keyring.KeyRing) {
+	h.keys = keys
 }
 
 // Handle - JWKS Endpoint handler
@@ -45,24 +53,111 @@ func (h *jwksHandler) Handle(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *jwksHandler) createJwks() (*models.Jwks, error) {
-	pub, err := rsaa.GetPublicKey(&h.privateKey.PublicKey)
-	if err != nil {
-		return nil, err
+	jwks := &models.Jwks{Keys: []models.JSONWebKeys{}}
+	for _, k := range h.keys.Keys() {
+		key, err := createJwk(k)
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, *key)
 	}
-	fp, err := rsaa.GetSha1Thumbprint(&h.privateKey.PublicKey)
+	return jwks, nil
+}
+
+// createJwk - JSON Web Key for one key in the key ring
+func createJwk(k *keyring.Key) (*models.JSONWebKeys, error) {
+	pub, err := x509.MarshalPKIXPublicKey(k.PublicKey)
 	if err != nil {
 		return nil, err
 	}
 
 	key := &models.JSONWebKeys{}
-	key.Alg = "RSA256"
-	key.Kty = "RSA"
+	key.Alg = k.Alg
 	key.Use = "sig"
-	key.X5c = []string{pub}
-	key.N = base64.EncodeToString(h.privateKey.PublicKey.N.Bytes())
-	key.E = base64.EncodeUint64ToString(uint64(h.privateKey.E))
-	key.Kid = fp
-	key.X5t = fp
+	key.X5c = []string{base64.EncodeToString(pub)}
+	key.Kid = k.ID
+	key.X5t = k.ID
+
+	switch pk := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = b64.RawURLEncoding.EncodeToString(pk.N.Bytes())
+		key.E = b64.RawURLEncoding.EncodeToString(big.NewInt(int64(pk.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// Coordinates are padded to the curve size (RFC 7518 6.2.1.2)
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = pk.Curve.Params().Name
+		key.X = b64.RawURLEncoding.EncodeToString(padBytes(pk.X.Bytes(), size))
+		key.Y = b64.RawURLEncoding.EncodeToString(padBytes(pk.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = b64.RawURLEncoding.EncodeToString(pk)
+	default:
+		return nil, fmt.Errorf("Key type: %T not supported", k.PublicKey)
+	}
+
+	return key, nil
+}
+
+// padBytes - Left pad b with zeros to size bytes
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	res := make([]byte, size)
+	copy(res[size-len(b):], b)
+	return res
+}
+
+// parseJwk - Public key of a JSON Web Key. The inverse of createJwk
+func parseJwk(key *models.JSONWebKeys) (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeJwkField(key.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("RSA key: %s has no valid n", key.Kid)
+		}
+		e, err := decodeJwkField(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("RSA key: %s has no valid e", key.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("EC key: %s curve: %s not supported", key.Kid, key.Crv)
+		}
+		x, errX := decodeJwkField(key.X)
+		y, errY := decodeJwkField(key.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("EC key: %s has no valid x and y", key.Kid)
+		}
+		pk := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pk.X, pk.Y) {
+			return nil, fmt.Errorf("EC key: %s is not on curve: %s", key.Kid, key.Crv)
+		}
+		return pk, nil
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, fmt.Errorf("OKP key: %s curve: %s not supported", key.Kid, key.Crv)
+		}
+		x, err := decodeJwkField(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("OKP key: %s has no valid x", key.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("Key: %s type: %s not supported", key.Kid, key.Kty)
+}
 
-	return &models.Jwks{Keys: []models.JSONWebKeys{*key}}, nil
+// decodeJwkField - Decode a key parameter. Only base64url without padding is valid (RFC 7518 2)
+func decodeJwkField(s string) ([]byte, error) {
+	return b64.RawURLEncoding.DecodeString(s)
 }
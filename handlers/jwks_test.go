@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
@@ -8,9 +9,13 @@ import (
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/jafossum/go-auth-server/crypto/keyring"
 	rsaa "github.com/jafossum/go-auth-server/crypto/rsa"
+	"github.com/jafossum/go-auth-server/crypto/signing"
 	"github.com/jafossum/go-auth-server/models"
 	"github.com/jafossum/go-auth-server/utils/logger"
 )
@@ -22,9 +27,9 @@ func init() {
 
 func TestJwksHandler(t *testing.T) {
 	// Build handler
-	key, _ := rsaa.ParseRsaKeys("../test-resources/private.pem", "", "../test-resources/public.pem")
+	keys := testKeyRing()
 	h := JwksHandler
-	h.SetCertificate(key)
+	h.SetKeyRing(keys)
 
 	// Create a request to pass to our handler. We don't have any query parameters for now, so we'll
 	req, err := http.NewRequest("GET", "/.well-known/jwks.json", nil)
@@ -50,14 +55,14 @@ func TestJwksHandler(t *testing.T) {
 	var b = &models.Jwks{}
 	_ = json.NewDecoder(rr.Body).Decode(b)
 
-	var res = string(len(b.Keys))
-	var exp = string(1)
+	var res = strconv.Itoa(len(b.Keys))
+	var exp = strconv.Itoa(1)
 	if res != exp {
 		t.Errorf("Expected: %v, but got: %v", exp, res)
 	}
 	k := b.Keys[0]
 	res = k.Alg
-	exp = "RSA256"
+	exp = "RS256"
 	if res != exp {
 		t.Errorf("Expected: %v, but got: %v", exp, res)
 	}
@@ -69,22 +74,21 @@ func TestJwksHandler(t *testing.T) {
 }
 
 func TestCreateJwks(t *testing.T) {
-	key, _ := rsaa.ParseRsaKeys("../test-resources/private.pem", "", "../test-resources/public.pem")
-	j := jwksHandler{key}
+	j := jwksHandler{testKeyRing()}
 
 	keys, err := j.createJwks()
 	if err != nil {
 		t.Errorf("EUnexpected error: %v", err)
 	}
 
-	var res = string(len(keys.Keys))
-	var exp = string(1)
+	var res = strconv.Itoa(len(keys.Keys))
+	var exp = strconv.Itoa(1)
 	if res != exp {
 		t.Errorf("Expected: %v, but got: %v", exp, res)
 	}
 	k := keys.Keys[0]
 	res = k.Alg
-	exp = "RSA256"
+	exp = "RS256"
 	if res != exp {
 		t.Errorf("Expected: %v, but got: %v", exp, res)
 	}
@@ -104,7 +108,7 @@ func TestCreateJwks(t *testing.T) {
 		t.Errorf("Expected: %v, but got: %v", exp, res)
 	}
 	res = k.N
-	exp = "ss+dtqxnhnROqkhnrDvqpP8pLLcaf0elK+JMajJ04a7T+dyd2fdq5PJbfqh0O39IbrIZblYkf2kLqp61qEqHpPs2NfIGKYG27YOInbBqddeGhaCxPf727G77Bwu0FQYgWc1j9Yv8kw65e9Zpfc8KzKU3pwQwwMnkCxc9r84d3Z9uNyOI2c/VVeRUqMreqDvLoIYKDc4rn3Q8z3gGMuyWzImzt/9MF6Kitp8ueYWPpGCxJVjaO7DabZHTujIFwC+YjS6vQzhs9pucwgcUvhOEQwRqR4NEG+jnAajtKKmKyDn0/o9V5r3Mo/qLrXNblw3G/EhW5y1Su4OdY8r90bdrqw"
+	exp = "ss-dtqxnhnROqkhnrDvqpP8pLLcaf0elK-JMajJ04a7T-dyd2fdq5PJbfqh0O39IbrIZblYkf2kLqp61qEqHpPs2NfIGKYG27YOInbBqddeGhaCxPf727G77Bwu0FQYgWc1j9Yv8kw65e9Zpfc8KzKU3pwQwwMnkCxc9r84d3Z9uNyOI2c_VVeRUqMreqDvLoIYKDc4rn3Q8z3gGMuyWzImzt_9MF6Kitp8ueYWPpGCxJVjaO7DabZHTujIFwC-YjS6vQzhs9pucwgcUvhOEQwRqR4NEG-jnAajtKKmKyDn0_o9V5r3Mo_qLrXNblw3G_EhW5y1Su4OdY8r90bdrqw"
 	if res != exp {
 		t.Errorf("Expected: %v, but got: %v", exp, res)
 	}
@@ -127,7 +131,8 @@ func TestCreateJwks(t *testing.T) {
 
 func TestCreateJwksNEToPrivateKey(t *testing.T) {
 	key, _ := rsaa.ParseRsaKeys("../test-resources/private.pem", "", "../test-resources/public.pem")
-	j := jwksHandler{key}
+	ring, _ := keyring.New(key)
+	j := jwksHandler{ring}
 
 	keys, err := j.createJwks()
 	if err != nil {
@@ -136,7 +141,7 @@ func TestCreateJwksNEToPrivateKey(t *testing.T) {
 	k := keys.Keys[0]
 
 	// decode the base64 bytes for n
-	nb, err := base64.RawStdEncoding.DecodeString(k.N)
+	nb, err := base64.RawURLEncoding.DecodeString(k.N)
 	if err != nil {
 		t.Errorf("Dekode N failure: %v", err)
 	}
@@ -185,3 +190,123 @@ func TestCreateJwksNEToPrivateKey(t *testing.T) {
 		fmt.Println(out.String())
 	*/
 }
+
+// testKeyRing - Key ring with the test-resources key as signing key
+func testKeyRing() *keyring.KeyRing {
+	key, _ := rsaa.ParseRsaKeys("../test-resources/private.pem", "", "../test-resources/public.pem")
+	keys, _ := keyring.New(key)
+	return keys
+}
+
+func TestCreateJwksRotated(t *testing.T) {
+	keys := testKeyRing()
+	old := keys.Signing().ID
+	next, _ := rsa.GenerateKey(rand.Reader, 1024)
+	if _, err := keys.Rotate(next, time.Hour); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	j := jwksHandler{keys}
+
+	jwks, err := j.createJwks()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, but got: %v", len(jwks.Keys))
+	}
+	// Active signing key first
+	if jwks.Keys[0].Kid != keys.Signing().ID {
+		t.Errorf("Expected: %v, but got: %v", keys.Signing().ID, jwks.Keys[0].Kid)
+	}
+	if jwks.Keys[1].Kid != old {
+		t.Errorf("Expected: %v, but got: %v", old, jwks.Keys[1].Kid)
+	}
+}
+
+func TestCreateJwksECAndEdDSA(t *testing.T) {
+	var testResp = []struct {
+		alg  string // key algorithm
+		kty  string // expected key type
+		crv  string // expected curve
+		size int    // expected coordinate size in bytes
+		y    bool   // expect y coordinate
+	}{
+		{signing.ES256, "EC", "P-256", 32, true},
+		{signing.ES384, "EC", "P-384", 48, true},
+		{signing.EdDSA, "OKP", "Ed25519", 32, false},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.alg, func(t *testing.T) {
+			key, _ := signing.GenerateKey(tc.alg)
+			keys, _ := keyring.New(key)
+			j := jwksHandler{keys}
+
+			jwks, err := j.createJwks()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			k := jwks.Keys[0]
+			if k.Alg != tc.alg || k.Kty != tc.kty || k.Crv != tc.crv || k.Kid != keys.Signing().ID {
+				t.Errorf("Expected alg: %s, kty: %s, crv: %s, Got: %+v", tc.alg, tc.kty, tc.crv, k)
+			}
+			if k.N != "" || k.E != "" {
+				t.Errorf("Expected no RSA parameters, Got n: %s, e: %s", k.N, k.E)
+			}
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || len(x) != tc.size {
+				t.Errorf("Expected x of %d bytes, Got: %d, %v", tc.size, len(x), err)
+			}
+			y, err := base64.RawURLEncoding.DecodeString(k.Y)
+			if tc.y && (err != nil || len(y) != tc.size) {
+				t.Errorf("Expected y of %d bytes, Got: %d, %v", tc.size, len(y), err)
+			}
+			if !tc.y && k.Y != "" {
+				t.Errorf("Expected no y, Got: %s", k.Y)
+			}
+		})
+	}
+}
+
+func TestParseJwk(t *testing.T) {
+	std := func(b []byte) string { return base64.RawStdEncoding.EncodeToString(b) }
+	for _, alg := range []string{signing.RS256, signing.ES256, signing.ES384, signing.EdDSA} {
+		key, _ := signing.GenerateKey(alg)
+		keys, _ := keyring.New(key)
+		jwk, err := createJwk(keys.Signing())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		pub, err := parseJwk(jwk)
+		if err != nil {
+			t.Fatalf("%s, Unexpected error: %v", alg, err)
+		}
+		if kid, _ := signing.Thumbprint(pub); kid != keys.Signing().ID {
+			t.Errorf("%s, Expected the published key, Got kid: %s", alg, kid)
+		}
+	}
+
+	// Bytes that encode differently in the standard and URL alphabets
+	x := make([]byte, 32)
+	for i := range x {
+		x[i] = 0xfb
+	}
+	var testResp = []struct {
+		name string
+		x    string // input
+		err  bool   // expect error
+	}{
+		{"base64url", base64.RawURLEncoding.EncodeToString(x), false},
+		{"standard alphabet", std(x), true},
+		{"padded", base64.URLEncoding.EncodeToString(x), true},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseJwk(&models.JSONWebKeys{Kty: "OKP", Crv: "Ed25519", X: tc.x})
+			if (err != nil) != tc.err {
+				t.Errorf("Expected error: %v, Got: %v", tc.err, err)
+			}
+		})
+	}
+}
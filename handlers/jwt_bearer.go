@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// GrantTypeJWTBearer - Exchange a JWT from a trusted issuer for an access token (RFC 7523 2.1)
+const GrantTypeJWTBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// handleJWTBearer - Issue a token to the client mapped from the assertion subject. Client
+// authentication is optional (RFC 7523 3.1), but a client that identifies itself must be the mapped client
+func (h *tokenHandler) handleJWTBearer(authorization *models.Authorization, req *models.TokenRequest) (*models.TokenResponse, error) {
+	if req.Assertion == "" {
+		return nil, errInvalidRequest("assertion is required")
+	}
+	issuer, claims, err := h.verifyTrustedAssertion(authorization, req)
+	if err != nil {
+		return nil, err
+	}
+	sub := stringClaim(claims, "sub")
+	rule := matchSubjectRule(issuer, claims)
+	if rule == nil {
+		return nil, errInvalidGrant("No rule of issuer: %s matches subject: %s", issuer.GetIssuer(), sub)
+	}
+	client := findClient(authorization, rule.GetClientId())
+	if client == nil {
+		logger.Error.Printf("Trusted issuer: %s rule maps to unknown client: %s", issuer.GetIssuer(), rule.GetClientId())
+		return nil, errInvalidGrant("No rule of issuer: %s matches subject: %s", issuer.GetIssuer(), sub)
+	}
+	clientID := req.ClientID
+	if req.ClientSecret != "" || req.ClientAssertion != "" {
+		authenticated, err := h.authenticateClient(authorization, req)
+		if err != nil {
+			return nil, err
+		}
+		clientID = authenticated.GetClientId()
+	}
+	if clientID != "" && clientID != client.GetClientId() {
+		return nil, errInvalidGrant("Assertion subject: %s is not mapped to client: %s", sub, clientID)
+	}
+	if !allowsGrant(client, GrantTypeJWTBearer) {
+		return nil, errUnauthorizedClient("Client: %s is not allowed to use grant_type: %s", client.GetClientId(), GrantTypeJWTBearer)
+	}
+	if err := h.checkTokenBinding(client, req); err != nil {
+		return nil, err
+	}
+	if err := h.limitClient(client); err != nil {
+		return nil, err
+	}
+	audience, err := resolveAudience(client, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	// Rule scopes the client has lost are not issued
+	scope, err := resolveScope(intersectScopes(rule.GetScopes(), clientScopes(client)), req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Assertion from issuer: %s subject: %s exchanged for client: %s", issuer.GetIssuer(), sub, client.GetClientId())
+	// The token ends with the assertion, and is not refreshed, so the issuer is asked again for every new token
+	return h.issueTokens(&tokenGrant{authorization: authorization, client: client, audience: audience, scope: scope, noRefresh: true,
+		notAfter: time.Unix(int64Claim(claims, "exp"), 0), cnf: h.tokenConfirmation(client, req)}, "")
+}
+
+// verifyTrustedAssertion - Check the assertion is signed by a trusted issuer, and is valid for this server
+func (h *tokenHandler) verifyTrustedAssertion(authorization *models.Authorization, req *models.TokenRequest) (*models.TrustedIssuer, jwt.MapClaims, error) {
+	parser := &jwt.Parser{ValidMethods: signing.Algorithms, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	token, _, err := parser.ParseUnverified(req.Assertion, claims)
+	if err != nil {
+		return nil, nil, errInvalidGrant("assertion could not be parsed: %s", err)
+	}
+	iss := stringClaim(claims, "iss")
+	issuer := findTrustedIssuer(authorization, iss)
+	if issuer == nil {
+		return nil, nil, errInvalidGrant("assertion issuer: %s is not trusted", iss)
+	}
+	keys, err := h.issuerKeys.keys(issuer)
+	if err != nil {
+		return nil, nil, errServerError("%s", err)
+	}
+	if !verifyAssertion(parser, keys, token, req.Assertion) {
+		return nil, nil, errInvalidGrant("assertion signature not valid for issuer: %s", iss)
+	}
+	if stringClaim(claims, "sub") == "" {
+		return nil, nil, errInvalidGrant("assertion has no sub")
+	}
+	audiences := issuer.GetAudiences()
+	if len(audiences) == 0 {
+		audiences = grantAudiences(authorization, req.EndpointPath)
+	}
+	if err := checkAssertionClaims(claims, audiences); err != nil {
+		return nil, nil, errInvalidGrant("assertion %s", err)
+	}
+	if issuer.GetSingleUse() {
+		jti := stringClaim(claims, "jti")
+		if jti == "" {
+			return nil, nil, errInvalidGrant("assertion has no jti")
+		}
+		if err := h.useID(GrantTypeJWTBearer+" "+iss+" "+jti, time.Unix(int64Claim(claims, "exp"), 0)); err != nil {
+			if err == store.ErrReused {
+				return nil, nil, errInvalidGrant("assertion jti: %s already used", jti)
+			}
+			return nil, nil, errServerError("assertion jti could not be stored: %s", err)
+		}
+	}
+	return issuer, claims, nil
+}
+
+// grantAudiences - Default audiences of a grant assertion: the issuer, and the token endpoint URL
+func grantAudiences(authorization *models.Authorization, path string) []string {
+	res := []string{}
+	if issuer := authorization.GetIssuer(); issuer != "" {
+		res = append(res, issuer)
+	}
+	if u := tokenEndpointURL(authorization, path); u != "" {
+		res = append(res, u)
+	}
+	return res
+}
+
+// validateTrustedIssuerKeys - Check the keys of the trusted issuer can be loaded
+func validateTrustedIssuerKeys(issuer *models.TrustedIssuer) error {
+	_, err := trustedIssuerKeys(issuer)
+	return err
+}
+
+// trustedIssuerKeys - Keys from the issuer jwks_file
+func trustedIssuerKeys(issuer *models.TrustedIssuer) ([]verificationKey, error) {
+	jwks, err := readJwks(issuer.GetJwksFile())
+	if err != nil {
+		return nil, fmt.Errorf("Trusted issuer: %s %v", issuer.GetIssuer(), err)
+	}
+	keys, err := jwksKeys(jwks)
+	if err != nil {
+		return nil, fmt.Errorf("Trusted issuer: %s %v", issuer.GetIssuer(), err)
+	}
+	return keys, nil
+}
+
+// jwksCache - Keys of trusted issuer JWKS files. A file is only read again when it changes
+type jwksCache struct {
+	mu    sync.Mutex
+	files map[string]*cachedJwks
+}
+
+type cachedJwks struct {
+	modTime time.Time
+	size    int64
+	keys    []verificationKey
+}
+
+// keys - Keys from the issuer jwks_file, cached until the file modification time or size changes
+func (c *jwksCache) keys(issuer *models.TrustedIssuer) ([]verificationKey, error) {
+	path := issuer.GetJwksFile()
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Trusted issuer: %s jwks_file could not be read: %v", issuer.GetIssuer(), err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f := c.files[path]; f != nil && f.modTime.Equal(fi.ModTime()) && f.size == fi.Size() {
+		return f.keys, nil
+	}
+	keys, err := trustedIssuerKeys(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if c.files == nil {
+		c.files = map[string]*cachedJwks{}
+	}
+	c.files[path] = &cachedJwks{modTime: fi.ModTime(), size: fi.Size(), keys: keys}
+	return keys, nil
+}
+
+// findTrustedIssuer - Look up trusted issuer by iss. Returns nil if not found
+func findTrustedIssuer(authorization *models.Authorization, iss string) *models.TrustedIssuer {
+	if iss == "" {
+		return nil
+	}
+	for _, issuer := range authorization.GetTrustedIssuers() {
+		if issuer.GetIssuer() == iss {
+			return issuer
+		}
+	}
+	return nil
+}
+
+// matchSubjectRule - First rule of the issuer matching the assertion. Nil if none match
+func matchSubjectRule(issuer *models.TrustedIssuer, claims jwt.MapClaims) *models.TrustedSubjectRule {
+	sub := stringClaim(claims, "sub")
+	for _, rule := range issuer.GetRules() {
+		if subjectMatches(rule.GetSubject(), sub) && claimsMatch(rule.GetClaims(), claims) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// subjectMatches - Exact match, or prefix match for patterns ending with *. Empty patterns match any subject
+func subjectMatches(pattern, sub string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(sub, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == "" || pattern == sub
+}
+
+// claimsMatch - All claims have the given values
+func claimsMatch(match map[string]string, claims jwt.MapClaims) bool {
+	for name, value := range match {
+		if !claimHasValue(claims[name], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimHasValue - Strings are compared as is, numbers and booleans in their JSON form.
+// Lists have the value if one of the items has it
+func claimHasValue(claim interface{}, value string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == value
+	case float64, bool:
+		return fmt.Sprint(c) == value
+	case []interface{}:
+		for _, item := range c {
+			if _, ok := item.([]interface{}); !ok && claimHasValue(item, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+const testExternalIssuer = "https://ci.example.com"
+
+// testExternalAssertion - JWT from the external issuer. Claims are added to valid defaults
+func testExternalAssertion(t *testing.T, key crypto.Signer, claims jwt.MapClaims) string {
+	t.Helper()
+	c := jwt.MapClaims{
+		"iss":        testExternalIssuer,
+		"sub":        "repo:org/app:ref:refs/heads/main",
+		"aud":        "Test-Issuer",
+		"exp":        time.Now().Add(5 * time.Minute).Unix(),
+		"repository": "org/app",
+		"groups":     []string{"deployers", "developers"},
+		"jti":        time.Now().String(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, c)
+	token.Header["kid"] = "ci1"
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	return s
+}
+
+func TestJWTBearerGrant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtbearer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, jwk := testAssertionKey(t, signing.ES256, "ci1")
+	otherKey, _ := testAssertionKey(t, signing.ES256, "ci1")
+	jwksFile := filepath.Join(dir, "ci.jwks.json")
+	if err := ioutil.WriteFile(jwksFile, []byte(`{"keys": [`+jwk+`]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := &models.Authorization{Issuer: "Test-Issuer", TokenEndpointUrl: testTokenEndpoint, Clients: []*models.Client{
+		testClient(t, `{"client_id": "deployer", "allow_refresh_token": true, "grant_types": ["urn:ietf:params:oauth:grant-type:jwt-bearer"], "scopes": ["deploy", "read"]}`),
+		testClient(t, `{"client_id": "reader", "grant_types": ["urn:ietf:params:oauth:grant-type:jwt-bearer"], "scopes": ["read"]}`),
+		testClient(t, `{"client_id": "nogrant", "scopes": ["read"]}`),
+		auth.Clients[0],
+	}}
+	issuer := &models.TrustedIssuer{}
+	if err := jsonpb.Unmarshal(strings.NewReader(`{
+		"issuer": "https://ci.example.com",
+		"jwks_file": "`+jwksFile+`",
+		"rules": [
+			{"subject": "repo:org/app:ref:refs/heads/main", "claims": {"groups": "deployers"}, "client_id": "deployer", "scopes": ["deploy", "read", "admin"]},
+			{"subject": "repo:org/app:*", "client_id": "reader", "scopes": ["read"]},
+			{"subject": "repo:org/legacy", "client_id": "nogrant", "scopes": ["read"]}
+		]}`), issuer); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	single := &models.TrustedIssuer{Issuer: "https://single.example.com", JwksFile: jwksFile, SingleUse: true, Audiences: []string{"https://auth.example.com"},
+		Rules: []*models.TrustedSubjectRule{{Subject: "job", ClientId: "reader", Scopes: []string{"read"}}}}
+	a.TrustedIssuers = []*models.TrustedIssuer{issuer, single}
+
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetAuthorization(a)
+	h.SetReplayCache(store.NewMemoryReplayCache())
+	h.SetRefreshTokenStore(store.NewMemoryRefreshTokenStore())
+
+	reused := testExternalAssertion(t, key, jwt.MapClaims{"iss": "https://single.example.com", "sub": "job", "aud": "https://auth.example.com"})
+	if _, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: GrantTypeJWTBearer, Assertion: reused}); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+
+	var testResp = []struct {
+		name      string
+		req       *models.TokenRequest // request, grant type is added
+		scope     string               // expected scope
+		errorCode string               // expected error, empty if none
+	}{
+		{"mapped by subject and claim", &models.TokenRequest{Assertion: testExternalAssertion(t, key, nil)}, "deploy read", ""},
+		{"down-scoped", &models.TokenRequest{Assertion: testExternalAssertion(t, key, nil), Scope: "read"}, "read", ""},
+		{"subject prefix", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{
+			"sub": "repo:org/app:pull_request"})}, "read", ""},
+		{"claim not matching falls through", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{
+			"groups": []string{"developers"}})}, "read", ""},
+		{"mapped client given", &models.TokenRequest{ClientID: "reader", Assertion: testExternalAssertion(t, key, jwt.MapClaims{
+			"sub": "repo:org/app:pull_request"})}, "read", ""},
+		{"audience list", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{
+			"aud": []string{"other", "Test-Issuer"}})}, "deploy read", ""},
+		{"token endpoint audience", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{
+			"aud": testTokenEndpoint})}, "deploy read", ""},
+		{"other server token endpoint", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{
+			"aud": "https://evil.example.com/oauth/token"})}, "", errCodeInvalidGrant},
+		{"issuer audiences", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{
+			"iss": "https://single.example.com", "sub": "job", "aud": "https://auth.example.com"})}, "read", ""},
+		{"scope not mapped", &models.TokenRequest{Assertion: testExternalAssertion(t, key, nil), Scope: "admin"}, "", errCodeInvalidScope},
+		{"no rule", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{"sub": "repo:other/app"})}, "", errCodeInvalidGrant},
+		{"untrusted issuer", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{"iss": "https://evil.example.com"})}, "", errCodeInvalidGrant},
+		{"wrong key", &models.TokenRequest{Assertion: testExternalAssertion(t, otherKey, nil)}, "", errCodeInvalidGrant},
+		{"expired", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})}, "", errCodeInvalidGrant},
+		{"no exp", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{"exp": nil})}, "", errCodeInvalidGrant},
+		{"wrong audience", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{"aud": "https://other.example.com"})}, "", errCodeInvalidGrant},
+		{"no sub", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{"sub": nil})}, "", errCodeInvalidGrant},
+		{"reused single use", &models.TokenRequest{Assertion: reused}, "", errCodeInvalidGrant},
+		{"single use without jti", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{
+			"iss": "https://single.example.com", "sub": "job", "aud": "https://auth.example.com", "jti": nil})}, "", errCodeInvalidGrant},
+		{"other client given", &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Assertion: testExternalAssertion(t, key, nil)}, "", errCodeInvalidGrant},
+		{"other client_id given", &models.TokenRequest{ClientID: "cl1", Assertion: testExternalAssertion(t, key, nil)}, "", errCodeInvalidGrant},
+		{"client authentication failed", &models.TokenRequest{ClientID: "cl1", ClientSecret: "wrong", Assertion: testExternalAssertion(t, key, nil)}, "", errCodeInvalidClient},
+		{"client without grant type", &models.TokenRequest{Assertion: testExternalAssertion(t, key, jwt.MapClaims{"sub": "repo:org/legacy"})}, "", errCodeUnauthorizedClient},
+		{"no assertion", &models.TokenRequest{}, "", errCodeInvalidRequest},
+		{"not a jwt", &models.TokenRequest{Assertion: "abc"}, "", errCodeInvalidGrant},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.GrantType = GrantTypeJWTBearer
+			tc.req.EndpointPath = testTokenPath
+			res, err := h.handleGrant(h.authorization.Load(), tc.req)
+			if tc.errorCode != "" {
+				expectOAuthError(t, err, tc.errorCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			if res.Scope != tc.scope {
+				t.Errorf("Scope, Expected: %q, Got: %q", tc.scope, res.Scope)
+			}
+			if res.RefreshToken != "" {
+				t.Error("Token from an assertion should not have a refresh token")
+			}
+		})
+	}
+
+	// The token never outlives the assertion
+	res, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: GrantTypeJWTBearer, EndpointPath: testTokenPath,
+		Assertion: testExternalAssertion(t, key, jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()})})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if res.ExpiresIn > 60 {
+		t.Errorf("expires_in, Expected at most 60, Got: %d", res.ExpiresIn)
+	}
+}
+
+func TestJwksCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, first := testAssertionKey(t, signing.ES256, "k1")
+	_, second := testAssertionKey(t, signing.ES384, "k2")
+	jwksFile := filepath.Join(dir, "ci.jwks.json")
+	if err := ioutil.WriteFile(jwksFile, []byte(`{"keys": [`+first+`]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	issuer := &models.TrustedIssuer{Issuer: testExternalIssuer, JwksFile: jwksFile}
+	c := &jwksCache{}
+
+	keys, err := c.keys(issuer)
+	if err != nil || len(keys) != 1 || keys[0].kid != "k1" {
+		t.Fatalf("Expected key k1, Got: %v, %v", keys, err)
+	}
+	// The cached keys are used while the file is unchanged
+	c.files[jwksFile].keys = []verificationKey{{kid: "cached"}}
+	if keys, _ := c.keys(issuer); keys[0].kid != "cached" {
+		t.Errorf("Expected cached keys, Got kid: %s", keys[0].kid)
+	}
+	// A replaced file is read again
+	if err := ioutil.WriteFile(jwksFile, []byte(`{"keys": [`+first+`, `+second+`]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := c.keys(issuer); err != nil || len(keys) != 2 {
+		t.Errorf("Expected 2 keys after the file changed, Got: %v, %v", keys, err)
+	}
+	os.Remove(jwksFile)
+	if _, err := c.keys(issuer); err == nil {
+		t.Error("Expected error for removed jwks_file")
+	}
+}
+
+func TestClaimHasValue(t *testing.T) {
+	var testResp = []struct {
+		claim interface{} // claim value
+		value string      // value to match
+		exp   bool        // expected result
+	}{
+		{"main", "main", true},
+		{"main", "Main", false},
+		{float64(42), "42", true},
+		{true, "true", true},
+		{[]interface{}{"a", "b"}, "b", true},
+		{[]interface{}{"a", []interface{}{"b"}}, "b", false},
+		{map[string]interface{}{"b": "b"}, "b", false},
+		{nil, "", false},
+	}
+	for _, tc := range testResp {
+		if res := claimHasValue(tc.claim, tc.value); res != tc.exp {
+			t.Errorf("claimHasValue(%v, %s), Expected: %v, Got: %v", tc.claim, tc.value, tc.exp, res)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// loginTemplate - Minimal built-in login page for the authorization endpoint.
+// Authorization request parameters are carried as hidden fields
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+</head>
+<body>
+<h1>Sign in to {{.ClientID}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="POST" action="{{.Action}}">
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}<label>Username <input type="text" name="username" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// errorTemplate - Error page for authorization requests that can not be redirected back to the client
+var errorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorization error</title>
+</head>
+<body>
+<h1>Authorization error</h1>
+<p>{{.Code}}: {{.Description}}</p>
+</body>
+</html>
+`))
+
+type loginPage struct {
+	ClientID string
+	Action   string
+	Params   map[string]string
+	Error    string
+}
+
+// setPageHeaders - Headers for all HTML pages. Pages must not be cached or framed
+func setPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+)
+
+//go:generate mockgen -destination=../mocks/metrics_handler_mock.go -package=mocks github.com/jafossum/go-auth-server/handlers IMetricsHandler
+
+// IMetricsHandler : MetricsHandler Interace
+type IMetricsHandler interface {
+	SetVerifyPool(pool *passwd.Pool)
+	Handle(w http.ResponseWriter, r *http.Request)
+}
+
+// MetricsHandler - Password verification pool metrics, in the Prometheus text format
+var MetricsHandler IMetricsHandler = &metricsHandler{}
+
+type metricsHandler struct {
+	pool *passwd.Pool
+}
+
+// SetVerifyPool - Initialize with the pool to report. No metrics are reported if nil
+func (h *metricsHandler) SetVerifyPool(pool *passwd.Pool) {
+	h.pool = pool
+}
+
+// Handle - Metrics Endpoint handler
+func (h *metricsHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if h.pool == nil {
+		return
+	}
+	writePoolMetrics(w, h.pool.Stats())
+}
+
+// writePoolMetrics - Write the pool stats as Prometheus gauges, counters and a latency histogram
+func writePoolMetrics(w io.Writer, s passwd.Stats) {
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("passwd_verify_workers", "gauge", "Password verifications that can run at the same time.", s.Workers)
+	metric("passwd_verify_queue_limit", "gauge", "Password verifications that can wait for a worker.", s.QueueLimit)
+	metric("passwd_verify_queue_depth", "gauge", "Password verifications waiting for a worker.", s.QueueDepth)
+	metric("passwd_verify_active", "gauge", "Password verifications running.", s.Active)
+	metric("passwd_verify_rejected_total", "counter", "Password verifications rejected because the queue was full.", s.Rejected)
+	metric("passwd_verify_timeouts_total", "counter", "Password verifications that timed out in the queue.", s.TimedOut)
+	metric("passwd_verify_wait_seconds_total", "counter", "Time password verifications waited for a worker.", s.WaitTime.Seconds())
+
+	name := "passwd_verify_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Password verification latency.\n# TYPE %s histogram\n", name, name)
+	for i, b := range passwd.DurationBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%v\"} %d\n", name, b.Seconds(), s.Buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, s.Verified)
+	fmt.Fprintf(w, "%s_sum %v\n%s_count %d\n", name, s.VerifyTime.Seconds(), name, s.Verified)
+}
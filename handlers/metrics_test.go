@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+)
+
+func TestMetricsHandle(t *testing.T) {
+	pool := passwd.NewPool(passwd.PoolConfig{Workers: 3, Queue: 10})
+	if err := pool.Compare("secret1", auth.Clients[0].ClientSecret); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	h := metricsHandler{}
+	h.SetVerifyPool(pool)
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	for _, exp := range []string{
+		"# TYPE passwd_verify_queue_depth gauge\npasswd_verify_queue_depth 0\n",
+		"passwd_verify_workers 3\n",
+		"passwd_verify_queue_limit 10\n",
+		"passwd_verify_rejected_total 0\n",
+		"# TYPE passwd_verify_duration_seconds histogram\n",
+		"passwd_verify_duration_seconds_bucket{le=\"5\"} 1\n",
+		"passwd_verify_duration_seconds_bucket{le=\"+Inf\"} 1\n",
+		"passwd_verify_duration_seconds_count 1\n",
+	} {
+		if !strings.Contains(body, exp) {
+			t.Errorf("Metrics, Expected: %q, Got: %s", exp, body)
+		}
+	}
+
+	// No metrics without a pool
+	h = metricsHandler{}
+	rr = httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Body.Len() != 0 {
+		t.Errorf("Expected no metrics, Got: %s", rr.Body.String())
+	}
+}
+
+func TestVerifyPasswordOverloaded(t *testing.T) {
+	pool := passwd.NewPool(passwd.PoolConfig{Workers: 1, Queue: 1, Timeout: time.Nanosecond})
+	// Fill the queue with slow verifications, until one is rejected
+	var wg sync.WaitGroup
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verifyPassword(pool, "secret1", auth.Clients[0].ClientSecret)
+		}()
+		err = verifyPassword(pool, "secret1", auth.Clients[0].ClientSecret)
+	}
+	wg.Wait()
+	if oe, ok := err.(*oauthError); !ok || oe.Status != 503 || oe.RetryAfter != time.Second {
+		t.Errorf("Expected 503 error, Got: %v", err)
+	}
+
+	if err := verifyPassword(nil, "wrong", auth.Clients[0].ClientSecret); err != errMismatch {
+		t.Errorf("Expected mismatch, Got: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// Mutual TLS client authentication methods (RFC 8705 2)
+const (
+	authMethodTLSClientAuth           = "tls_client_auth"
+	authMethodSelfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
+// mtlsConfig - Mutual TLS client authentication settings. Disabled until SetClientCertificateAuth is called
+type mtlsConfig struct {
+	// mtlsEnabled - Client certificates are requested in the TLS handshake
+	mtlsEnabled bool
+	// clientCAs - Roots for tls_client_auth certificates. Nil if only self-signed certificates are accepted
+	clientCAs *x509.CertPool
+}
+
+// SetClientCertificateAuth - Enable mutual TLS client authentication. Certificates for
+// tls_client_auth must chain to roots. With nil roots only self_signed_tls_client_auth can be used
+func (c *mtlsConfig) SetClientCertificateAuth(roots *x509.CertPool) {
+	c.mtlsEnabled = true
+	c.clientCAs = roots
+}
+
+// validateClientCertificateAuth - Check the mutual TLS settings of the client
+func validateClientCertificateAuth(client *models.Client) error {
+	if client.GetPublic() && hasClientCertificateAuth(client) {
+		return fmt.Errorf("Client: %s is public, and can not authenticate with a client certificate", client.GetClientId())
+	}
+	set := 0
+	for _, v := range []string{client.GetTlsClientAuthSubjectDn(), client.GetTlsClientAuthSanDns(), client.GetTlsClientAuthSanUri(),
+		client.GetTlsClientAuthSanIp(), client.GetTlsClientAuthSanEmail()} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("Client: %s can only have one of tls_client_auth_subject_dn and the tls_client_auth_san values", client.GetClientId())
+	}
+	if ip := client.GetTlsClientAuthSanIp(); ip != "" && net.ParseIP(ip) == nil {
+		return fmt.Errorf("Client: %s tls_client_auth_san_ip: %s is not an IP address", client.GetClientId(), ip)
+	}
+	for _, t := range client.GetTlsClientCertificateThumbprints() {
+		if b, err := b64.RawURLEncoding.DecodeString(strings.TrimRight(t, "=")); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("Client: %s certificate thumbprint: %s is not a base64url SHA-256 hash", client.GetClientId(), t)
+		}
+	}
+	return nil
+}
+
+// hasClientCertificateAuth - Client authenticates with a client certificate
+func hasClientCertificateAuth(client *models.Client) bool {
+	return tlsClientAuthMatch(client) || len(client.GetTlsClientCertificateThumbprints()) > 0
+}
+
+// tlsClientAuthMatch - Client has a subject DN or SAN for tls_client_auth
+func tlsClientAuthMatch(client *models.Client) bool {
+	return client.GetTlsClientAuthSubjectDn() != "" || client.GetTlsClientAuthSanDns() != "" || client.GetTlsClientAuthSanUri() != "" ||
+		client.GetTlsClientAuthSanIp() != "" || client.GetTlsClientAuthSanEmail() != ""
+}
+
+// authenticateClientCertificate - Authenticate the client with the certificate presented in the
+// TLS handshake. Pinned self-signed certificates are checked first, then tls_client_auth
+func (c *mtlsConfig) authenticateClientCertificate(client *models.Client, req *models.TokenRequest) (*models.Client, error) {
+	if req.ClientSecret != "" {
+		return nil, errInvalidClient("Client: %s authenticates with a client certificate, not a secret", client.GetClientId())
+	}
+	if !c.mtlsEnabled || len(req.ClientCertificates) == 0 {
+		return nil, errInvalidClient("Client: %s requires a client certificate", client.GetClientId())
+	}
+	cert := req.ClientCertificates[0]
+	thumbprint := certificateThumbprint(cert)
+	for _, t := range client.GetTlsClientCertificateThumbprints() {
+		if strings.TrimRight(t, "=") == thumbprint {
+			return client, nil
+		}
+	}
+	if tlsClientAuthMatch(client) && c.clientCAs != nil {
+		if err := c.verifyClientCertificate(req.ClientCertificates); err != nil {
+			return nil, errInvalidClient("Client: %s certificate not valid: %s", client.GetClientId(), err)
+		}
+		if certificateMatches(client, cert) {
+			return client, nil
+		}
+	}
+	return nil, errInvalidClient("Client certificate does not match client_id: %s", client.GetClientId())
+}
+
+// verifyClientCertificate - Check the certificate chains to the client CA, and can be used for client authentication
+func (c *mtlsConfig) verifyClientCertificate(chain []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         c.clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// certificateMatches - Certificate has the subject DN or SAN registered for the client.
+// The subject DN is compared in RFC 4514 string form, e.g. CN=client1,O=Example
+func certificateMatches(client *models.Client, cert *x509.Certificate) bool {
+	switch {
+	case client.GetTlsClientAuthSubjectDn() != "":
+		return cert.Subject.String() == client.GetTlsClientAuthSubjectDn()
+	case client.GetTlsClientAuthSanDns() != "":
+		return containsString(cert.DNSNames, client.GetTlsClientAuthSanDns())
+	case client.GetTlsClientAuthSanUri() != "":
+		for _, u := range cert.URIs {
+			if u.String() == client.GetTlsClientAuthSanUri() {
+				return true
+			}
+		}
+	case client.GetTlsClientAuthSanIp() != "":
+		ip := net.ParseIP(client.GetTlsClientAuthSanIp())
+		for _, a := range cert.IPAddresses {
+			if a.Equal(ip) {
+				return true
+			}
+		}
+	case client.GetTlsClientAuthSanEmail() != "":
+		return containsString(cert.EmailAddresses, client.GetTlsClientAuthSanEmail())
+	}
+	return false
+}
+
+// certificateThumbprint - base64url SHA-256 of the DER certificate (x5t#S256)
+func certificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return b64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// certificateConfirmation - Confirmation claim binding tokens to the client certificate. Nil if
+// the client neither authenticates with a certificate nor asks for certificate-bound tokens
+func (c *mtlsConfig) certificateConfirmation(client *models.Client, req *models.TokenRequest) *models.Confirmation {
+	if len(req.ClientCertificates) == 0 || !c.mtlsEnabled {
+		return nil
+	}
+	if !hasClientCertificateAuth(client) && !client.GetTlsClientCertificateBoundAccessTokens() {
+		return nil
+	}
+	return &models.Confirmation{X5tS256: certificateThumbprint(req.ClientCertificates[0])}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// testCertificate - Certificate for template, signed by parent. Self-signed if parent is nil
+func testCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	return cert, key
+}
+
+// testCA - Self-signed CA certificate
+func testCA(t *testing.T, name string) (*x509.Certificate, crypto.Signer) {
+	return testCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: name}, IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	ca, caKey := testCA(t, "Test CA")
+	otherCA, otherKey := testCA(t, "Other CA")
+	uri, _ := url.Parse("spiffe://example.com/client")
+	clientTemplate := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:        pkix.Name{CommonName: "client1", Organization: []string{"Example"}},
+			DNSNames:       []string{"client1.example.com"},
+			URIs:           []*url.URL{uri},
+			IPAddresses:    []net.IP{net.ParseIP("10.0.0.5")},
+			EmailAddresses: []string{"client1@example.com"},
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+	}
+	cert, _ := testCertificate(t, clientTemplate(), ca, caKey)
+	serverCert, _ := testCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client1", Organization: []string{"Example"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca, caKey)
+	untrusted, _ := testCertificate(t, clientTemplate(), otherCA, otherKey)
+	selfSigned, _ := testCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "self"}}, nil, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	c := clientAuthenticator{}
+	c.SetClientCertificateAuth(roots)
+
+	a := &models.Authorization{Clients: []*models.Client{
+		{ClientId: "dn", TlsClientAuthSubjectDn: "CN=client1,O=Example"},
+		{ClientId: "dns", TlsClientAuthSanDns: "client1.example.com"},
+		{ClientId: "uri", TlsClientAuthSanUri: "spiffe://example.com/client"},
+		{ClientId: "ip", TlsClientAuthSanIp: "10.0.0.5"},
+		{ClientId: "email", TlsClientAuthSanEmail: "client1@example.com"},
+		{ClientId: "other", TlsClientAuthSubjectDn: "CN=client2,O=Example"},
+		{ClientId: "self", TlsClientCertificateThumbprints: []string{certificateThumbprint(selfSigned)}},
+		{ClientId: "bound", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G", TlsClientCertificateBoundAccessTokens: true},
+	}}
+
+	var testResp = []struct {
+		name      string
+		req       *models.TokenRequest // request
+		errorCode string               // expected error, empty if none
+	}{
+		{"subject dn", &models.TokenRequest{ClientID: "dn", ClientCertificates: []*x509.Certificate{cert}}, ""},
+		{"san dns", &models.TokenRequest{ClientID: "dns", ClientCertificates: []*x509.Certificate{cert}}, ""},
+		{"san uri", &models.TokenRequest{ClientID: "uri", ClientCertificates: []*x509.Certificate{cert}}, ""},
+		{"san ip", &models.TokenRequest{ClientID: "ip", ClientCertificates: []*x509.Certificate{cert}}, ""},
+		{"san email", &models.TokenRequest{ClientID: "email", ClientCertificates: []*x509.Certificate{cert}}, ""},
+		{"self-signed", &models.TokenRequest{ClientID: "self", ClientCertificates: []*x509.Certificate{selfSigned}}, ""},
+		{"bound with secret", &models.TokenRequest{ClientID: "bound", ClientSecret: "secret1", ClientCertificates: []*x509.Certificate{selfSigned}}, ""},
+		{"subject mismatch", &models.TokenRequest{ClientID: "other", ClientCertificates: []*x509.Certificate{cert}}, errCodeInvalidClient},
+		{"untrusted ca", &models.TokenRequest{ClientID: "dn", ClientCertificates: []*x509.Certificate{untrusted}}, errCodeInvalidClient},
+		{"not for client auth", &models.TokenRequest{ClientID: "dn", ClientCertificates: []*x509.Certificate{serverCert}}, errCodeInvalidClient},
+		{"self-signed not pinned", &models.TokenRequest{ClientID: "dn", ClientCertificates: []*x509.Certificate{selfSigned}}, errCodeInvalidClient},
+		{"wrong pinned cert", &models.TokenRequest{ClientID: "self", ClientCertificates: []*x509.Certificate{cert}}, errCodeInvalidClient},
+		{"no certificate", &models.TokenRequest{ClientID: "dn"}, errCodeInvalidClient},
+		{"secret instead of certificate", &models.TokenRequest{ClientID: "dn", ClientSecret: "secret1", ClientCertificates: []*x509.Certificate{cert}}, errCodeInvalidClient},
+		{"bound without certificate", &models.TokenRequest{ClientID: "bound", ClientSecret: "secret1"}, errCodeInvalidClient},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := c.authenticateClient(a, tc.req)
+			if tc.errorCode != "" {
+				expectOAuthError(t, err, tc.errorCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			if client.GetClientId() != tc.req.ClientID {
+				t.Errorf("Expected: %s, Got: %s", tc.req.ClientID, client.GetClientId())
+			}
+		})
+	}
+}
+
+func TestClientCertificateAuthDisabled(t *testing.T) {
+	selfSigned, _ := testCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "self"}}, nil, nil)
+	a := &models.Authorization{Clients: []*models.Client{
+		{ClientId: "self", TlsClientCertificateThumbprints: []string{certificateThumbprint(selfSigned)}},
+	}}
+	c := clientAuthenticator{}
+	_, err := c.authenticateClient(a, &models.TokenRequest{ClientID: "self", ClientCertificates: []*x509.Certificate{selfSigned}})
+	expectOAuthError(t, err, errCodeInvalidClient)
+}
+
+func TestCertificateBoundToken(t *testing.T) {
+	selfSigned, _ := testCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "self"}}, nil, nil)
+
+	keys := testKeyRing()
+	h := tokenHandler{}
+	h.SetClientCertificateAuth(nil)
+	h.SetKeyRing(keys)
+	h.SetAuthorization(&models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+		{ClientId: "self", TlsClientCertificateThumbprints: []string{certificateThumbprint(selfSigned)}},
+		{ClientId: "cl1", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G"},
+	}})
+	introspect := introspectHandler{keys: keys}
+	introspect.SetAuthorization(&models.Authorization{Issuer: "Test-Issuer"})
+
+	var testResp = []struct {
+		name string
+		req  *models.TokenRequest // request
+		cnf  string               // expected x5t#S256, empty for bearer tokens
+	}{
+		{"mtls client", &models.TokenRequest{GrantType: "client_credentials", ClientID: "self",
+			ClientCertificates: []*x509.Certificate{selfSigned}}, certificateThumbprint(selfSigned)},
+		{"secret client with certificate", &models.TokenRequest{GrantType: "client_credentials", ClientID: "cl1", ClientSecret: "secret1",
+			ClientCertificates: []*x509.Certificate{selfSigned}}, ""},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := h.handleGrant(h.authorization.Load(), tc.req)
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			claims := jwt.MapClaims{}
+			if _, err := jwt.ParseWithClaims(res.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+				return keys.Signing().PublicKey, nil
+			}); err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			cnf, _ := claims["cnf"].(map[string]interface{})
+			if x5t, _ := cnf["x5t#S256"].(string); x5t != tc.cnf {
+				t.Errorf("cnf x5t#S256, Expected: %q, Got: %q", tc.cnf, x5t)
+			}
+			ir := introspect.introspect(introspect.authorization.Load(), res.AccessToken)
+			if (tc.cnf == "" && ir.Cnf != nil) || (tc.cnf != "" && (ir.Cnf == nil || ir.Cnf.X5tS256 != tc.cnf)) {
+				t.Errorf("Introspection cnf, Expected: %q, Got: %+v", tc.cnf, ir.Cnf)
+			}
+		})
+	}
+}
+
+func TestValidateClientCertificateAuth(t *testing.T) {
+	var testResp = []struct {
+		name   string
+		client *models.Client
+		err    bool // expect error
+	}{
+		{"none", &models.Client{ClientId: "cl1"}, false},
+		{"subject dn", &models.Client{ClientId: "cl1", TlsClientAuthSubjectDn: "CN=client1"}, false},
+		{"thumbprint", &models.Client{ClientId: "cl1", TlsClientCertificateThumbprints: []string{"bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"}}, false},
+		{"two match values", &models.Client{ClientId: "cl1", TlsClientAuthSubjectDn: "CN=client1", TlsClientAuthSanDns: "client1"}, true},
+		{"bad ip", &models.Client{ClientId: "cl1", TlsClientAuthSanIp: "10.0.0"}, true},
+		{"bad thumbprint", &models.Client{ClientId: "cl1", TlsClientCertificateThumbprints: []string{"abc"}}, true},
+		{"public", &models.Client{ClientId: "cl1", Public: true, TlsClientAuthSubjectDn: "CN=client1"}, true},
+		{"public bound", &models.Client{ClientId: "cl1", Public: true, TlsClientCertificateBoundAccessTokens: true}, false},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateClientCertificateAuth(tc.client)
+			if err == nil && tc.err {
+				t.Error("Not getting expected error")
+			}
+			if err != nil && !tc.err {
+				t.Errorf("Got uinexpected error: %v", err)
+			}
+		})
+	}
+}
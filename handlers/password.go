@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// dummyHash - Compared against when the user does not exist, so unknown and known
+// usernames take the same time to reject
+const dummyHash = "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G"
+
+// handlePassword - Resource owner password credentials grant (RFC 6749 4.3).
+// Both the client and the user are authenticated, and the token is issued for the user
+func (h *tokenHandler) handlePassword(authorization *models.Authorization, req *models.TokenRequest) (*models.TokenResponse, error) {
+	client, err := h.authenticate(authorization, req)
+	if err != nil {
+		return nil, err
+	}
+	if !allowsGrant(client, "password") {
+		return nil, errUnauthorizedClient("Client: %s is not allowed to use grant_type: password", client.GetClientId())
+	}
+	if req.Username == "" || req.Password == "" {
+		return nil, errInvalidRequest("username and password are required")
+	}
+	user, err := authenticateUser(h.pool, authorization, req.Username, req.Password)
+	if err != nil {
+		return nil, err
+	}
+	audience, err := resolveAudience(client, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := resolveScope(clientScopes(client), req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return h.issueTokens(&tokenGrant{authorization: authorization, client: client, user: user, audience: audience, scope: scope,
+		cnf: h.tokenConfirmation(client, req)}, "")
+}
+
+// authenticateUser - Find an enabled user and validate the password on the pool
+func authenticateUser(pool *passwd.Pool, authorization *models.Authorization, username, password string) (*models.User, error) {
+	user := findUser(authorization, username)
+	hash := dummyHash
+	if user != nil {
+		hash = user.GetPassword()
+	}
+	err := verifyPassword(pool, password, hash)
+	if err != nil && err != errMismatch {
+		return nil, err
+	}
+	if err != nil || user == nil || user.GetDisabled() {
+		return nil, errInvalidGrant("Invalid username or password")
+	}
+	return user, nil
+}
+
+// findUser - Look up user by username. Returns nil if not found
+func findUser(authorization *models.Authorization, username string) *models.User {
+	for _, user := range authorization.GetUsers() {
+		if user.GetUsername() == username {
+			return user
+		}
+	}
+	return nil
+}
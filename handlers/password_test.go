@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+var passwordAuth = &models.Authorization{Issuer: "Test-Issuer",
+	Clients: []*models.Client{
+		&models.Client{ClientId: "cl1", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G", IsAdmin: true, Scope: "sc", GrantTypes: []string{"password"}, AllowRefreshToken: true},
+		&models.Client{ClientId: "cl2", ClientSecret: "$2a$10$a/JANxkdgbJtc0i36ZEk.eVxoUaMdvMhr/k4fpjL5kTbAeZJFpeIm", Scope: "sc"}},
+	Users: []*models.User{
+		&models.User{Username: "alice", Password: "$2a$10$ceIdYU59NMUnoaw0MEafm.qNiWHAb8gWdBC1Fr2bUVI1KqPAaVdZO", Roles: []string{"reader", "writer"}},
+		&models.User{Username: "bob", Password: "$2a$10$wr0J2BANKYVZBoYmfp/p7utqJOqTtPTQyw1bPN8rExdPBHyXJi5L2", Disabled: true}}}
+
+func TestHandlePassword(t *testing.T) {
+	keys := testKeyRing()
+	h := tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(passwordAuth)
+
+	var testResp = []struct {
+		req  *models.TokenRequest // request
+		code string               // expected error code, empty on success
+	}{
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Username: "alice", Password: "alicepass"}, ""},
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret2", Username: "alice", Password: "alicepass"}, errCodeInvalidClient},
+		{&models.TokenRequest{ClientID: "cl2", ClientSecret: "secret2", Username: "alice", Password: "alicepass"}, errCodeUnauthorizedClient},
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Username: "alice"}, errCodeInvalidRequest},
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Username: "alice", Password: "wrongpass"}, errCodeInvalidGrant},
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Username: "carol", Password: "alicepass"}, errCodeInvalidGrant},
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Username: "bob", Password: "bobpass1"}, errCodeInvalidGrant},
+	}
+
+	for _, tc := range testResp {
+		t.Run(tc.req.ClientID+tc.req.Username+tc.req.Password, func(t *testing.T) {
+			tc := tc // rebind tc into this lexical scope
+			t.Parallel()
+			_, err := h.handlePassword(h.authorization.Load(), tc.req)
+			if tc.code == "" {
+				if err != nil {
+					t.Errorf("Error not expected error; %v", err)
+				}
+				return
+			}
+			expectOAuthError(t, err, tc.code)
+		})
+	}
+}
+
+func TestHandlePasswordClaims(t *testing.T) {
+	keys := testKeyRing()
+	h := tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(passwordAuth)
+
+	res, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "password", ClientID: "cl1", ClientSecret: "secret1", Username: "alice", Password: "alicepass", Audience: "Aud"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(res.AccessToken, claims, func(*jwt.Token) (interface{}, error) { return keys.Signing().PublicKey, nil })
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var testResp = []struct {
+		claim string      // claim name
+		exp   interface{} // expected value
+	}{
+		{"sub", "alice"},
+		{"azp", "cl1"},
+		{"client_id", "cl1"},
+		{"aud", "Aud"},
+		{"admin", "false"},
+	}
+	for _, tc := range testResp {
+		if claims[tc.claim] != tc.exp {
+			t.Errorf("Claim %s, Expected: %v, Got: %v", tc.claim, tc.exp, claims[tc.claim])
+		}
+	}
+	roles, _ := claims["roles"].([]interface{})
+	if len(roles) != 2 || roles[0] != "reader" || roles[1] != "writer" {
+		t.Errorf("Claim roles, Expected: [reader writer], Got: %v", claims["roles"])
+	}
+}
+
+func TestRefreshTokenDisabledUser(t *testing.T) {
+	keys := testKeyRing()
+	auth := &models.Authorization{Clients: passwordAuth.Clients, Users: []*models.User{
+		&models.User{Username: "alice", Password: "$2a$10$ceIdYU59NMUnoaw0MEafm.qNiWHAb8gWdBC1Fr2bUVI1KqPAaVdZO"}}}
+	h := tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(auth)
+	h.SetRefreshTokenStore(store.NewMemoryRefreshTokenStore())
+
+	res, err := h.handlePassword(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Username: "alice", Password: "alicepass"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res, err = h.handleRefreshToken(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", RefreshToken: res.RefreshToken})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	auth.Users[0].Disabled = true
+	_, err = h.handleRefreshToken(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", RefreshToken: res.RefreshToken})
+	expectOAuthError(t, err, errCodeInvalidGrant)
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// PKCE (RFC 7636). Only the S256 method is supported
+const codeChallengeMethodS256 = "S256"
+
+// codeVerifierFormat - 43 to 128 unreserved characters (RFC 7636 4.1)
+var codeVerifierFormat = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// codeChallengeFormat - base64url encoded SHA-256 hash
+var codeChallengeFormat = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+
+// s256Challenge - Code challenge for a verifier using the S256 method
+func s256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyCodeChallenge - Check that the verifier matches the S256 challenge
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if !codeVerifierFormat.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(s256Challenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import "testing"
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	var testResp = []struct {
+		verifier  string // input
+		challenge string // input
+		exp       bool   // expected result
+	}{
+		// RFC 7636 Appendix B
+		{"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", true},
+		{"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXl", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", false},
+		{"short", s256Challenge("short"), false},
+		{"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", "", false},
+	}
+
+	for _, tc := range testResp {
+		t.Run(tc.verifier, func(t *testing.T) {
+			if res := verifyCodeChallenge(tc.verifier, tc.challenge); res != tc.exp {
+				t.Errorf("verifyCodeChallenge(%s, %s), Expected: %v, Got: %v", tc.verifier, tc.challenge, tc.exp, res)
+			}
+		})
+	}
+}
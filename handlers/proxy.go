@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies - Parse IPs and CIDRs. A single IP is a network of one address
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	res := []*net.IPNet{}
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("Trusted proxy: %s is not an IP address or CIDR", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("Trusted proxy: %s is not an IP address or CIDR", p)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// ipTrusted - IP is in one of the proxy networks
+func ipTrusted(ip net.IP, proxies []*net.IPNet) bool {
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP - Source IP of the request. Behind trusted proxies X-Forwarded-For is read from the
+// right, and the first address that is not a trusted proxy is the client
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ipTrusted(ip, proxies) {
+		return host
+	}
+	hops := []string{}
+	for _, h := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// Malformed entries can not be trusted, the last valid hop is used
+			break
+		}
+		ip = hop
+		if !ipTrusted(hop, proxies) {
+			break
+		}
+	}
+	return ip.String()
+}
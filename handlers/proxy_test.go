@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", " 192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	var testResp = []struct {
+		name       string
+		remoteAddr string   // connection source address
+		forwarded  []string // X-Forwarded-For headers
+		exp        string   // expected client IP
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted forwarded", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted single IP", "192.168.1.1:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.1.2.3:5000", []string{"198.51.100.1, 10.4.5.6"}, "198.51.100.1"},
+		{"spoofed first hop", "10.1.2.3:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "10.1.2.3:5000", []string{"1.2.3.4", "198.51.100.1, 10.4.5.6"}, "198.51.100.1"},
+		{"only proxies", "10.1.2.3:5000", []string{"10.4.5.6"}, "10.4.5.6"},
+		{"malformed hop", "10.1.2.3:5000", []string{"198.51.100.1, junk, 10.4.5.6"}, "10.4.5.6"},
+		{"no header", "10.1.2.3:5000", nil, "10.1.2.3"},
+		{"ipv6 proxy", "[fd00::1]:5000", []string{"2001:db8::5"}, "2001:db8::5"},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/oauth/token", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, f := range tc.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if res := clientIP(r, proxies); res != tc.exp {
+				t.Errorf("clientIP(), Expected: %s, Got: %s", tc.exp, res)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	var testResp = []struct {
+		proxies []string // configured proxies
+		valid   bool     // expected to parse
+	}{
+		{[]string{"10.0.0.0/8", "192.168.1.1", "::1", ""}, true},
+		{[]string{"10.0.0.0/33"}, false},
+		{[]string{"proxy.example.com"}, false},
+	}
+	for _, tc := range testResp {
+		if _, err := parseTrustedProxies(tc.proxies); (err == nil) != tc.valid {
+			t.Errorf("parseTrustedProxies(%v), Expected valid: %v, Got: %v", tc.proxies, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// defaultLockoutDuration - First lockout, if the config has none
+const defaultLockoutDuration = time.Minute
+
+// rateLimitSweepInterval - How often idle buckets and old failures are removed
+const rateLimitSweepInterval = time.Minute
+
+// NewRateLimits - Rate limits and lockouts for the token endpoint and the login form. The same
+// limits are given to both, so failed logins and password grants count together. Nil if conf is nil
+func NewRateLimits(conf *models.RateLimitConfig) (*RateLimits, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	proxies, err := parseTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return newRateLimits(*conf, proxies), nil
+}
+
+// RateLimits - Token buckets per source IP and authenticated client, and failed authentications per
+// source IP, and per client_id and username from a source IP
+type RateLimits struct {
+	conf     models.RateLimitConfig
+	proxies  []*net.IPNet
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	failures map[string]*failures
+	swept    time.Time
+}
+
+// bucket - Token bucket. full is when the bucket is full again, and can be removed
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// failures - Failed authentications since the last success
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newRateLimits(conf models.RateLimitConfig, proxies []*net.IPNet) *RateLimits {
+	if conf.LockoutDuration <= 0 {
+		conf.LockoutDuration = defaultLockoutDuration
+	}
+	if conf.LockoutMax < conf.LockoutDuration {
+		conf.LockoutMax = conf.LockoutDuration
+	}
+	return &RateLimits{conf: conf, proxies: proxies, buckets: map[string]*bucket{}, failures: map[string]*failures{}, swept: time.Now()}
+}
+
+// clientIP - Source IP of the request, behind the trusted proxies
+func (l *RateLimits) clientIP(r *http.Request) string {
+	return clientIP(r, l.proxies)
+}
+
+// allow - Check lockouts and the source IP rate limit. Returns how long to wait, 0 if allowed.
+// Locked out callers are rejected before the rate limit, so their requests are not counted
+func (l *RateLimits) allow(ip string, req *models.TokenRequest) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	for _, key := range lockoutKeys(ip, req) {
+		if f := l.failures[key]; f != nil && f.lockedUntil.After(now) {
+			return f.lockedUntil.Sub(now)
+		}
+	}
+	return l.take("ip:"+ip, l.conf.IPRate, l.conf.IPBurst, now)
+}
+
+// allowClient - Check the rate limit of an authenticated client. Returns how long to wait, 0 if allowed
+func (l *RateLimits) allowClient(clientID string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.take("client:"+clientID, l.conf.ClientRate, l.conf.ClientBurst, time.Now())
+}
+
+// take - Take a token from the bucket. Returns how long until a token is available if it is empty
+func (l *RateLimits) take(key string, rate float64, burst int, now time.Time) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.updated = now
+	var wait time.Duration
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		wait = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return wait
+}
+
+// record - Count failed client and password authentications. A success clears the failures
+// of the client_id and username from the source IP, but not of the source IP itself
+func (l *RateLimits) record(ip string, req *models.TokenRequest, err error) {
+	if l.conf.LockoutThreshold <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err == nil {
+		for _, key := range lockoutKeys(ip, req)[1:] {
+			delete(l.failures, key)
+		}
+		return
+	}
+	if !authenticationFailure(req, err) {
+		return
+	}
+	now := time.Now()
+	for _, key := range lockoutKeys(ip, req) {
+		f := l.failures[key]
+		if f == nil || now.Sub(f.last) > l.conf.LockoutMax {
+			f = &failures{}
+			l.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		if f.count < l.conf.LockoutThreshold {
+			continue
+		}
+		d := l.conf.LockoutDuration
+		for i := l.conf.LockoutThreshold; i < f.count && d < l.conf.LockoutMax; i++ {
+			d *= 2
+		}
+		if d > l.conf.LockoutMax {
+			d = l.conf.LockoutMax
+		}
+		f.lockedUntil = now.Add(d)
+		logger.Warning.Printf("%s locked out for %s after %d failed authentications", key, d, f.count)
+	}
+}
+
+// sweep - Remove full buckets and forgotten failures, so the maps do not grow without bound
+func (l *RateLimits) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateLimitSweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+	for key, f := range l.failures {
+		if now.Sub(f.last) > l.conf.LockoutMax && !f.lockedUntil.After(now) {
+			delete(l.failures, key)
+		}
+	}
+}
+
+// lockoutKeys - Failures are counted per source IP, and per client_id and username from the
+// source IP. Failures from other addresses can not lock a client or user out
+func lockoutKeys(ip string, req *models.TokenRequest) []string {
+	keys := []string{"ip:" + ip}
+	if req.ClientID != "" {
+		keys = append(keys, "client:"+req.ClientID+"@"+ip)
+	}
+	if req.Username != "" && req.GrantType == "password" {
+		keys = append(keys, "user:"+req.Username+"@"+ip)
+	}
+	return keys
+}
+
+// authenticationFailure - Client authentication failed, or a password grant had a wrong password
+func authenticationFailure(req *models.TokenRequest, err error) bool {
+	switch toOAuthError(err).Code {
+	case errCodeInvalidClient:
+		return true
+	case errCodeInvalidGrant:
+		return req.GrantType == "password"
+	}
+	return false
+}
+
+// limitClient - Charge the rate limit of the client. Only called once the client is authenticated,
+// so nobody can use up the budget of another client. Public clients have no credentials, and are
+// only limited per source IP
+func (h *tokenHandler) limitClient(client *models.Client) error {
+	if h.limits == nil || client.GetPublic() {
+		return nil
+	}
+	if wait := h.limits.allowClient(client.GetClientId()); wait > 0 {
+		return errTooManyRequests(wait, "Too many requests for client_id: %s", client.GetClientId())
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func TestRateLimitBuckets(t *testing.T) {
+	l := newRateLimits(models.RateLimitConfig{IPRate: 1, IPBurst: 2, ClientRate: 10, ClientBurst: 1}, nil)
+	req := &models.TokenRequest{ClientID: "cl1"}
+	if wait := l.allow("203.0.113.7", req); wait != 0 {
+		t.Fatalf("First request, Expected allowed, Got wait: %s", wait)
+	}
+	if wait := l.allow("203.0.113.7", req); wait != 0 {
+		t.Errorf("Second request from IP, Expected allowed, Got wait: %s", wait)
+	}
+	if wait := l.allow("203.0.113.7", req); wait <= 0 || wait > time.Second {
+		t.Errorf("IP burst, Expected wait up to 1s, Got wait: %s", wait)
+	}
+	// Tokens are refilled at the rate
+	l.buckets["ip:203.0.113.7"].updated = time.Now().Add(-time.Second)
+	if wait := l.allow("203.0.113.7", req); wait != 0 {
+		t.Errorf("After refill, Expected allowed, Got wait: %s", wait)
+	}
+	// Other IPs have their own bucket
+	if wait := l.allow("203.0.113.8", req); wait != 0 {
+		t.Errorf("Other IP, Expected allowed, Got wait: %s", wait)
+	}
+
+	// Client burst is 1
+	if wait := l.allowClient("cl1"); wait != 0 {
+		t.Errorf("First client request, Expected allowed, Got wait: %s", wait)
+	}
+	if wait := l.allowClient("cl1"); wait <= 0 || wait > 100*time.Millisecond {
+		t.Errorf("Client burst, Expected wait up to 100ms, Got: %s", wait)
+	}
+	if wait := l.allowClient("cl2"); wait != 0 {
+		t.Errorf("Other client, Expected allowed, Got wait: %s", wait)
+	}
+
+	// Unlimited if the rate is 0
+	l = newRateLimits(models.RateLimitConfig{}, nil)
+	for i := 0; i < 100; i++ {
+		if wait := l.allow("203.0.113.7", req); wait != 0 {
+			t.Fatalf("No limits, Expected allowed, Got wait: %s", wait)
+		}
+		if wait := l.allowClient("cl1"); wait != 0 {
+			t.Fatalf("No limits, Expected client allowed, Got wait: %s", wait)
+		}
+	}
+}
+
+func TestTokenHandleClientRateLimit(t *testing.T) {
+	limits, err := NewRateLimits(&models.RateLimitConfig{ClientRate: 0.001, ClientBurst: 1})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetAuthorization(auth)
+	h.SetRateLimits(limits)
+
+	post := func(secret string) int {
+		body := url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl1"}, "client_secret": {secret}}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", contentTypeForm)
+		rr := httptest.NewRecorder()
+		h.Handle(rr, req)
+		return rr.Code
+	}
+	// Requests that fail authentication do not use the budget of the client
+	for i := 0; i < 3; i++ {
+		if code := post("wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401, Got: %d", code)
+		}
+	}
+	if code := post("secret1"); code != http.StatusOK {
+		t.Errorf("Expected 200, Got: %d", code)
+	}
+	if code := post("secret1"); code != http.StatusTooManyRequests {
+		t.Errorf("Client burst used, Expected 429, Got: %d", code)
+	}
+}
+
+func TestRateLimitLockout(t *testing.T) {
+	l := newRateLimits(models.RateLimitConfig{LockoutThreshold: 3, LockoutDuration: time.Minute, LockoutMax: 3 * time.Minute}, nil)
+	bad := &models.TokenRequest{GrantType: "client_credentials", ClientID: "cl1"}
+	failed := errInvalidClient("Client authentication failed")
+	for i := 0; i < 2; i++ {
+		l.record("203.0.113.7", bad, failed)
+		if wait := l.allow("203.0.113.7", bad); wait != 0 {
+			t.Fatalf("Failure %d, Expected allowed, Got wait: %s", i+1, wait)
+		}
+	}
+	l.record("203.0.113.7", bad, failed)
+	if wait := l.allow("203.0.113.7", bad); wait <= 59*time.Second || wait > time.Minute {
+		t.Errorf("Threshold reached, Expected 1m lockout, Got wait: %s", wait)
+	}
+	// Failures from one source IP do not lock the client_id out from others
+	if wait := l.allow("198.51.100.1", bad); wait != 0 {
+		t.Errorf("Other IP, Expected client_id allowed, Got wait: %s", wait)
+	}
+	if wait := l.allow("203.0.113.7", &models.TokenRequest{ClientID: "cl2"}); wait <= 0 {
+		t.Error("Source IP should be locked out for other clients")
+	}
+	// The lockout doubles, up to the max
+	l.record("203.0.113.7", bad, failed)
+	if f := l.failures["client:cl1@203.0.113.7"]; time.Until(f.lockedUntil) <= 119*time.Second || time.Until(f.lockedUntil) > 2*time.Minute {
+		t.Errorf("Second lockout, Expected 2m, Got: %s", time.Until(f.lockedUntil))
+	}
+	l.record("203.0.113.7", bad, failed)
+	l.record("203.0.113.7", bad, failed)
+	if wait := l.allow("203.0.113.7", bad); wait <= 179*time.Second || wait > 3*time.Minute {
+		t.Errorf("Lockout max, Expected 3m, Got wait: %s", wait)
+	}
+	// A success clears the client_id from the source IP, but not the source IP
+	l.record("203.0.113.7", bad, nil)
+	if _, ok := l.failures["client:cl1@203.0.113.7"]; ok {
+		t.Error("After success, Expected client_id failures cleared")
+	}
+	if wait := l.allow("203.0.113.7", &models.TokenRequest{ClientID: "cl2"}); wait <= 0 {
+		t.Error("After success, source IP should still be locked out")
+	}
+	// Failures are forgotten LockoutMax after the last one
+	l.failures["ip:203.0.113.7"].lockedUntil = time.Time{}
+	l.failures["ip:203.0.113.7"].last = time.Now().Add(-4 * time.Minute)
+	l.record("203.0.113.7", bad, failed)
+	if f := l.failures["ip:203.0.113.7"]; f.count != 1 {
+		t.Errorf("Forgotten failures, Expected count: 1, Got: %d", f.count)
+	}
+}
+
+func TestLockoutKeys(t *testing.T) {
+	var testResp = []struct {
+		name string
+		req  *models.TokenRequest // input
+		exp  []string             // expected keys
+	}{
+		{"no client", &models.TokenRequest{}, []string{"ip:203.0.113.7"}},
+		{"client", &models.TokenRequest{ClientID: "cl1"}, []string{"ip:203.0.113.7", "client:cl1@203.0.113.7"}},
+		{"password", &models.TokenRequest{GrantType: "password", ClientID: "cl1", Username: "alice"},
+			[]string{"ip:203.0.113.7", "client:cl1@203.0.113.7", "user:alice@203.0.113.7"}},
+		{"username without password grant", &models.TokenRequest{GrantType: "client_credentials", Username: "alice"}, []string{"ip:203.0.113.7"}},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			if res := lockoutKeys("203.0.113.7", tc.req); !reflect.DeepEqual(res, tc.exp) {
+				t.Errorf("Expected: %v, Got: %v", tc.exp, res)
+			}
+		})
+	}
+}
+
+func TestAuthenticationFailure(t *testing.T) {
+	var testResp = []struct {
+		grantType string // request grant type
+		err       error  // grant error
+		exp       bool   // expected to count as failure
+	}{
+		{"client_credentials", errInvalidClient("x"), true},
+		{"password", errInvalidGrant("x"), true},
+		{"password", errInvalidRequest("x"), false},
+		{"refresh_token", errInvalidGrant("x"), false},
+		{"client_credentials", errInvalidScope("x"), false},
+		{"client_credentials", errors.New("x"), false},
+	}
+	for _, tc := range testResp {
+		if res := authenticationFailure(&models.TokenRequest{GrantType: tc.grantType}, tc.err); res != tc.exp {
+			t.Errorf("authenticationFailure(%s, %v), Expected: %v, Got: %v", tc.grantType, tc.err, tc.exp, res)
+		}
+	}
+}
+
+func TestTokenHandleRateLimited(t *testing.T) {
+	limits, err := NewRateLimits(&models.RateLimitConfig{LockoutThreshold: 2, LockoutDuration: time.Minute, TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetAuthorization(auth)
+	h.SetRateLimits(limits)
+
+	post := func(secret string) *httptest.ResponseRecorder {
+		body := url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl1"}, "client_secret": {secret}}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", contentTypeForm)
+		req.RemoteAddr = "10.1.2.3:5000"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		rr := httptest.NewRecorder()
+		h.Handle(rr, req)
+		return rr
+	}
+	for i := 0; i < 2; i++ {
+		if rr := post("wrong"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401, Got: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	// Locked out, also with the right secret
+	rr := post("secret1")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), errCodeTemporarilyUnavailable) {
+		t.Fatalf("Expected 429, Got: %d %s", rr.Code, rr.Body.String())
+	}
+	if ra := rr.Header().Get("Retry-After"); ra != "60" {
+		t.Errorf("Retry-After, Expected: 60, Got: %s", ra)
+	}
+	if _, ok := limits.failures["ip:203.0.113.7"]; !ok {
+		t.Error("Expected failures counted for the forwarded client IP")
+	}
+	if _, err := NewRateLimits(&models.RateLimitConfig{TrustedProxies: []string{"not-an-ip"}}); err == nil {
+		t.Error("Expected error for invalid trusted proxy")
+	}
+}
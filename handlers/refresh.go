@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// refreshTokenLifetime - Lifetime of an unused refresh token
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+// handleRefreshToken - Exchange a refresh token for a new access token and a rotated refresh token.
+// A refresh token can only be used once. Using it again revokes the whole rotation family
+func (h *tokenHandler) handleRefreshToken(authorization *models.Authorization, req *models.TokenRequest) (*models.TokenResponse, error) {
+	client, err := h.authenticate(authorization, req)
+	if err != nil {
+		return nil, err
+	}
+	if !allowsGrant(client, "refresh_token") || h.refreshStore == nil {
+		return nil, errUnauthorizedClient("Client: %s is not allowed to use refresh tokens", client.GetClientId())
+	}
+	if req.RefreshToken == "" {
+		return nil, errInvalidRequest("refresh_token is required")
+	}
+
+	// Check the client, audience, DPoP key and a requested scope before the token is used, so an
+	// invalid request does not cost the real holder its token, or revoke its family. A used token
+	// presented by its own client is left to Consume, which detects the reuse
+	if rt, err := h.refreshStore.Get(hashToken(req.RefreshToken)); err == nil {
+		if rt.ClientID != client.GetClientId() {
+			logger.Warning.Printf("Refresh token for client: %s presented by client: %s", rt.ClientID, client.GetClientId())
+			return nil, errInvalidGrant("Refresh token is invalid, expired or revoked")
+		}
+		if !rt.Used {
+			if rt.Jkt != "" && rt.Jkt != req.DPoPJkt {
+				return nil, errInvalidDPoPProof("Refresh token is bound to another DPoP key")
+			}
+			if _, err := resolveAudience(client, rt.Audience); err != nil {
+				return nil, err
+			}
+			if req.Scope != "" {
+				if _, err := resolveScope(refreshGrantScope(client, rt), req.Scope); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	rt, err := h.refreshStore.Consume(hashToken(req.RefreshToken))
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		return nil, errInvalidGrant("Refresh token is invalid, expired or revoked")
+	case store.ErrReused:
+		logger.Warning.Printf("Refresh token reuse detected for client: %s, revoking token family", rt.ClientID)
+		h.revokeRefreshFamily(rt.FamilyID)
+		return nil, errInvalidGrant("Refresh token is invalid, expired or revoked")
+	default:
+		return nil, errServerError("Refresh token lookup failed: %s", err)
+	}
+	if rt.ClientID != client.GetClientId() {
+		// Token presented by another client than it was issued to. Treat it as compromised
+		logger.Warning.Printf("Refresh token for client: %s presented by client: %s, revoking token family", rt.ClientID, client.GetClientId())
+		h.revokeRefreshFamily(rt.FamilyID)
+		return nil, errInvalidGrant("Refresh token is invalid, expired or revoked")
+	}
+	if rt.Jkt != "" && rt.Jkt != req.DPoPJkt {
+		logger.Warning.Printf("DPoP-bound refresh token for client: %s used without its key, revoking token family", rt.ClientID)
+		h.revokeRefreshFamily(rt.FamilyID)
+		return nil, errInvalidGrant("Refresh token is invalid, expired or revoked")
+	}
+	// The client may have lost access to the audience since the token was issued
+	audience, err := resolveAudience(client, rt.Audience)
+	if err != nil {
+		return nil, err
+	}
+	// A subset of the refresh token scope can be requested
+	granted := refreshGrantScope(client, rt)
+	scope, err := resolveScope(granted, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	g := &tokenGrant{authorization: authorization, client: client, audience: audience, scope: scope, refreshScope: granted, cnf: h.tokenConfirmation(client, req)}
+	if rt.Subject != "" {
+		// User token. The user must still exist and be enabled
+		g.user = findUser(authorization, rt.Subject)
+		if g.user == nil || g.user.GetDisabled() {
+			h.revokeRefreshFamily(rt.FamilyID)
+			return nil, errInvalidGrant("Refresh token is invalid, expired or revoked")
+		}
+	}
+	return h.issueTokens(g, rt.FamilyID)
+}
+
+// refreshGrantScope - Scope of the refresh token, without scopes the client has lost since it was issued.
+// Tokens stored without a scope get the current client scopes
+func refreshGrantScope(client *models.Client, rt *models.RefreshToken) []string {
+	granted := clientScopes(client)
+	if rt.Scope == "" {
+		return granted
+	}
+	return intersectScopes(strings.Fields(rt.Scope), granted)
+}
+
+// generateRefreshToken - Create and store a new refresh token. Only the hash of the token is stored
+func (h *tokenHandler) generateRefreshToken(g *tokenGrant, familyID string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	id := hashToken(token)
+	if familyID == "" {
+		familyID = id
+	}
+	now := time.Now()
+	err = h.refreshStore.Save(&models.RefreshToken{
+		ID:        id,
+		FamilyID:  familyID,
+		ClientID:  g.client.GetClientId(),
+		Subject:   g.user.GetUsername(),
+		Audience:  g.audience,
+		Scope:     strings.Join(g.refreshTokenScope(), " "),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(refreshTokenLifetime),
+		Jkt:       g.refreshTokenJkt(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (h *tokenHandler) revokeRefreshFamily(familyID string) {
+	if err := h.refreshStore.RevokeFamily(familyID); err != nil {
+		logger.Error.Printf("Revoke of refresh token family failed: %s", err)
+	}
+}
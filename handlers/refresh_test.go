@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+var refreshAuth = &models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+	&models.Client{ClientId: "cl1", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G", Scope: "sc", AllowRefreshToken: true},
+	&models.Client{ClientId: "cl2", ClientSecret: "$2a$10$a/JANxkdgbJtc0i36ZEk.eVxoUaMdvMhr/k4fpjL5kTbAeZJFpeIm", Scope: "sc", AllowRefreshToken: true},
+	&models.Client{ClientId: "cl3", ClientSecret: "$2a$10$0sxSR6FKk8msHgPSBN0Au.sGW3HQxRughWXsAZMq8GAVDcrTfFeLm", Scope: "sc"}}}
+
+func newRefreshTestHandler() *tokenHandler {
+	keys := testKeyRing()
+	h := &tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(refreshAuth)
+	h.SetRefreshTokenStore(store.NewMemoryRefreshTokenStore())
+	return h
+}
+
+// expectOAuthError - Fail unless err is an oauthError with the given code
+func expectOAuthError(t *testing.T, err error, code string) {
+	t.Helper()
+	oe, ok := err.(*oauthError)
+	if !ok {
+		t.Errorf("Expected %s error, Got: %v", code, err)
+		return
+	}
+	if oe.Code != code {
+		t.Errorf("Expected %s error, Got: %v", code, oe)
+	}
+}
+
+func TestRefreshTokenIssued(t *testing.T) {
+	h := newRefreshTestHandler()
+
+	res, err := h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.RefreshToken == "" {
+		t.Error("Expected refresh token for client allowing refresh tokens")
+	}
+	res, err = h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl3", ClientSecret: "secret3"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.RefreshToken != "" {
+		t.Error("Unexpected refresh token for client not allowing refresh tokens")
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	h := newRefreshTestHandler()
+
+	res, err := h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Audience: "Aud"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first := res.RefreshToken
+
+	res, err = h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "refresh_token", ClientID: "cl1", ClientSecret: "secret1", RefreshToken: first})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.AccessToken == "" {
+		t.Error("Expected access token")
+	}
+	second := res.RefreshToken
+	if second == "" || second == first {
+		t.Errorf("Expected rotated refresh token, Got: %v", second)
+	}
+
+	// Replay of the first token revokes the family, including the second token
+	_, err = h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "refresh_token", ClientID: "cl1", ClientSecret: "secret1", RefreshToken: first})
+	expectOAuthError(t, err, errCodeInvalidGrant)
+	_, err = h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "refresh_token", ClientID: "cl1", ClientSecret: "secret1", RefreshToken: second})
+	expectOAuthError(t, err, errCodeInvalidGrant)
+}
+
+func TestRefreshTokenOtherClient(t *testing.T) {
+	h := newRefreshTestHandler()
+
+	res, err := h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "refresh_token", ClientID: "cl2", ClientSecret: "secret2", RefreshToken: res.RefreshToken})
+	expectOAuthError(t, err, errCodeInvalidGrant)
+
+	// Token is not used or revoked for the owning client
+	_, err = h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "refresh_token", ClientID: "cl1", ClientSecret: "secret1", RefreshToken: res.RefreshToken})
+	if err != nil {
+		t.Errorf("Got uinexpected error: %v", err)
+	}
+}
+
+func TestRefreshTokenAudienceRemoved(t *testing.T) {
+	h := newRefreshTestHandler()
+
+	res, err := h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Audience: "Aud"})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+
+	// The client lost the audience. The request fails without using the token
+	restricted := proto.Clone(refreshAuth).(*models.Authorization)
+	restricted.Clients[0].AllowedAudiences = []string{"Other"}
+	h.SetAuthorization(restricted)
+	_, err = h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "refresh_token", ClientID: "cl1", ClientSecret: "secret1", RefreshToken: res.RefreshToken})
+	expectOAuthError(t, err, errCodeInvalidTarget)
+
+	h.SetAuthorization(refreshAuth)
+	_, err = h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: "refresh_token", ClientID: "cl1", ClientSecret: "secret1", RefreshToken: res.RefreshToken})
+	if err != nil {
+		t.Errorf("Got uinexpected error: %v", err)
+	}
+}
+
+func TestHandleRefreshTokenErrors(t *testing.T) {
+	h := newRefreshTestHandler()
+
+	var testResp = []struct {
+		req  *models.TokenRequest // request
+		code string               // expected error code
+	}{
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret2", RefreshToken: "rt"}, errCodeInvalidClient},
+		{&models.TokenRequest{ClientID: "cl3", ClientSecret: "secret3", RefreshToken: "rt"}, errCodeUnauthorizedClient},
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"}, errCodeInvalidRequest},
+		{&models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", RefreshToken: "unknown"}, errCodeInvalidGrant},
+	}
+
+	for _, tc := range testResp {
+		t.Run(tc.req.ClientID+tc.code, func(t *testing.T) {
+			_, err := h.handleRefreshToken(h.authorization.Load(), tc.req)
+			expectOAuthError(t, err, tc.code)
+		})
+	}
+}
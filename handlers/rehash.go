@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// SecretUpdater - Stores upgraded client secret hashes
+type SecretUpdater interface {
+	// UpdateClientSecret - Replace the secret hash of the client, unless it has changed from oldHash
+	UpdateClientSecret(clientID, oldHash, newHash string) error
+}
+
+// SetSecretUpdater - Rehash client secrets that do not use the default algorithm and cost
+// when the client authenticates, and store the new hash with u. Nil disables rehash
+func (a *clientAuthenticator) SetSecretUpdater(u SecretUpdater) {
+	a.secretUpdater = u
+}
+
+// rehashClientSecret - Upgrade the secret hash of an authenticated client, if needed. The hash runs
+// in the background on the verification pool, and is dropped if the pool is busy. The client is
+// rehashed on a later authentication instead
+func (a *clientAuthenticator) rehashClientSecret(client *models.Client, secret string) {
+	updater := a.secretUpdater
+	if updater == nil || !passwd.NeedsRehash(client.GetClientSecret()) {
+		return
+	}
+	if _, running := a.rehashing.LoadOrStore(client.GetClientId(), true); running {
+		return
+	}
+	c, pool := passwd.Default(), a.pool
+	go func() {
+		defer a.rehashing.Delete(client.GetClientId())
+		hash, err := pool.Hash(secret, c)
+		switch err {
+		case nil:
+		case passwd.ErrBusy, passwd.ErrTimeout:
+			logger.Warning.Printf("Client: %s secret rehash skipped: %s", client.GetClientId(), err)
+			return
+		default:
+			logger.Error.Printf("Client: %s secret could not be rehashed: %s", client.GetClientId(), err)
+			return
+		}
+		if err := updater.UpdateClientSecret(client.GetClientId(), client.GetClientSecret(), hash); err != nil {
+			logger.Error.Printf("Client: %s secret could not be rehashed: %s", client.GetClientId(), err)
+		}
+	}()
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// testSecretUpdater - Sends secret updates on the channel
+type testSecretUpdater chan [3]string
+
+func (u testSecretUpdater) UpdateClientSecret(clientID, oldHash, newHash string) error {
+	u <- [3]string{clientID, oldHash, newHash}
+	return nil
+}
+
+// waitUpdate - Wait for the background rehash to update the secret
+func (u testSecretUpdater) waitUpdate(t *testing.T) [3]string {
+	t.Helper()
+	select {
+	case update := <-u:
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected secret update")
+	}
+	return [3]string{}
+}
+
+func TestRehashClientSecret(t *testing.T) {
+	u := make(testSecretUpdater, 10)
+	pool := passwd.NewPool(passwd.PoolConfig{Workers: 1})
+	c := clientAuthenticator{}
+	c.SetSecretUpdater(u)
+	c.SetVerifyPool(pool)
+	defer passwd.SetDefault(passwd.Config{})
+
+	// bcrypt cost 10 is the default, nothing to upgrade
+	if _, err := c.authenticateClient(auth, &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"}); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if len(u) != 0 {
+		t.Errorf("Expected no rehash with the default algorithm, Got: %d", len(u))
+	}
+
+	if err := passwd.SetDefault(passwd.Config{Algorithm: passwd.Argon2id, Cost: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// Failed authentication never rehashes
+	if _, err := c.authenticateClient(auth, &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret2"}); err == nil {
+		t.Fatal("Expected authentication to fail")
+	}
+	if len(u) != 0 {
+		t.Errorf("Expected no rehash on failed authentication, Got: %d", len(u))
+	}
+
+	if _, err := c.authenticateClient(auth, &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"}); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	update := u.waitUpdate(t)
+	if update[0] != "cl1" || update[1] != auth.Clients[0].GetClientSecret() {
+		t.Fatalf("Expected rehash of cl1, Got: %v", update)
+	}
+	if !strings.HasPrefix(update[2], "$argon2id$") {
+		t.Errorf("Expected argon2id hash, Got: %s", update[2])
+	}
+	if err := passwd.ComparePasswords("secret1", update[2]); err != nil {
+		t.Errorf("New hash does not match the secret: %v", err)
+	}
+	// Three verifications and the rehash ran on the handler pool
+	if s := pool.Stats(); s.Verified != 4 {
+		t.Errorf("Pool verified, Expected: 4, Got: %d", s.Verified)
+	}
+}
+
+func TestRehashClientSecretRunning(t *testing.T) {
+	u := make(testSecretUpdater, 10)
+	c := clientAuthenticator{}
+	c.SetSecretUpdater(u)
+	if err := passwd.SetDefault(passwd.Config{Algorithm: passwd.Argon2id, Cost: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer passwd.SetDefault(passwd.Config{})
+
+	// A rehash already running for the client is not started again
+	c.rehashing.Store("cl1", true)
+	c.rehashClientSecret(auth.Clients[0], "secret1")
+	c.rehashing.Delete("cl1")
+	time.Sleep(10 * time.Millisecond)
+	if len(u) != 0 {
+		t.Errorf("Expected no second rehash, Got: %d", len(u))
+	}
+
+	c.rehashClientSecret(auth.Clients[0], "secret1")
+	if update := u.waitUpdate(t); update[0] != "cl1" {
+		t.Errorf("Expected rehash of cl1, Got: %v", update)
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// parseTokenRequest - Decode a token, introspection or revocation request from a JSON or
+// form encoded body, and apply client credentials given with HTTP Basic authentication
+func parseTokenRequest(r *http.Request) (*models.TokenRequest, error) {
+	req, err := decodeTokenRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyBasicAuth(r, req); err != nil {
+		return nil, err
+	}
+	req.EndpointPath = r.URL.Path
+	if r.TLS != nil {
+		req.ClientCertificates = r.TLS.PeerCertificates
+	}
+	// RFC 9449 4.3 - A request can only have one DPoP proof
+	if proofs := r.Header[http.CanonicalHeaderKey("DPoP")]; len(proofs) > 1 {
+		return nil, errInvalidDPoPProof("Request has more than one DPoP header")
+	} else if len(proofs) == 1 {
+		req.DPoPProof = proofs[0]
+	}
+	return req, nil
+}
+
+// decodeTokenRequest - Decode body based on Content-Type
+func decodeTokenRequest(r *http.Request) (*models.TokenRequest, error) {
+	mediaType := contentTypeJSON
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return nil, errInvalidRequest("Invalid Content-Type: %s", ct)
+		}
+		mediaType = mt
+	}
+
+	req := &models.TokenRequest{}
+	switch mediaType {
+	case contentTypeForm:
+		if err := r.ParseForm(); err != nil {
+			return nil, errInvalidRequest("Form body could not be parsed: %s", err)
+		}
+		req.GrantType = r.PostForm.Get("grant_type")
+		req.ClientID = r.PostForm.Get("client_id")
+		req.ClientSecret = r.PostForm.Get("client_secret")
+		req.Audience = r.PostForm.Get("audience")
+		req.Scope = r.PostForm.Get("scope")
+		req.RefreshToken = r.PostForm.Get("refresh_token")
+		req.Username = r.PostForm.Get("username")
+		req.Password = r.PostForm.Get("password")
+		req.Code = r.PostForm.Get("code")
+		req.RedirectURI = r.PostForm.Get("redirect_uri")
+		req.CodeVerifier = r.PostForm.Get("code_verifier")
+		req.Token = r.PostForm.Get("token")
+		req.TokenTypeHint = r.PostForm.Get("token_type_hint")
+		req.Assertion = r.PostForm.Get("assertion")
+		req.SubjectToken = r.PostForm.Get("subject_token")
+		req.SubjectTokenType = r.PostForm.Get("subject_token_type")
+		req.ActorToken = r.PostForm.Get("actor_token")
+		req.ActorTokenType = r.PostForm.Get("actor_token_type")
+		req.RequestedTokenType = r.PostForm.Get("requested_token_type")
+		req.ClientAssertion = r.PostForm.Get("client_assertion")
+		req.ClientAssertionType = r.PostForm.Get("client_assertion_type")
+	case contentTypeJSON:
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return nil, errInvalidRequest("JSON body could not be parsed: %s", err)
+		}
+	default:
+		return nil, errInvalidRequest("Content-Type: %s not supported", mediaType)
+	}
+	return req, nil
+}
+
+// applyBasicAuth - Read client credentials from the Authorization header (client_secret_basic).
+// Credentials can only be given one way, so a secret in the body together with a header is an error
+func applyBasicAuth(r *http.Request, req *models.TokenRequest) error {
+	if r.Header.Get("Authorization") == "" {
+		return nil
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return errInvalidRequest("Authorization header is not valid Basic authentication")
+	}
+	// RFC 6749 2.3.1 - Client ID and secret are form encoded before base64 encoding
+	clientID, err := url.QueryUnescape(user)
+	if err != nil {
+		return errInvalidRequest("Basic authentication client_id could not be decoded: %s", err)
+	}
+	clientSecret, err := url.QueryUnescape(pass)
+	if err != nil {
+		return errInvalidRequest("Basic authentication client_secret could not be decoded: %s", err)
+	}
+	if req.ClientSecret != "" {
+		return errInvalidRequest("Client credentials given in both Authorization header and body")
+	}
+	if req.ClientID != "" && req.ClientID != clientID {
+		return errInvalidRequest("client_id in body does not match Authorization header")
+	}
+	req.ClientID = clientID
+	req.ClientSecret = clientSecret
+	return nil
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jafossum/go-auth-server/crypto/keyring"
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+//go:generate mockgen -destination=../mocks/revoke_handler_mock.go -package=mocks github.com/jafossum/go-auth-server/handlers IRevokeHandler
+
+// IRevokeHandler : RevokeHandler Interace
+type IRevokeHandler interface {
+	SetKeyRing(keys *keyring.KeyRing)
+	SetAuthorization(authorization *models.Authorization)
+	SetRefreshTokenStore(refreshStore store.RefreshTokenStore)
+	SetRevocationStore(revocations store.RevocationStore)
+	SetClientCertificateAuth(roots *x509.CertPool)
+	SetReplayCache(replays store.ReplayCache)
+	SetVerifyPool(pool *passwd.Pool)
+	SetSecretUpdater(u SecretUpdater)
+	Handle(w http.ResponseWriter, r *http.Request)
+}
+
+// RevokeHandler - Token revocation handler (RFC 7009)
+var RevokeHandler IRevokeHandler = &revokeHandler{}
+
+type revokeHandler struct {
+	clientAuthenticator
+	keys          *keyring.KeyRing
+	authorization authorizationSnapshot
+	refreshStore  store.RefreshTokenStore
+	revocations   store.RevocationStore
+}
+
+// SetKeyRing - Initialize with signing and verification keys
+func (h *revokeHandler) SetKeyRing(keys *keyring.KeyRing) {
+	h.keys = keys
+}
+
+// SetAuthorization - Initialize with authorization data
+func (h *revokeHandler) SetAuthorization(authorization *models.Authorization) {
+	h.authorization.Store(authorization)
+}
+
+// SetRefreshTokenStore - Initialize with refresh token storage
+func (h *revokeHandler) SetRefreshTokenStore(refreshStore store.RefreshTokenStore) {
+	h.refreshStore = refreshStore
+}
+
+// SetRevocationStore - Initialize with revoked token storage
+func (h *revokeHandler) SetRevocationStore(revocations store.RevocationStore) {
+	h.revocations = revocations
+}
+
+// Handle - Revocation Endpoint handler. Clients can revoke their own access and refresh tokens,
+// and admin clients can revoke any token. Unknown and invalid tokens are ignored (RFC 7009 2.2)
+func (h *revokeHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	setNoCache(w)
+
+	req, err := parseTokenRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	authorization := h.authorization.Load()
+	client, err := h.authenticateClient(authorization, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Token == "" {
+		writeError(w, errInvalidRequest("token is required"))
+		return
+	}
+
+	// Access tokens are JWTs, refresh tokens are opaque
+	if strings.Count(req.Token, ".") == 2 {
+		err = h.revokeAccessToken(authorization, client, req.Token)
+	} else {
+		err = h.revokeRefreshToken(client, req.Token)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeAccessToken - Add the token ID to the revocation store until the token expires
+func (h *revokeHandler) revokeAccessToken(authorization *models.Authorization, client *models.Client, token string) error {
+	claims, err := parseAccessToken(h.keys, authorization.GetIssuer(), h.revocations, token)
+	if err != nil {
+		logger.Info.Printf("Revocation of invalid access token ignored: %s", err)
+		return nil
+	}
+	if !mayRevoke(client, stringClaim(claims, "client_id")) {
+		return errUnauthorizedClient("Token was not issued to client: %s", client.GetClientId())
+	}
+	jti := stringClaim(claims, "jti")
+	if jti == "" {
+		return errInvalidRequest("Token has no jti, and can not be revoked")
+	}
+	if err := h.revocations.Revoke(jti, time.Unix(int64Claim(claims, "exp"), 0)); err != nil {
+		return errServerError("Token revocation failed: %s", err)
+	}
+	logger.Info.Printf("Access token: %s revoked by client: %s", jti, client.GetClientId())
+	return nil
+}
+
+// revokeRefreshToken - Revoke the refresh token and every token rotated from the same original token
+func (h *revokeHandler) revokeRefreshToken(client *models.Client, token string) error {
+	rt, err := h.refreshStore.Get(hashToken(token))
+	if err == store.ErrNotFound {
+		logger.Info.Println("Revocation of unknown refresh token ignored")
+		return nil
+	}
+	if err != nil {
+		return errServerError("Refresh token lookup failed: %s", err)
+	}
+	if !mayRevoke(client, rt.ClientID) {
+		return errUnauthorizedClient("Token was not issued to client: %s", client.GetClientId())
+	}
+	if err := h.refreshStore.RevokeFamily(rt.FamilyID); err != nil {
+		return errServerError("Refresh token revocation failed: %s", err)
+	}
+	logger.Info.Printf("Refresh token family of client: %s revoked by client: %s", rt.ClientID, client.GetClientId())
+	return nil
+}
+
+// mayRevoke - Clients can revoke their own tokens, and admin clients any token
+func mayRevoke(client *models.Client, tokenClientID string) bool {
+	return client.GetClientId() == tokenClientID || (client.GetIsAdmin() && !client.GetPublic())
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+var revokeAuth = &models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+	&models.Client{ClientId: "cl1", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G", AllowRefreshToken: true},
+	&models.Client{ClientId: "cl2", ClientSecret: "$2a$10$a/JANxkdgbJtc0i36ZEk.eVxoUaMdvMhr/k4fpjL5kTbAeZJFpeIm", AllowRefreshToken: true},
+	&models.Client{ClientId: "cl3", ClientSecret: "$2a$10$0sxSR6FKk8msHgPSBN0Au.sGW3HQxRughWXsAZMq8GAVDcrTfFeLm", IsAdmin: true}}}
+
+func newRevokeTestHandlers() (*revokeHandler, *tokenHandler) {
+	keys := testKeyRing()
+	refreshStore := store.NewMemoryRefreshTokenStore()
+	revocations := store.NewMemoryRevocationStore()
+
+	th := &tokenHandler{}
+	th.SetKeyRing(keys)
+	th.SetAuthorization(revokeAuth)
+	th.SetRefreshTokenStore(refreshStore)
+
+	h := &revokeHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(revokeAuth)
+	h.SetRefreshTokenStore(refreshStore)
+	h.SetRevocationStore(revocations)
+	return h, th
+}
+
+func doRevoke(h *revokeHandler, user, pass, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/oauth/revoke", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(user, pass)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.Handle).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	h, th := newRevokeTestHandlers()
+	tr, err := th.handleClientCredentials(th.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := parseAccessToken(h.keys, "Test-Issuer", h.revocations, tr.AccessToken); err != nil {
+		t.Fatalf("Expected valid token before revocation: %v", err)
+	}
+
+	// Other clients can not revoke the token
+	if rr := doRevoke(h, "cl2", "secret2", tr.AccessToken); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doRevoke(h, "cl1", "secret1", tr.AccessToken); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if _, err := parseAccessToken(h.keys, "Test-Issuer", h.revocations, tr.AccessToken); err == nil {
+		t.Error("Expected revoked token to be invalid")
+	}
+	// Revoking again is not an error
+	if rr := doRevoke(h, "cl1", "secret1", tr.AccessToken); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRevokeAccessTokenByAdmin(t *testing.T) {
+	h, th := newRevokeTestHandlers()
+	tr, err := th.handleClientCredentials(th.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rr := doRevoke(h, "cl3", "secret3", tr.AccessToken); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if _, err := parseAccessToken(h.keys, "Test-Issuer", h.revocations, tr.AccessToken); err == nil {
+		t.Error("Expected revoked token to be invalid")
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	h, th := newRevokeTestHandlers()
+	tr, err := th.handleClientCredentials(th.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if rr := doRevoke(h, "cl2", "secret2", tr.RefreshToken); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doRevoke(h, "cl1", "secret1", tr.RefreshToken); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	_, err = th.handleRefreshToken(th.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", RefreshToken: tr.RefreshToken})
+	expectOAuthError(t, err, errCodeInvalidGrant)
+}
+
+func TestRevokeHandleErrors(t *testing.T) {
+	h, _ := newRevokeTestHandlers()
+
+	var testResp = []struct {
+		name       string // test name
+		user, pass string // client credentials
+		token      string // token to revoke
+		status     int    // expected status code
+	}{
+		{"unknown refresh token", "cl1", "secret1", "unknown", http.StatusOK},
+		{"invalid access token", "cl1", "secret1", "a.b.c", http.StatusOK},
+		{"missing token", "cl1", "secret1", "", http.StatusBadRequest},
+		{"wrong secret", "cl1", "secret2", "unknown", http.StatusUnauthorized},
+	}
+	for _, tc := range testResp {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := doRevoke(h, tc.user, tc.pass, tc.token); rr.Code != tc.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.status)
+			}
+		})
+	}
+}
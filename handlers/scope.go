@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// clientScopes - Scopes granted to the client, from scopes and the deprecated space separated scope
+func clientScopes(client *models.Client) []string {
+	res := []string{}
+	for _, s := range append(client.GetScopes(), strings.Fields(client.GetScope())...) {
+		if !containsString(res, s) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// resolveScope - The requested scopes, or all allowed scopes if none are requested.
+// Every requested scope must be allowed (RFC 6749 3.3)
+func resolveScope(allowed []string, requested string) ([]string, error) {
+	if requested == "" {
+		return allowed, nil
+	}
+	res := []string{}
+	for _, s := range strings.Split(requested, " ") {
+		if !validScopeToken(s) {
+			return nil, errInvalidScope("scope: %q is not valid", requested)
+		}
+		if !containsString(allowed, s) {
+			return nil, errInvalidScope("scope: %s is not granted to the client", s)
+		}
+		if !containsString(res, s) {
+			res = append(res, s)
+		}
+	}
+	return res, nil
+}
+
+// intersectScopes - Scopes in a that are also in b, in the order of a
+func intersectScopes(a, b []string) []string {
+	res := []string{}
+	for _, s := range a {
+		if containsString(b, s) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// supportedScopes - All scopes granted to any client, sorted
+func supportedScopes(authorization *models.Authorization) []string {
+	res := []string{}
+	for _, client := range authorization.GetClients() {
+		for _, s := range clientScopes(client) {
+			if !containsString(res, s) {
+				res = append(res, s)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// validScopeToken - scope-token = 1*( %x21 / %x23-5B / %x5D-7E )
+func validScopeToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c < 0x21 || c > 0x7E || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
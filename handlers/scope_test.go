@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+func TestClientScopes(t *testing.T) {
+	var testResp = []struct {
+		client *models.Client // input
+		exp    []string       // expected result
+	}{
+		{&models.Client{}, []string{}},
+		{&models.Client{Scope: "read write"}, []string{"read", "write"}},
+		{&models.Client{Scope: " read  write "}, []string{"read", "write"}},
+		{&models.Client{Scopes: []string{"read", "admin"}}, []string{"read", "admin"}},
+		{&models.Client{Scopes: []string{"read", "admin"}, Scope: "read write"}, []string{"read", "admin", "write"}},
+	}
+
+	for _, tc := range testResp {
+		if res := clientScopes(tc.client); !reflect.DeepEqual(res, tc.exp) {
+			t.Errorf("clientScopes(%v), Expected: %v, Got: %v", tc.client, tc.exp, res)
+		}
+	}
+}
+
+func TestResolveScope(t *testing.T) {
+	allowed := []string{"read", "write", "admin"}
+	var testResp = []struct {
+		requested string   // input
+		exp       []string // expected result
+		err       bool     // expect error
+	}{
+		{"", allowed, false},
+		{"read", []string{"read"}, false},
+		{"write read", []string{"write", "read"}, false},
+		{"read read", []string{"read"}, false},
+		{"delete", nil, true},
+		{"read delete", nil, true},
+		{"read  write", nil, true},
+		{" read", nil, true},
+		{`re"ad`, nil, true},
+	}
+
+	for _, tc := range testResp {
+		res, err := resolveScope(allowed, tc.requested)
+		if err == nil && tc.err {
+			t.Errorf("resolveScope(%q), Not getting expected error", tc.requested)
+		}
+		if err != nil {
+			if !tc.err {
+				t.Errorf("resolveScope(%q), Got uinexpected error: %v", tc.requested, err)
+			}
+			expectOAuthError(t, err, errCodeInvalidScope)
+			continue
+		}
+		if !reflect.DeepEqual(res, tc.exp) {
+			t.Errorf("resolveScope(%q), Expected: %v, Got: %v", tc.requested, tc.exp, res)
+		}
+	}
+}
+
+func TestSupportedScopes(t *testing.T) {
+	a := &models.Authorization{Clients: []*models.Client{
+		{Scope: "write read"},
+		{Scopes: []string{"admin", "read"}},
+		{},
+	}}
+	exp := []string{"admin", "read", "write"}
+	if res := supportedScopes(a); !reflect.DeepEqual(res, exp) {
+		t.Errorf("supportedScopes(), Expected: %v, Got: %v", exp, res)
+	}
+}
+
+func TestScopeGrant(t *testing.T) {
+	h := &tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetAuthorization(&models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+		&models.Client{ClientId: "cl1", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G",
+			Scopes: []string{"read", "write", "admin"}, AllowRefreshToken: true}}})
+	h.SetRefreshTokenStore(store.NewMemoryRefreshTokenStore())
+
+	res, err := h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Scope != "read write admin" {
+		t.Errorf("Expected all granted scopes, Got: %v", res.Scope)
+	}
+
+	res, err = h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Scope: "read write"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Scope != "read write" {
+		t.Errorf("Expected requested scopes, Got: %v", res.Scope)
+	}
+
+	_, err = h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Scope: "read delete"})
+	expectOAuthError(t, err, errCodeInvalidScope)
+
+	// Down-scoped refresh. The rotated refresh token keeps the original scope
+	rt := res.RefreshToken
+	res, err = h.handleRefreshToken(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", RefreshToken: rt, Scope: "read"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Scope != "read" {
+		t.Errorf("Expected down-scoped access token, Got: %v", res.Scope)
+	}
+	rt = res.RefreshToken
+	_, err = h.handleRefreshToken(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", RefreshToken: rt, Scope: "admin"})
+	expectOAuthError(t, err, errCodeInvalidScope)
+
+	// The failed request did not use the token
+	res, err = h.handleRefreshToken(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", RefreshToken: rt})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Scope != "read write" {
+		t.Errorf("Expected refresh token scope, Got: %v", res.Scope)
+	}
+}
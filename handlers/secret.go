@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+)
+
+// errMismatch - Secret or password does not match the hash
+var errMismatch = errors.New("Secret does not match")
+
+// verifyPassword - Compare on the password verification pool. errMismatch if the password does
+// not match, and a 503 error if the pool is overloaded
+func verifyPassword(pool *passwd.Pool, plainPwd, hashedPwd string) error {
+	switch err := pool.Compare(plainPwd, hashedPwd); err {
+	case nil:
+		return nil
+	case passwd.ErrBusy, passwd.ErrTimeout:
+		return errServiceUnavailable("Password verification overloaded: %s", err)
+	default:
+		return errMismatch
+	}
+}
+
+// randomToken - Generate an opaque, URL safe random token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken - ID used to store an opaque token, so the token value itself is never stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,17 +1,22 @@
 package handlers
 
 import (
-	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/keyring"
 	"github.com/jafossum/go-auth-server/crypto/passwd"
-	rsaa "github.com/jafossum/go-auth-server/crypto/rsa"
+	"github.com/jafossum/go-auth-server/crypto/signing"
 	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
 	"github.com/jafossum/go-auth-server/utils/logger"
 )
 
@@ -19,8 +24,17 @@ import (
 
 // ITokenHandler : TokenHandler Interace
 type ITokenHandler interface {
-	SetCertificate(privateKey *rsa.PrivateKey)
+	SetKeyRing(keys *keyring.KeyRing)
 	SetAuthorization(authorization *models.Authorization)
+	SetRefreshTokenStore(refreshStore store.RefreshTokenStore)
+	SetAuthorizationCodeStore(codeStore store.AuthorizationCodeStore)
+	SetRevocationStore(revocations store.RevocationStore)
+	SetClientCertificateAuth(roots *x509.CertPool)
+	SetReplayCache(replays store.ReplayCache)
+	SetVerifyPool(pool *passwd.Pool)
+	SetDPoPNonceRequired(required bool)
+	SetRateLimits(limits *RateLimits)
+	SetSecretUpdater(u SecretUpdater)
 	Handle(w http.ResponseWriter, r *http.Request)
 }
 
@@ -28,85 +42,438 @@ type ITokenHandler interface {
 var TokenHandler ITokenHandler = &tokenHandler{}
 
 type tokenHandler struct {
-	privateKey    *rsa.PrivateKey
-	authorization *models.Authorization
+	clientAuthenticator
+	keys          *keyring.KeyRing
+	authorization authorizationSnapshot
+	refreshStore  store.RefreshTokenStore
+	codeStore     store.AuthorizationCodeStore
+	revocations   store.RevocationStore
+	// issuerKeys - Keys of the trusted issuers for the jwt-bearer grant
+	issuerKeys jwksCache
+	// dpopNonces - Server nonces. Nil if DPoP proofs need no nonce
+	dpopNonces *nonceSource
+	// limits - Rate limits and lockouts. Nil if disabled
+	limits *RateLimits
 }
 
-// SetCertificate - Initialize with setting certificates
-func (h *tokenHandler) SetCertificate(privateKey *rsa.PrivateKey) {
-	h.privateKey = privateKey
+// SetKeyRing - Initialize with signing and verification keys
+func (h *tokenHandler) SetKeyRing(keys *keyring.KeyRing) {
+	h.keys = keys
 }
 
 // SetAuthorization - Initialize with authorization data
 func (h *tokenHandler) SetAuthorization(authorization *models.Authorization) {
-	h.authorization = authorization
+	h.authorization.Store(authorization)
+}
+
+// SetRefreshTokenStore - Initialize with refresh token storage
+func (h *tokenHandler) SetRefreshTokenStore(refreshStore store.RefreshTokenStore) {
+	h.refreshStore = refreshStore
+}
+
+// SetAuthorizationCodeStore - Initialize with authorization code storage
+func (h *tokenHandler) SetAuthorizationCodeStore(codeStore store.AuthorizationCodeStore) {
+	h.codeStore = codeStore
+}
+
+// SetRevocationStore - Initialize with revoked token storage. Revoked tokens can not be exchanged
+func (h *tokenHandler) SetRevocationStore(revocations store.RevocationStore) {
+	h.revocations = revocations
+}
+
+// SetRateLimits - Enable rate limiting and lockout. Nil disables them
+func (h *tokenHandler) SetRateLimits(limits *RateLimits) {
+	h.limits = limits
 }
 
 // Handle - Tokewn Endpoint handler
 func (h *tokenHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	setNoCache(w)
+	if h.dpopNonces != nil {
+		w.Header().Set("DPoP-Nonce", h.dpopNonces.nonce())
+	}
 
-	var req = &models.TokenRequest{}
-	_ = json.NewDecoder(r.Body).Decode(req)
+	req, err := parseTokenRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-	if req.GrantType == "client_credentials" {
-		res, err := h.handleClientCredentials(req)
-		if err != nil {
-			logger.Warning.Println(err)
-			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+	// Limits are checked before the grant, so rejected guesses cost no password hashing
+	limits := h.limits
+	var ip string
+	if limits != nil {
+		ip = limits.clientIP(r)
+		if wait := limits.allow(ip, req); wait > 0 {
+			writeError(w, errTooManyRequests(wait, "Too many requests from: %s", ip))
 			return
 		}
-		json.NewEncoder(w).Encode(res)
+	}
+	// The authorization data is loaded once, so a reload can not change it halfway through the request
+	res, err := h.handleGrant(h.authorization.Load(), req)
+	if limits != nil {
+		limits.record(ip, req, err)
+	}
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	logger.Warning.Printf("GrantType: %s not supported", req.GrantType)
-	http.Error(w, `{"error": "Unsupported Grant Type"}`, http.StatusUnauthorized)
-	return
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// handleGrant - Dispatch request to the grant type handler, with the authorization data of the request
+func (h *tokenHandler) handleGrant(authorization *models.Authorization, req *models.TokenRequest) (*models.TokenResponse, error) {
+	if req.GrantType == "" {
+		return nil, errInvalidRequest("grant_type is required")
+	}
+	handle, ok := grantHandlers[req.GrantType]
+	if !ok {
+		return nil, errUnsupportedGrantType("grant_type: %s not supported", req.GrantType)
+	}
+	if req.DPoPProof != "" {
+		jkt, err := h.verifyDPoPProof(authorization, req)
+		if err != nil {
+			return nil, err
+		}
+		req.DPoPJkt = jkt
+	}
+	return handle(h, authorization, req)
+}
+
+// grantHandlers - Supported grant types
+var grantHandlers = map[string]func(h *tokenHandler, authorization *models.Authorization, req *models.TokenRequest) (*models.TokenResponse, error){
+	"client_credentials":   (*tokenHandler).handleClientCredentials,
+	"refresh_token":        (*tokenHandler).handleRefreshToken,
+	"password":             (*tokenHandler).handlePassword,
+	"authorization_code":   (*tokenHandler).handleAuthorizationCode,
+	GrantTypeJWTBearer:     (*tokenHandler).handleJWTBearer,
+	grantTypeTokenExchange: (*tokenHandler).handleTokenExchange,
+}
+
+// supportedGrantTypes - Grant types accepted by the token endpoint, sorted
+func supportedGrantTypes() []string {
+	res := make([]string, 0, len(grantHandlers))
+	for gt := range grantHandlers {
+		res = append(res, gt)
+	}
+	sort.Strings(res)
+	return res
 }
 
+// accessTokenLifetime - Validity of issued access tokens, if the client has no token_lifetime_seconds
+const accessTokenLifetime = time.Hour
+
 type myClaimsStructure struct {
 	*jwt.StandardClaims
-	Admin string `json:"admin"`
-	Scope string `json:"scope"`
-}
-
-func (h *tokenHandler) handleClientCredentials(req *models.TokenRequest) (*models.TokenResponse, error) {
-	for _, client := range h.authorization.GetClients() {
-		if client.GetClientId() == req.ClientID {
-			if err := passwd.ComparePasswords(req.ClientSecret, client.GetClientSecret()); err != nil {
-				return nil, err
-			}
-			j, err := h.generateJWT(req.Audience, client.GetScope(), client.GetIsAdmin())
-			if err != nil {
-				return nil, err
-			}
-			res := getResponse(j)
-			return res, nil
+	Admin    string   `json:"admin"`
+	Scope    string   `json:"scope"`
+	ClientID string   `json:"client_id,omitempty"`
+	Azp      string   `json:"azp,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	// Cnf - Client certificate or DPoP key the token is bound to
+	Cnf *models.Confirmation `json:"cnf,omitempty"`
+	// Act - Actor of a delegated token
+	Act *models.Actor `json:"act,omitempty"`
+	// custom - Client custom claims, merged in by MarshalJSON
+	custom map[string]interface{}
+}
+
+// tokenGrant - An authorized grant, used as input when issuing tokens
+type tokenGrant struct {
+	// authorization - Authorization data the grant was checked against. Gives the token issuer
+	authorization *models.Authorization
+	client        *models.Client
+	// user - Resource owner the token is issued for. Nil for client tokens
+	user     *models.User
+	audience string
+	scope    []string
+	// refreshScope - Scope of the issued refresh token, if it differs from scope.
+	// A down-scoped refresh keeps the scope of the refresh token (RFC 6749 6)
+	refreshScope []string
+	// cnf - Key the access token is bound to. Nil for bearer tokens
+	cnf *models.Confirmation
+	// subjectID - Client subject of an exchanged token. The client itself if empty
+	subjectID string
+	// act - Current and prior actors of a delegated token
+	act *models.Actor
+	// notAfter - Latest expiry of the access token. No limit if zero
+	notAfter time.Time
+	// noRefresh - Do not issue a refresh token, whatever the client allows
+	noRefresh bool
+}
+
+// subject - The user for user tokens, the exchanged subject, or the client itself
+func (g *tokenGrant) subject() string {
+	if g.user != nil {
+		return g.user.GetUsername()
+	}
+	if g.subjectID != "" {
+		return g.subjectID
+	}
+	return g.client.GetClientId()
+}
+
+// lifetime - Access token lifetime of the client, shortened to end at notAfter
+func (g *tokenGrant) lifetime() time.Duration {
+	l := tokenLifetime(g.client)
+	if !g.notAfter.IsZero() {
+		if remaining := time.Until(g.notAfter).Truncate(time.Second); remaining < l {
+			l = remaining
 		}
 	}
-	return nil, errors.New("No ClientID - ClientSecret found")
+	return l
+}
+
+// refreshTokenScope - Scope of the refresh token issued with the grant
+func (g *tokenGrant) refreshTokenScope() []string {
+	if g.refreshScope != nil {
+		return g.refreshScope
+	}
+	return g.scope
+}
+
+// refreshTokenJkt - Refresh tokens of public clients are bound to the DPoP key (RFC 9449 5).
+// Confidential clients authenticate on refresh, and get unbound refresh tokens
+func (g *tokenGrant) refreshTokenJkt() string {
+	if !g.client.GetPublic() || g.cnf == nil {
+		return ""
+	}
+	return g.cnf.Jkt
+}
+
+func (h *tokenHandler) handleClientCredentials(authorization *models.Authorization, req *models.TokenRequest) (*models.TokenResponse, error) {
+	client, err := h.authenticate(authorization, req)
+	if err != nil {
+		return nil, err
+	}
+	if !allowsGrant(client, "client_credentials") {
+		return nil, errUnauthorizedClient("Client: %s is not allowed to use grant_type: client_credentials", client.GetClientId())
+	}
+	audience, err := resolveAudience(client, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := resolveScope(clientScopes(client), req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return h.issueTokens(&tokenGrant{authorization: authorization, client: client, audience: audience, scope: scope,
+		cnf: h.tokenConfirmation(client, req)}, "")
+}
+
+// resolveAudience - The requested audience, or the client default if none is requested.
+// Clients with allowed_audiences can only get tokens for those
+func resolveAudience(client *models.Client, audience string) (string, error) {
+	if audience == "" {
+		return client.GetDefaultAudience(), nil
+	}
+	if !allowsAudience(client, audience) {
+		return "", errInvalidTarget("Client: %s is not allowed to request tokens for audience: %s", client.GetClientId(), audience)
+	}
+	return audience, nil
 }
 
-func (h *tokenHandler) generateJWT(audience, scope string, admin bool) (string, error) {
+// allowsAudience - Empty audiences and clients without allowed_audiences are always allowed
+func allowsAudience(client *models.Client, audience string) bool {
+	return audience == "" || len(client.GetAllowedAudiences()) == 0 || containsString(client.GetAllowedAudiences(), audience)
+}
+
+// tokenLifetime - Access token lifetime of the client
+func tokenLifetime(client *models.Client) time.Duration {
+	if client.GetTokenLifetimeSeconds() > 0 {
+		return time.Duration(client.GetTokenLifetimeSeconds()) * time.Second
+	}
+	return accessTokenLifetime
+}
+
+// maxTokenLifetime - Longest access token lifetime of any client
+func maxTokenLifetime(authorization *models.Authorization) time.Duration {
+	max := accessTokenLifetime
+	for _, client := range authorization.GetClients() {
+		if l := tokenLifetime(client); l > max {
+			max = l
+		}
+	}
+	return max
+}
+
+// authenticate - Authenticate the client of a token request, and charge its rate limit
+func (h *tokenHandler) authenticate(authorization *models.Authorization, req *models.TokenRequest) (*models.Client, error) {
+	client, err := h.authenticateClient(authorization, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.limitClient(client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// clientAuthenticator - Client authentication settings, embedded in the handlers that authenticate clients
+type clientAuthenticator struct {
+	mtlsConfig
+	// pool - Runs secret verifications and rehashes. Nil runs them on the request goroutine
+	pool *passwd.Pool
+	// replays - IDs of used single use tokens, like client assertions and DPoP proofs
+	replays store.ReplayCache
+	// secretUpdater - Rehash is disabled if nil
+	secretUpdater SecretUpdater
+	// rehashing - Clients with a rehash running, so a burst of authentications starts only one
+	rehashing sync.Map
+}
+
+// SetVerifyPool - Verify client secrets on a bounded pool, so a burst of requests can not take every CPU
+func (a *clientAuthenticator) SetVerifyPool(pool *passwd.Pool) {
+	a.pool = pool
+}
+
+// SetReplayCache - Initialize with storage of used client assertion and DPoP proof IDs. Assertions
+// and proofs are rejected until it is set
+func (a *clientAuthenticator) SetReplayCache(replays store.ReplayCache) {
+	a.replays = replays
+}
+
+// useID - Mark the ID of a single use token as used. Fails without a replay cache, so no token is accepted twice
+func (a *clientAuthenticator) useID(id string, expiresAt time.Time) error {
+	if a.replays == nil {
+		return errors.New("No replay cache configured")
+	}
+	return a.replays.Use(id, expiresAt)
+}
+
+// authenticateClient - Find and authenticate the client. Clients with certificate-bound or
+// DPoP-bound tokens must present the certificate or a DPoP proof, whatever the authentication method
+func (a *clientAuthenticator) authenticateClient(authorization *models.Authorization, req *models.TokenRequest) (*models.Client, error) {
+	client, err := a.authenticateClientCredentials(authorization, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkTokenBinding(client, req); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// authenticateClientCredentials - Authenticate with a client assertion, a client certificate,
+// or a client secret. Public clients only give their client_id
+func (a *clientAuthenticator) authenticateClientCredentials(authorization *models.Authorization, req *models.TokenRequest) (*models.Client, error) {
+	if req.ClientAssertion != "" || req.ClientAssertionType != "" {
+		return a.authenticateClientAssertion(authorization, req)
+	}
+	client := findClient(authorization, req.ClientID)
+	if client == nil {
+		return nil, errInvalidClient("Client authentication failed for client_id: %s", req.ClientID)
+	}
+	if hasClientCertificateAuth(client) {
+		return a.authenticateClientCertificate(client, req)
+	}
+	if client.GetPublic() {
+		if req.ClientSecret != "" {
+			return nil, errInvalidClient("Public client: %s can not authenticate with a secret", req.ClientID)
+		}
+		return client, nil
+	}
+	if err := verifyPassword(a.pool, req.ClientSecret, client.GetClientSecret()); err != nil {
+		if err == errMismatch {
+			return nil, errInvalidClient("Client authentication failed for client_id: %s", req.ClientID)
+		}
+		return nil, err
+	}
+	a.rehashClientSecret(client, req.ClientSecret)
+	return client, nil
+}
+
+// findClient - Look up client by ID. Returns nil if not found
+func findClient(authorization *models.Authorization, clientID string) *models.Client {
+	for _, client := range authorization.GetClients() {
+		if client.GetClientId() == clientID {
+			return client
+		}
+	}
+	return nil
+}
+
+// allowsGrant - Check if the client may use the grant type. Clients without configured
+// grant_types may only use client_credentials, and refresh_token is controlled by allow_refresh_token.
+// Public clients can never use client_credentials
+func allowsGrant(client *models.Client, grantType string) bool {
+	if grantType == "refresh_token" {
+		return client.GetAllowRefreshToken()
+	}
+	if grantType == "client_credentials" && client.GetPublic() {
+		return false
+	}
+	if len(client.GetGrantTypes()) == 0 {
+		return grantType == "client_credentials"
+	}
+	for _, gt := range client.GetGrantTypes() {
+		if gt == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// issueTokens - Generate an access token, and a refresh token if the client allows it.
+// familyID is the refresh token rotation family, empty to start a new one
+func (h *tokenHandler) issueTokens(g *tokenGrant, familyID string) (*models.TokenResponse, error) {
+	j, err := h.generateJWT(g)
+	if err != nil {
+		return nil, errServerError("Token could not be generated: %s", err)
+	}
+	res := getResponse(j, g.lifetime())
+	res.Scope = strings.Join(g.scope, " ")
+	if g.cnf != nil && g.cnf.Jkt != "" {
+		res.TokenType = tokenTypeDPoP
+	}
+	if g.client.GetAllowRefreshToken() && h.refreshStore != nil && !g.noRefresh {
+		rt, err := h.generateRefreshToken(g, familyID)
+		if err != nil {
+			return nil, errServerError("Refresh token could not be generated: %s", err)
+		}
+		res.RefreshToken = rt
+	}
+	return res, nil
+}
+
+func (h *tokenHandler) generateJWT(g *tokenGrant) (string, error) {
+	// Admin is a client privilege, and is not given to tokens for other subjects.
+	// User privileges are given by roles
+	admin := g.client.GetIsAdmin() && g.user == nil && g.subjectID == ""
+	// Unique token ID, used for revocation
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
 	// Create the Claims
 	claims := myClaimsStructure{
-		&jwt.StandardClaims{
-			Issuer:    h.authorization.GetIssuer(),
-			IssuedAt:  time.Now().Unix(),
-			ExpiresAt: time.Now().Add(time.Second * 3600).Unix(),
-			Audience:  audience,
+		StandardClaims: &jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    g.authorization.GetIssuer(),
+			Subject:   g.subject(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(g.lifetime()).Unix(),
+			Audience:  g.audience,
 		},
-		fmt.Sprintf("%t", admin),
-		scope,
+		Admin:    fmt.Sprintf("%t", admin),
+		Scope:    strings.Join(g.scope, " "),
+		ClientID: g.client.GetClientId(),
+		Azp:      g.client.GetClientId(),
+		Roles:    g.user.GetRoles(),
+		Cnf:      g.cnf,
+		Act:      g.act,
+		custom:   customClaims(g.client.GetClientId(), g.client.GetCustomClaims()),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	tp, err := rsaa.GetSha1Thumbprint(&h.privateKey.PublicKey)
-	token.Header["kid"] = tp
+	key := h.keys.Signing()
+	method, err := signing.Method(key.Alg)
 	if err != nil {
-		logger.Error.Printf("Thumbprint error: %s", err.Error())
 		return "", err
 	}
-	tokenString, err := token.SignedString(h.privateKey)
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.PrivateKey)
 	if err != nil {
 		logger.Error.Printf("Sign token error: %s", err.Error())
 		return "", err
@@ -114,10 +481,10 @@ func (h *tokenHandler) generateJWT(audience, scope string, admin bool) (string,
 	return tokenString, nil
 }
 
-func getResponse(token string) *models.TokenResponse {
+func getResponse(token string, lifetime time.Duration) *models.TokenResponse {
 	return &models.TokenResponse{
 		TokenType:   "bearer",
 		AccessToken: token,
-		ExpiresIn:   3600,
+		ExpiresIn:   int(lifetime.Seconds()),
 	}
 }
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// Token exchange grant and token types (RFC 8693 2.1 and 3)
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// defaultMaxDelegationDepth - Longest delegation chain, if the client policy has none
+const defaultMaxDelegationDepth = 5
+
+// handleTokenExchange - Exchange an access token issued by this server for a token for another
+// audience or with less scope. With an actor_token the actor is recorded in the act claim. Without
+// one the client is the actor, unless its policy allows impersonation
+func (h *tokenHandler) handleTokenExchange(authorization *models.Authorization, req *models.TokenRequest) (*models.TokenResponse, error) {
+	client, err := h.authenticate(authorization, req)
+	if err != nil {
+		return nil, err
+	}
+	policy := client.GetTokenExchange()
+	// Clients without a policy can not exchange tokens, even with the grant type
+	if client.GetPublic() || !allowsGrant(client, grantTypeTokenExchange) || policy == nil {
+		return nil, errUnauthorizedClient("Client: %s is not allowed to use grant_type: %s", client.GetClientId(), grantTypeTokenExchange)
+	}
+	if req.SubjectToken == "" {
+		return nil, errInvalidRequest("subject_token is required")
+	}
+	if !exchangeTokenType(req.SubjectTokenType) {
+		return nil, errInvalidRequest("subject_token_type: %s not supported", req.SubjectTokenType)
+	}
+	if req.RequestedTokenType != "" && !exchangeTokenType(req.RequestedTokenType) {
+		return nil, errInvalidRequest("requested_token_type: %s not supported", req.RequestedTokenType)
+	}
+
+	subject, err := h.parseExchangeToken(authorization, req.SubjectToken, req)
+	if err != nil {
+		return nil, errInvalidRequest("subject_token is not valid: %s", err)
+	}
+	subjectClient := stringClaim(subject, "client_id")
+	if len(policy.GetSubjectClients()) > 0 && !containsString(policy.GetSubjectClients(), subjectClient) {
+		return nil, errUnauthorizedClient("Client: %s is not allowed to exchange tokens issued to client: %s", client.GetClientId(), subjectClient)
+	}
+	// The subject token must be meant for the client, or for a resource the client may exchange tokens for.
+	// Otherwise any client could turn a token sent elsewhere into a token of its own
+	accepted := append(append([]string{client.GetClientId()}, policy.GetAudiences()...), client.GetAllowedAudiences()...)
+	if !audienceValid(subject["aud"], accepted) {
+		return nil, errInvalidRequest("subject_token is not valid: audience does not include client: %s", client.GetClientId())
+	}
+
+	var act *models.Actor
+	switch {
+	case req.ActorToken != "":
+		if !exchangeTokenType(req.ActorTokenType) {
+			return nil, errInvalidRequest("actor_token_type: %s not supported", req.ActorTokenType)
+		}
+		actor, err := h.parseExchangeToken(authorization, req.ActorToken, req)
+		if err != nil {
+			return nil, errInvalidRequest("actor_token is not valid: %s", err)
+		}
+		act = &models.Actor{Sub: stringClaim(actor, "sub")}
+	case req.ActorTokenType != "":
+		return nil, errInvalidRequest("actor_token_type given without actor_token")
+	case !policy.GetAllowImpersonation():
+		act = &models.Actor{Sub: client.GetClientId()}
+	}
+	// Prior actors of the subject token are kept, also when impersonating
+	if prior := parseActorClaim(subject["act"]); act != nil {
+		act.Act = prior
+	} else {
+		act = prior
+	}
+	maxDepth := int(policy.GetMaxDelegationDepth())
+	if maxDepth == 0 {
+		maxDepth = defaultMaxDelegationDepth
+	}
+	if actorDepth(act) > maxDepth {
+		return nil, errInvalidRequest("Delegation chain is longer than %d actors", maxDepth)
+	}
+
+	if req.Audience != "" && len(policy.GetAudiences()) > 0 && !containsString(policy.GetAudiences(), req.Audience) {
+		return nil, errInvalidTarget("Client: %s is not allowed to exchange tokens for audience: %s", client.GetClientId(), req.Audience)
+	}
+	audience, err := resolveAudience(client, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	// Only scopes of the subject token that the client also has can be issued
+	scope, err := resolveScope(intersectScopes(strings.Fields(stringClaim(subject, "scope")), clientScopes(client)), req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &tokenGrant{authorization: authorization, client: client, audience: audience, scope: scope, act: act, noRefresh: true,
+		notAfter: time.Unix(int64Claim(subject, "exp"), 0), cnf: h.tokenConfirmation(client, req)}
+	// Client subjects are the client the token was issued to, or the subject of an exchanged client token
+	sub := stringClaim(subject, "sub")
+	if sub == subjectClient || (findUser(authorization, sub) == nil && findClient(authorization, sub) != nil) {
+		g.subjectID = sub
+	} else {
+		// User token. The user must still exist and be enabled
+		g.user = findUser(authorization, sub)
+		if g.user == nil || g.user.GetDisabled() {
+			return nil, errInvalidRequest("subject_token is not valid: user: %s not found", sub)
+		}
+	}
+	res, err := h.issueTokens(g, "")
+	if err != nil {
+		return nil, err
+	}
+	res.IssuedTokenType = tokenTypeAccessToken
+	logger.Info.Printf("Token for subject: %s exchanged by client: %s for audience: %s", g.subject(), client.GetClientId(), audience)
+	return res, nil
+}
+
+// exchangeTokenType - Token types that can be exchanged. Both are access tokens issued by this server
+func exchangeTokenType(tokenType string) bool {
+	return tokenType == tokenTypeAccessToken || tokenType == tokenTypeJWT
+}
+
+// parseExchangeToken - Validate an access token issued by this server. Certificate-bound tokens
+// can only be exchanged over a connection with the same client certificate, and DPoP-bound
+// tokens with a proof for the same key
+func (h *tokenHandler) parseExchangeToken(authorization *models.Authorization, token string, req *models.TokenRequest) (jwt.MapClaims, error) {
+	claims, err := parseAccessToken(h.keys, authorization.GetIssuer(), h.revocations, token)
+	if err != nil {
+		return nil, err
+	}
+	cnf := confirmationClaim(claims)
+	if cnf == nil {
+		return claims, nil
+	}
+	if cnf.X5tS256 != "" && (len(req.ClientCertificates) == 0 || certificateThumbprint(req.ClientCertificates[0]) != cnf.X5tS256) {
+		return nil, errors.New("Token is bound to another client certificate")
+	}
+	if cnf.Jkt != "" && cnf.Jkt != req.DPoPJkt {
+		return nil, errors.New("Token is bound to another DPoP key")
+	}
+	return claims, nil
+}
+
+// parseActorClaim - The act claim of a token. Nil if the token has none
+func parseActorClaim(claim interface{}) *models.Actor {
+	m, ok := claim.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return &models.Actor{Sub: stringClaim(m, "sub"), Act: parseActorClaim(m["act"])}
+}
+
+// actorDepth - Number of actors in the chain
+func actorDepth(a *models.Actor) int {
+	n := 0
+	for ; a != nil; a = a.Act {
+		n++
+	}
+	return n
+}
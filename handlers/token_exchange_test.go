@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+// actorChain - Actors of the act claim, current actor first, separated by >
+func actorChain(a *models.Actor) string {
+	subs := []string{}
+	for ; a != nil; a = a.Act {
+		subs = append(subs, a.Sub)
+	}
+	return strings.Join(subs, ">")
+}
+
+func TestTokenExchange(t *testing.T) {
+	a := &models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+		testClient(t, `{"client_id": "frontend", "client_secret": "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G",
+			"is_admin": true, "grant_types": ["password", "client_credentials"], "scopes": ["read", "write"]}`),
+		testClient(t, `{"client_id": "orders", "client_secret": "$2a$10$a/JANxkdgbJtc0i36ZEk.eVxoUaMdvMhr/k4fpjL5kTbAeZJFpeIm",
+			"is_admin": true, "allow_refresh_token": true, "grant_types": ["urn:ietf:params:oauth:grant-type:token-exchange"],
+			"scopes": ["read"], "allowed_audiences": ["inventory", "billing", "gateway"],
+			"token_exchange": {"subject_clients": ["frontend", "orders", "gateway"], "audiences": ["inventory", "gateway"], "max_delegation_depth": 2}}`),
+		testClient(t, `{"client_id": "gateway", "client_secret": "$2a$10$0sxSR6FKk8msHgPSBN0Au.sGW3HQxRughWXsAZMq8GAVDcrTfFeLm",
+			"grant_types": ["urn:ietf:params:oauth:grant-type:token-exchange", "client_credentials"], "scopes": ["read", "write"],
+			"token_exchange": {"allow_impersonation": true}}`),
+		testClient(t, `{"client_id": "nogrant", "client_secret": "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G", "scopes": ["read"]}`),
+		testClient(t, `{"client_id": "nopolicy", "client_secret": "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G",
+			"grant_types": ["urn:ietf:params:oauth:grant-type:token-exchange"], "scopes": ["read"]}`),
+	}, Users: []*models.User{
+		&models.User{Username: "alice", Roles: []string{"reader"}},
+		&models.User{Username: "carol", Disabled: true},
+	}}
+	revocations := store.NewMemoryRevocationStore()
+	h := tokenHandler{}
+	h.SetKeyRing(testKeyRing())
+	h.SetAuthorization(a)
+	h.SetRefreshTokenStore(store.NewMemoryRefreshTokenStore())
+	h.SetRevocationStore(revocations)
+
+	token := func(g *tokenGrant) string {
+		g.authorization = a
+		s, err := h.generateJWT(g)
+		if err != nil {
+			t.Fatalf("Got uinexpected error: %v", err)
+		}
+		return s
+	}
+	exchange := func(clientID, secret, subjectToken, audience string) string {
+		res, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: grantTypeTokenExchange, ClientID: clientID, ClientSecret: secret,
+			SubjectToken: subjectToken, SubjectTokenType: tokenTypeAccessToken, Audience: audience})
+		if err != nil {
+			t.Fatalf("Got uinexpected error: %v", err)
+		}
+		return res.AccessToken
+	}
+	// Subject tokens sent to orders or gateway, unless named for another audience
+	alice := token(&tokenGrant{client: a.Clients[0], user: a.Users[0], audience: "orders", scope: []string{"read", "write"}})
+	aliceGateway := token(&tokenGrant{client: a.Clients[0], user: a.Users[0], audience: "gateway", scope: []string{"read", "write"}})
+	aliceBilling := token(&tokenGrant{client: a.Clients[0], user: a.Users[0], audience: "billing", scope: []string{"read"}})
+	aliceFrontend := token(&tokenGrant{client: a.Clients[0], user: a.Users[0], audience: "frontend", scope: []string{"read"}})
+	aliceNoAudience := token(&tokenGrant{client: a.Clients[0], user: a.Users[0], scope: []string{"read"}})
+	carol := token(&tokenGrant{client: a.Clients[0], user: a.Users[1], audience: "orders", scope: []string{"read"}})
+	frontend := token(&tokenGrant{client: a.Clients[0], audience: "gateway", scope: []string{"read", "write"}})
+	gateway := token(&tokenGrant{client: a.Clients[2], audience: "gateway", scope: []string{"read"}})
+	other := token(&tokenGrant{client: a.Clients[3], audience: "orders", scope: []string{"read"}})
+	shortLived := token(&tokenGrant{client: a.Clients[0], user: a.Users[0], audience: "orders", scope: []string{"read"}, notAfter: time.Now().Add(time.Minute)})
+	revoked := token(&tokenGrant{client: a.Clients[0], user: a.Users[0], audience: "orders", scope: []string{"read"}})
+	claims, err := parseAccessToken(h.keys, a.Issuer, nil, revoked)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	revocations.Revoke(stringClaim(claims, "jti"), time.Unix(int64Claim(claims, "exp"), 0))
+	delegated := exchange("orders", "secret2", alice, "gateway")
+	impersonated := exchange("gateway", "secret3", delegated, "orders")
+	twice := exchange("orders", "secret2", impersonated, "inventory")
+
+	var testResp = []struct {
+		name      string
+		clientID  string               // client, authenticated with its secret
+		req       *models.TokenRequest // request, grant type and client are added
+		sub       string               // expected subject
+		act       string               // expected actor chain
+		aud       string               // expected audience
+		scope     string               // expected scope
+		errorCode string               // expected error, empty if none
+	}{
+		{"delegation", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken},
+			"alice", "orders", "", "read", ""},
+		{"jwt token type", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeJWT, RequestedTokenType: tokenTypeAccessToken},
+			"alice", "orders", "", "read", ""},
+		{"actor token", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken,
+			ActorToken: gateway, ActorTokenType: tokenTypeAccessToken}, "alice", "gateway", "", "read", ""},
+		{"audience", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken, Audience: "inventory"},
+			"alice", "orders", "inventory", "read", ""},
+		{"impersonation", "gateway", &models.TokenRequest{SubjectToken: aliceGateway, SubjectTokenType: tokenTypeAccessToken},
+			"alice", "", "", "read write", ""},
+		{"impersonation down-scoped", "gateway", &models.TokenRequest{SubjectToken: aliceGateway, SubjectTokenType: tokenTypeAccessToken, Scope: "read"},
+			"alice", "", "", "read", ""},
+		{"client subject", "gateway", &models.TokenRequest{SubjectToken: frontend, SubjectTokenType: tokenTypeAccessToken},
+			"frontend", "", "", "read write", ""},
+		{"impersonation keeps prior actors", "gateway", &models.TokenRequest{SubjectToken: delegated, SubjectTokenType: tokenTypeAccessToken},
+			"alice", "orders", "", "read", ""},
+		{"nested actors", "orders", &models.TokenRequest{SubjectToken: impersonated, SubjectTokenType: tokenTypeAccessToken},
+			"alice", "orders>orders", "", "read", ""},
+		{"delegation depth", "orders", &models.TokenRequest{SubjectToken: twice, SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeInvalidRequest},
+		{"subject client not allowed", "orders", &models.TokenRequest{SubjectToken: other, SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeUnauthorizedClient},
+		{"audience not in policy", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken, Audience: "billing"},
+			"", "", "", "", errCodeInvalidTarget},
+		{"no audience policy", "gateway", &models.TokenRequest{SubjectToken: aliceGateway, SubjectTokenType: tokenTypeAccessToken, Audience: "reports"},
+			"alice", "", "reports", "read write", ""},
+		{"scope not in subject token", "gateway", &models.TokenRequest{SubjectToken: gateway, SubjectTokenType: tokenTypeAccessToken, Scope: "write"},
+			"", "", "", "", errCodeInvalidScope},
+		{"scope not granted to client", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken, Scope: "write"},
+			"", "", "", "", errCodeInvalidScope},
+		{"disabled user", "orders", &models.TokenRequest{SubjectToken: carol, SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeInvalidRequest},
+		{"revoked subject token", "orders", &models.TokenRequest{SubjectToken: revoked, SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeInvalidRequest},
+		{"invalid subject token", "orders", &models.TokenRequest{SubjectToken: "abc", SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeInvalidRequest},
+		{"invalid actor token", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken,
+			ActorToken: "abc", ActorTokenType: tokenTypeAccessToken}, "", "", "", "", errCodeInvalidRequest},
+		{"actor token type without token", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken,
+			ActorTokenType: tokenTypeAccessToken}, "", "", "", "", errCodeInvalidRequest},
+		{"no subject token", "orders", &models.TokenRequest{SubjectTokenType: tokenTypeAccessToken}, "", "", "", "", errCodeInvalidRequest},
+		{"no subject token type", "orders", &models.TokenRequest{SubjectToken: alice}, "", "", "", "", errCodeInvalidRequest},
+		{"unsupported subject token type", "orders", &models.TokenRequest{SubjectToken: alice,
+			SubjectTokenType: "urn:ietf:params:oauth:token-type:refresh_token"}, "", "", "", "", errCodeInvalidRequest},
+		{"unsupported requested token type", "orders", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken,
+			RequestedTokenType: "urn:ietf:params:oauth:token-type:id_token"}, "", "", "", "", errCodeInvalidRequest},
+		{"client without grant type", "nogrant", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeUnauthorizedClient},
+		{"client without policy", "nopolicy", &models.TokenRequest{SubjectToken: alice, SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeUnauthorizedClient},
+		{"subject token for allowed resource", "orders", &models.TokenRequest{SubjectToken: aliceBilling, SubjectTokenType: tokenTypeAccessToken},
+			"alice", "orders", "", "read", ""},
+		{"subject token for other client", "orders", &models.TokenRequest{SubjectToken: aliceFrontend, SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeInvalidRequest},
+		{"subject token without audience", "orders", &models.TokenRequest{SubjectToken: aliceNoAudience, SubjectTokenType: tokenTypeAccessToken},
+			"", "", "", "", errCodeInvalidRequest},
+	}
+	secrets := map[string]string{"orders": "secret2", "gateway": "secret3", "nogrant": "secret1", "nopolicy": "secret1"}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.GrantType = grantTypeTokenExchange
+			tc.req.ClientID = tc.clientID
+			tc.req.ClientSecret = secrets[tc.clientID]
+			res, err := h.handleGrant(h.authorization.Load(), tc.req)
+			if tc.errorCode != "" {
+				expectOAuthError(t, err, tc.errorCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			if res.IssuedTokenType != tokenTypeAccessToken {
+				t.Errorf("issued_token_type, Expected: %s, Got: %s", tokenTypeAccessToken, res.IssuedTokenType)
+			}
+			if res.RefreshToken != "" {
+				t.Error("Exchanged token should not have a refresh token")
+			}
+			if res.Scope != tc.scope {
+				t.Errorf("Scope, Expected: %q, Got: %q", tc.scope, res.Scope)
+			}
+			claims, err := parseAccessToken(h.keys, a.Issuer, revocations, res.AccessToken)
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			if sub := stringClaim(claims, "sub"); sub != tc.sub {
+				t.Errorf("sub, Expected: %s, Got: %s", tc.sub, sub)
+			}
+			if act := actorChain(parseActorClaim(claims["act"])); act != tc.act {
+				t.Errorf("act, Expected: %q, Got: %q", tc.act, act)
+			}
+			if aud := stringClaim(claims, "aud"); aud != tc.aud {
+				t.Errorf("aud, Expected: %q, Got: %q", tc.aud, aud)
+			}
+			if stringClaim(claims, "admin") != "false" {
+				t.Errorf("Exchanged token should not be admin, Got: %s", stringClaim(claims, "admin"))
+			}
+			if stringClaim(claims, "client_id") != tc.clientID {
+				t.Errorf("client_id, Expected: %s, Got: %s", tc.clientID, stringClaim(claims, "client_id"))
+			}
+		})
+	}
+
+	// The exchanged token never outlives the subject token
+	res, err := h.handleGrant(h.authorization.Load(), &models.TokenRequest{GrantType: grantTypeTokenExchange, ClientID: "orders", ClientSecret: "secret2",
+		SubjectToken: shortLived, SubjectTokenType: tokenTypeAccessToken})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if res.ExpiresIn > 60 {
+		t.Errorf("expires_in, Expected at most 60, Got: %d", res.ExpiresIn)
+	}
+	exchanged, err := parseAccessToken(h.keys, a.Issuer, nil, res.AccessToken)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if subject, _ := parseAccessToken(h.keys, a.Issuer, nil, shortLived); int64Claim(exchanged, "exp") > int64Claim(subject, "exp") {
+		t.Error("Exchanged token expires after the subject token")
+	}
+}
+
+func TestActorDepth(t *testing.T) {
+	var testResp = []struct {
+		act *models.Actor // actor chain
+		exp int           // expected depth
+	}{
+		{nil, 0},
+		{&models.Actor{Sub: "a"}, 1},
+		{&models.Actor{Sub: "a", Act: &models.Actor{Sub: "b", Act: &models.Actor{Sub: "c"}}}, 3},
+	}
+	for _, tc := range testResp {
+		if res := actorDepth(tc.act); res != tc.exp {
+			t.Errorf("actorDepth(%s), Expected: %d, Got: %d", actorChain(tc.act), tc.exp, res)
+		}
+	}
+}
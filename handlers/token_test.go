@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
-	rsaa "github.com/jafossum/go-auth-server/crypto/rsa"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/keyring"
+	"github.com/jafossum/go-auth-server/crypto/signing"
 	"github.com/jafossum/go-auth-server/models"
 	"github.com/jafossum/go-auth-server/utils/logger"
 )
@@ -33,9 +38,9 @@ func TestTokenHandle(t *testing.T) {
 		{&models.TokenRequest{GrantType: "", ClientID: "cl2", ClientSecret: "secret2", Audience: ""}, true},
 	}
 
-	key, _ := rsaa.ParseRsaKeys("../test-resources/private.pem", "", "../test-resources/public.pem")
+	keys := testKeyRing()
 	h := tokenHandler{}
-	h.SetCertificate(key)
+	h.SetKeyRing(keys)
 	h.SetAuthorization(auth)
 
 	for _, tc := range testResp {
@@ -68,10 +73,122 @@ func TestTokenHandle(t *testing.T) {
 	}
 }
 
+func TestTokenHandleContentTypes(t *testing.T) {
+	form := func(v url.Values) string { return v.Encode() }
+	var testResp = []struct {
+		name        string // test name
+		contentType string // Content-Type header
+		body        string // request body
+		user, pass  string // Basic auth credentials, not set if empty
+		code        int    // expected status code
+	}{
+		{"json", "application/json", `{"grant_type": "client_credentials", "client_id": "cl1", "client_secret": "secret1"}`, "", "", http.StatusOK},
+		{"json charset", "application/json; charset=utf-8", `{"grant_type": "client_credentials", "client_id": "cl1", "client_secret": "secret1"}`, "", "", http.StatusOK},
+		{"json no content-type", "", `{"grant_type": "client_credentials", "client_id": "cl1", "client_secret": "secret1"}`, "", "", http.StatusOK},
+		{"json malformed", "application/json", `{"grant_type": "client_credentials",`, "", "", http.StatusBadRequest},
+		{"form", "application/x-www-form-urlencoded", form(url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl1"}, "client_secret": {"secret1"}}), "", "", http.StatusOK},
+		{"form wrong secret", "application/x-www-form-urlencoded", form(url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl1"}, "client_secret": {"secret2"}}), "", "", http.StatusUnauthorized},
+		{"form basic", "application/x-www-form-urlencoded", form(url.Values{"grant_type": {"client_credentials"}}), "cl1", "secret1", http.StatusOK},
+		{"form basic encoded", "application/x-www-form-urlencoded", form(url.Values{"grant_type": {"client_credentials"}}), "cl1", "secret%31", http.StatusOK},
+		{"form basic matching client_id", "application/x-www-form-urlencoded", form(url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl1"}}), "cl1", "secret1", http.StatusOK},
+		{"form basic other client_id", "application/x-www-form-urlencoded", form(url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl2"}}), "cl1", "secret1", http.StatusBadRequest},
+		{"form basic and body secret", "application/x-www-form-urlencoded", form(url.Values{"grant_type": {"client_credentials"}, "client_secret": {"secret1"}}), "cl1", "secret1", http.StatusBadRequest},
+		{"form basic wrong secret", "application/x-www-form-urlencoded", form(url.Values{"grant_type": {"client_credentials"}}), "cl1", "secret2", http.StatusUnauthorized},
+		{"json basic", "application/json", `{"grant_type": "client_credentials"}`, "cl2", "secret2", http.StatusOK},
+		{"json basic and body secret", "application/json", `{"grant_type": "client_credentials", "client_secret": "secret2"}`, "cl2", "secret2", http.StatusBadRequest},
+		{"unsupported content-type", "text/plain", "grant_type=client_credentials", "cl1", "secret1", http.StatusBadRequest},
+	}
+
+	keys := testKeyRing()
+	h := tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(auth)
+
+	for _, tc := range testResp {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/oauth/token", strings.NewReader(tc.body))
+			if err != nil {
+				t.Errorf("Got uinexpected error: %v", err)
+			}
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.user != "" {
+				req.SetBasicAuth(tc.user, tc.pass)
+			}
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(h.Handle)
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.code {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.code)
+			}
+		})
+	}
+}
+
+func TestTokenHandleErrors(t *testing.T) {
+	var testResp = []struct {
+		body   string // request body
+		code   string // expected error code, empty on success
+		status int    // expected status code
+	}{
+		{`{"grant_type": "client_credentials", "client_id": "cl1", "client_secret": "secret1"}`, "", http.StatusOK},
+		{`{"client_id": "cl1", "client_secret": "secret1"}`, "invalid_request", http.StatusBadRequest},
+		{`{"grant_type": "implicit", "client_id": "cl1", "client_secret": "secret1"}`, "unsupported_grant_type", http.StatusBadRequest},
+		{`{"grant_type": "client_credentials", "client_id": "cl1", "client_secret": "secret2"}`, "invalid_client", http.StatusUnauthorized},
+		{`{"grant_type": "client_credentials", "client_id": "cl5", "client_secret": "secret1"}`, "invalid_client", http.StatusUnauthorized},
+		{`{"grant_type": `, "invalid_request", http.StatusBadRequest},
+	}
+
+	keys := testKeyRing()
+	h := tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(auth)
+
+	for _, tc := range testResp {
+		t.Run(tc.body, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/oauth/token", strings.NewReader(tc.body))
+			if err != nil {
+				t.Errorf("Got uinexpected error: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(h.Handle)
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.status)
+			}
+			if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+				t.Errorf("Expected Cache-Control: no-store, got: %v", cc)
+			}
+			if p := rr.Header().Get("Pragma"); p != "no-cache" {
+				t.Errorf("Expected Pragma: no-cache, got: %v", p)
+			}
+			if tc.code == "" {
+				return
+			}
+			var res = &models.ErrorResponse{}
+			if err := json.NewDecoder(rr.Body).Decode(res); err != nil {
+				t.Errorf("Got uinexpected error: %v", err)
+			}
+			if res.Error != tc.code {
+				t.Errorf("Expected error: %v, got: %v", tc.code, res.Error)
+			}
+			if res.ErrorDescription == "" {
+				t.Error("Expected error_description to be set")
+			}
+		})
+	}
+}
+
 func TestHandleClientCredentials(t *testing.T) {
-	key, _ := rsaa.ParseRsaKeys("../test-resources/private.pem", "", "../test-resources/public.pem")
+	keys := testKeyRing()
 	h := tokenHandler{}
-	h.SetCertificate(key)
+	h.SetKeyRing(keys)
 	h.SetAuthorization(auth)
 
 	var testResp = []struct {
@@ -89,7 +206,7 @@ func TestHandleClientCredentials(t *testing.T) {
 		t.Run(tc.req.ClientID+tc.req.ClientSecret, func(t *testing.T) {
 			tc := tc // rebind tc into this lexical scope
 			t.Parallel()
-			_, err := h.handleClientCredentials(tc.req)
+			_, err := h.handleClientCredentials(h.authorization.Load(), tc.req)
 			if err == nil && tc.err {
 				t.Error("Not getting expected error")
 			}
@@ -100,10 +217,56 @@ func TestHandleClientCredentials(t *testing.T) {
 	}
 }
 
+func TestHandleGrantSnapshot(t *testing.T) {
+	keys := testKeyRing()
+	h := tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(auth)
+	snapshot := h.authorization.Load()
+
+	// A reload during the request does not change the client or the issuer of the token
+	h.SetAuthorization(&models.Authorization{Issuer: "Reloaded-Issuer"})
+	res, err := h.handleGrant(snapshot, &models.TokenRequest{GrantType: "client_credentials", ClientID: "cl1", ClientSecret: "secret1"})
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	claims, err := parseAccessToken(keys, auth.GetIssuer(), nil, res.AccessToken)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if iss := stringClaim(claims, "iss"); iss != auth.GetIssuer() {
+		t.Errorf("iss, Expected: %s, Got: %s", auth.GetIssuer(), iss)
+	}
+}
+
+func TestAllowsGrant(t *testing.T) {
+	var testResp = []struct {
+		client    *models.Client // input
+		grantType string         // input
+		exp       bool           // expected result
+	}{
+		{&models.Client{}, "client_credentials", true},
+		{&models.Client{}, "password", false},
+		{&models.Client{}, "refresh_token", false},
+		{&models.Client{AllowRefreshToken: true}, "refresh_token", true},
+		{&models.Client{GrantTypes: []string{"password"}}, "password", true},
+		{&models.Client{GrantTypes: []string{"password"}}, "client_credentials", false},
+		{&models.Client{GrantTypes: []string{"password", "client_credentials"}}, "client_credentials", true},
+	}
+
+	for _, tc := range testResp {
+		t.Run(tc.grantType, func(t *testing.T) {
+			if res := allowsGrant(tc.client, tc.grantType); res != tc.exp {
+				t.Errorf("allowsGrant(%v, %s), Expected: %v, Got: %v", tc.client, tc.grantType, tc.exp, res)
+			}
+		})
+	}
+}
+
 func TestGenerateJWT(t *testing.T) {
-	key, _ := rsaa.ParseRsaKeys("../test-resources/private.pem", "", "../test-resources/public.pem")
+	keys := testKeyRing()
 	h := tokenHandler{}
-	h.SetCertificate(key)
+	h.SetKeyRing(keys)
 
 	var testResp = []struct {
 		a   string // audience
@@ -120,7 +283,7 @@ func TestGenerateJWT(t *testing.T) {
 		t.Run(tc.a+tc.s, func(t *testing.T) {
 			tc := tc // rebind tc into this lexical scope
 			t.Parallel()
-			res, err := h.generateJWT(tc.a, tc.s, tc.adm)
+			res, err := h.generateJWT(&tokenGrant{authorization: h.authorization.Load(), client: &models.Client{Scope: tc.s, IsAdmin: tc.adm}, audience: tc.a})
 			if err == nil && tc.err {
 				t.Error("Not getting expected error")
 			}
@@ -141,25 +304,27 @@ func TestGenerateJWTPanicWithNoKey(t *testing.T) {
 		}
 	}()
 	h := tokenHandler{}
-	h.generateJWT("Aud", "Scope", false)
+	h.generateJWT(&tokenGrant{authorization: h.authorization.Load(), client: &models.Client{Scope: "Scope"}, audience: "Aud"})
 	t.Error("Not getting expected panic")
 }
 
 func TestGetResponse(t *testing.T) {
 	var testResp = []struct {
-		token string                // input
-		exp   *models.TokenResponse // expected result
+		token    string                // input
+		lifetime time.Duration         // input
+		exp      *models.TokenResponse // expected result
 	}{
-		{"Token1", &models.TokenResponse{TokenType: "bearer", AccessToken: "Token1", ExpiresIn: 3600, RefreshToken: "", Scope: ""}},
-		{"t", &models.TokenResponse{TokenType: "bearer", AccessToken: "t", ExpiresIn: 3600, RefreshToken: "", Scope: ""}},
-		{"", &models.TokenResponse{TokenType: "bearer", AccessToken: "", ExpiresIn: 3600, RefreshToken: "", Scope: ""}},
+		{"Token1", accessTokenLifetime, &models.TokenResponse{TokenType: "bearer", AccessToken: "Token1", ExpiresIn: 3600, RefreshToken: "", Scope: ""}},
+		{"t", accessTokenLifetime, &models.TokenResponse{TokenType: "bearer", AccessToken: "t", ExpiresIn: 3600, RefreshToken: "", Scope: ""}},
+		{"", accessTokenLifetime, &models.TokenResponse{TokenType: "bearer", AccessToken: "", ExpiresIn: 3600, RefreshToken: "", Scope: ""}},
+		{"short", 5 * time.Minute, &models.TokenResponse{TokenType: "bearer", AccessToken: "short", ExpiresIn: 300, RefreshToken: "", Scope: ""}},
 	}
 
 	for _, tc := range testResp {
 		t.Run(tc.token, func(t *testing.T) {
 			tc := tc // rebind tc into this lexical scope
 			t.Parallel()
-			res := getResponse(tc.token)
+			res := getResponse(tc.token, tc.lifetime)
 			if res.TokenType != tc.exp.TokenType {
 				t.Errorf("getResponse(%s), Expected: %v, Got: %v", tc.token, tc.exp, res)
 			}
@@ -178,3 +343,132 @@ func TestGetResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveAudience(t *testing.T) {
+	restricted := &models.Client{ClientId: "cl1", AllowedAudiences: []string{"api1", "api2"}, DefaultAudience: "api1"}
+	var testResp = []struct {
+		client   *models.Client // input
+		audience string         // input
+		exp      string         // expected result
+		err      bool           // expect error
+	}{
+		{&models.Client{}, "", "", false},
+		{&models.Client{}, "any", "any", false},
+		{&models.Client{DefaultAudience: "def"}, "", "def", false},
+		{restricted, "", "api1", false},
+		{restricted, "api2", "api2", false},
+		{restricted, "other", "", true},
+	}
+
+	for _, tc := range testResp {
+		res, err := resolveAudience(tc.client, tc.audience)
+		if err == nil && tc.err {
+			t.Errorf("resolveAudience(%s), Not getting expected error", tc.audience)
+		}
+		if err != nil {
+			if !tc.err {
+				t.Errorf("resolveAudience(%s), Got uinexpected error: %v", tc.audience, err)
+			} else if oe := toOAuthError(err); oe.Code != "invalid_target" {
+				t.Errorf("resolveAudience(%s), Expected error: invalid_target, Got: %v", tc.audience, oe.Code)
+			}
+		}
+		if res != tc.exp {
+			t.Errorf("resolveAudience(%s), Expected: %v, Got: %v", tc.audience, tc.exp, res)
+		}
+	}
+}
+
+func TestMaxTokenLifetime(t *testing.T) {
+	var testResp = []struct {
+		clients []*models.Client // input
+		exp     time.Duration    // expected result
+	}{
+		{nil, time.Hour},
+		{[]*models.Client{{TokenLifetimeSeconds: 300}}, time.Hour},
+		{[]*models.Client{{TokenLifetimeSeconds: 300}, {TokenLifetimeSeconds: 7200}, {}}, 2 * time.Hour},
+	}
+
+	for _, tc := range testResp {
+		if res := maxTokenLifetime(&models.Authorization{Clients: tc.clients}); res != tc.exp {
+			t.Errorf("maxTokenLifetime(%v), Expected: %v, Got: %v", tc.clients, tc.exp, res)
+		}
+	}
+}
+
+func TestClientTokenLifetimeAndAudience(t *testing.T) {
+	keys := testKeyRing()
+	h := tokenHandler{}
+	h.SetKeyRing(keys)
+	h.SetAuthorization(&models.Authorization{Issuer: "Test-Issuer", Clients: []*models.Client{
+		&models.Client{ClientId: "cl1", ClientSecret: "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G",
+			TokenLifetimeSeconds: 300, AllowedAudiences: []string{"api1", "api2"}, DefaultAudience: "api1"}}})
+
+	var testResp = []struct {
+		audience string // requested audience
+		exp      string // expected aud claim
+		err      bool   // expect error
+	}{
+		{"", "api1", false},
+		{"api2", "api2", false},
+		{"other", "", true},
+	}
+
+	for _, tc := range testResp {
+		res, err := h.handleClientCredentials(h.authorization.Load(), &models.TokenRequest{ClientID: "cl1", ClientSecret: "secret1", Audience: tc.audience})
+		if err == nil && tc.err {
+			t.Errorf("handleClientCredentials(%s), Not getting expected error", tc.audience)
+		}
+		if err != nil {
+			if !tc.err {
+				t.Errorf("handleClientCredentials(%s), Got uinexpected error: %v", tc.audience, err)
+			}
+			continue
+		}
+		if res.ExpiresIn != 300 {
+			t.Errorf("handleClientCredentials(%s), Expected expires_in: 300, Got: %v", tc.audience, res.ExpiresIn)
+		}
+		claims := &myClaimsStructure{StandardClaims: &jwt.StandardClaims{}}
+		if _, err := jwt.ParseWithClaims(res.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+			return keys.Signing().PublicKey, nil
+		}); err != nil {
+			t.Fatalf("Got uinexpected error: %v", err)
+		}
+		if claims.Audience != tc.exp {
+			t.Errorf("handleClientCredentials(%s), Expected aud: %v, Got: %v", tc.audience, tc.exp, claims.Audience)
+		}
+		if claims.ExpiresAt-claims.IssuedAt != 300 {
+			t.Errorf("handleClientCredentials(%s), Expected lifetime: 300, Got: %v", tc.audience, claims.ExpiresAt-claims.IssuedAt)
+		}
+	}
+}
+
+func TestGenerateJWTAlgorithms(t *testing.T) {
+	for _, alg := range signing.Algorithms {
+		alg := alg // rebind
+		t.Run(alg, func(t *testing.T) {
+			key, err := signing.GenerateKey(alg)
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			keys, _ := keyring.New(key)
+			h := tokenHandler{}
+			h.SetKeyRing(keys)
+			h.SetAuthorization(&models.Authorization{Issuer: "Test-Issuer"})
+
+			res, err := h.generateJWT(&tokenGrant{authorization: h.authorization.Load(), client: &models.Client{ClientId: "cl1"}})
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			token, _, err := new(jwt.Parser).ParseUnverified(res, jwt.MapClaims{})
+			if err != nil {
+				t.Fatalf("Got uinexpected error: %v", err)
+			}
+			if token.Header["alg"] != alg || token.Header["kid"] != keys.Signing().ID {
+				t.Errorf("Expected alg: %s and kid: %s, Got: %v", alg, keys.Signing().ID, token.Header)
+			}
+			if _, err := parseAccessToken(keys, "Test-Issuer", nil, res); err != nil {
+				t.Errorf("parseAccessToken(), Got uinexpected error: %v", err)
+			}
+		})
+	}
+}
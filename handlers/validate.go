@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/keyring"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+// parseAccessToken - Validate signature, expiry and issuer of an access token issued by this server,
+// and check that it is not revoked. The token must be signed by a key in the key ring. revocations can be nil
+func parseAccessToken(keys *keyring.KeyRing, issuer string, revocations store.RevocationStore, tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := keys.Get(kid)
+		if key == nil {
+			return nil, fmt.Errorf("Unknown signing key: %s", kid)
+		}
+		// The algorithm is given by the key, never by the token
+		if token.Method.Alg() != key.Alg {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(issuer, true) {
+		return nil, errors.New("Token issuer does not match")
+	}
+	if revocations != nil {
+		jti, _ := claims["jti"].(string)
+		revoked, err := revocations.IsRevoked(jti)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, errors.New("Token is revoked")
+		}
+	}
+	return claims, nil
+}
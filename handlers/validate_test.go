@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/store"
+)
+
+// signTestToken - Sign claims with the given method and key. Keys are identified by their thumbprint
+func signTestToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	kid := ""
+	if k, ok := key.(crypto.Signer); ok {
+		kid, _ = signing.Thumbprint(k.Public())
+	}
+	return signTestTokenWithKid(t, method, key, kid, claims)
+}
+
+// signTestTokenWithKid - Sign claims with the given method and key, and any kid
+func signTestTokenWithKid(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	return s
+}
+
+func TestParseAccessTokenRevoked(t *testing.T) {
+	keys := testKeyRing()
+	key := keys.Signing().PrivateKey
+	exp := time.Now().Add(time.Hour)
+	revocations := store.NewMemoryRevocationStore()
+	revocations.Revoke("revoked", exp)
+
+	var testResp = []struct {
+		jti string // token ID
+		err bool   // expect error
+	}{
+		{"revoked", true},
+		{"active", false},
+	}
+	for _, tc := range testResp {
+		token := signTestToken(t, jwt.SigningMethodRS256, key, jwt.MapClaims{"iss": "Test-Issuer", "exp": exp.Unix(), "jti": tc.jti})
+		_, err := parseAccessToken(keys, "Test-Issuer", revocations, token)
+		if err == nil && tc.err {
+			t.Errorf("parseAccessToken(%s), Not getting expected error", tc.jti)
+		}
+		if err != nil && !tc.err {
+			t.Errorf("parseAccessToken(%s), Error not expected error; %v", tc.jti, err)
+		}
+	}
+}
+
+func TestParseAccessToken(t *testing.T) {
+	keys := testKeyRing()
+	key := keys.Signing().PrivateKey
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := signing.GenerateKey(signing.ES256)
+	now := time.Now()
+	valid := jwt.MapClaims{"iss": "Test-Issuer", "exp": now.Add(time.Hour).Unix(), "iat": now.Unix()}
+
+	var testResp = []struct {
+		name  string // test name
+		token string // input
+		err   bool   // expect error
+	}{
+		{"valid", signTestToken(t, jwt.SigningMethodRS256, key, valid), false},
+		{"expired", signTestToken(t, jwt.SigningMethodRS256, key, jwt.MapClaims{"iss": "Test-Issuer", "exp": now.Add(-time.Minute).Unix()}), true},
+		{"other issuer", signTestToken(t, jwt.SigningMethodRS256, key, jwt.MapClaims{"iss": "Other", "exp": now.Add(time.Hour).Unix()}), true},
+		{"no issuer", signTestToken(t, jwt.SigningMethodRS256, key, jwt.MapClaims{"exp": now.Add(time.Hour).Unix()}), true},
+		{"other key", signTestToken(t, jwt.SigningMethodRS256, other, valid), true},
+		{"other algorithm", signTestToken(t, jwt.SigningMethodRS512, key, valid), true},
+		{"other key type", signTestToken(t, jwt.SigningMethodES256, ecKey, valid), true},
+		{"other key type with known kid", signTestTokenWithKid(t, jwt.SigningMethodES256, ecKey, keys.Signing().ID, valid), true},
+		{"hmac with public key", signTestToken(t, jwt.SigningMethodHS256, []byte("secret"), valid), true},
+		{"garbage", "not.a.token", true},
+	}
+
+	for _, tc := range testResp {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseAccessToken(keys, "Test-Issuer", nil, tc.token)
+			if err == nil && tc.err {
+				t.Error("Not getting expected error")
+			}
+			if err != nil && !tc.err {
+				t.Errorf("Error not expected error; %v", err)
+			}
+		})
+	}
+}
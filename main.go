@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -37,18 +38,52 @@ func parseConfig() (c *models.ServiceConfig) {
 	c = &models.ServiceConfig{}
 	r := &models.RSAConfig{}
 	t := &models.TLSConfig{}
+	l := &models.RateLimitConfig{}
 	flag.String(flag.DefaultConfigFlagname, "", "path to config file")
+	flag.BoolVar(&c.Dev, "dev", false, "Development mode. Use a temporary signing key if no key is given, or the key can not be loaded")
 	flag.StringVar(&c.LogFile, "log_logfile", "./logs/out.log", "Directory to write logs")
 	flag.StringVar(&c.Port, "port", "9065", "Server port")
-	flag.StringVar(&r.Private, "rsa_private", "", "Path to RSA Private Key")
+	flag.StringVar(&r.Private, "rsa_private", "", "Path to Private Key. RSA, EC or Ed25519, see signing_alg")
 	flag.StringVar(&r.Public, "rsa_public", "", "Path to RSA Public Key")
-	flag.StringVar(&r.Pass, "rsa_pass", "", "RSA PrivateKey Password")
+	flag.StringVar(&r.Pass, "rsa_pass", "", "PrivateKey Password")
+	flag.StringVar(&r.PassFile, "rsa_pass_file", "", "File with the PrivateKey Password on the first line, or - to read it from stdin")
+	flag.StringVar(&c.SigningAlg, "signing_alg", "RS256", "Token signing algorithm: RS256, ES256, ES384 or EdDSA. The rsa_private key must match")
+	flag.StringVar(&c.KeyDir, "key_dir", "", "Directory to persist a generated signing key in, used if rsa_private is empty")
+	rsaVerify := flag.String("rsa_verify", "", "Comma separated paths to Public Keys only used for token validation")
 	flag.StringVar(&t.Key, "tls_key", "", "Path to TLS Key")
 	flag.StringVar(&t.Cert, "tls_cert", "", "Path to TLS Certificate")
+	flag.BoolVar(&t.ClientAuth, "tls_client_auth", false, "Request client certificates for mutual TLS client authentication")
+	flag.StringVar(&t.ClientCA, "tls_client_ca", "", "Path to CA certificates for tls_client_auth client certificates. Enables tls_client_auth")
 	flag.StringVar(&c.UserConf, "user_conf", "./config/auth_conf.json", "Path to User Configuration file. Protobuf formatted JSON.")
+	flag.DurationVar(&c.UserConfWatch, "user_conf_watch", 0, "Interval to check user_conf for changes and reload it, e.g. 10s. Disabled if 0, send SIGHUP to reload")
+	flag.StringVar(&c.PasswordHash, "password_hash", "bcrypt", "Password hash algorithm for new hashes: bcrypt, argon2id or scrypt")
+	flag.IntVar(&c.PasswordHashCost, "password_hash_cost", 0, "bcrypt cost, argon2id iterations or scrypt log2(N). Algorithm default if 0")
+	flag.IntVar(&c.VerifyWorkers, "passwd_workers", 0, "Password verifications running at the same time. All CPUs but one if 0")
+	flag.IntVar(&c.VerifyQueue, "passwd_queue", 64, "Password verifications waiting for a worker. Further requests get 503")
+	flag.DurationVar(&c.VerifyTimeout, "passwd_queue_timeout", 2*time.Second, "Longest wait for a password verification worker before 503")
+	flag.BoolVar(&c.Metrics, "metrics", false, "Serve password verification metrics on /metrics")
+	flag.BoolVar(&c.RehashSecrets, "rehash_secrets", false, "Upgrade client secret hashes to password_hash on successful authentication, and write them to user_conf")
+	flag.StringVar(&c.RefreshStore, "refresh_store", "", "Path to Refresh Token store file. Refresh tokens are kept in memory if empty")
+	flag.StringVar(&c.RevocationStore, "revocation_store", "", "Path to revoked token store file. Revoked tokens are kept in memory if empty")
+	flag.BoolVar(&c.DPoPNonce, "dpop_nonce", false, "Require DPoP proofs to have a nonce from the DPoP-Nonce response header")
+	flag.Float64Var(&l.IPRate, "rate_limit_ip", 0, "Token requests per second from one source IP. No limit if 0")
+	flag.IntVar(&l.IPBurst, "rate_limit_ip_burst", 20, "Token requests from one source IP allowed in a burst")
+	flag.Float64Var(&l.ClientRate, "rate_limit_client", 0, "Token requests per second for one authenticated client. No limit if 0")
+	flag.IntVar(&l.ClientBurst, "rate_limit_client_burst", 10, "Token requests for one client_id allowed in a burst")
+	flag.IntVar(&l.LockoutThreshold, "lockout_threshold", 0, "Failed authentications before the source IP, or a client_id or username from it, is locked out. No lockout if 0")
+	flag.DurationVar(&l.LockoutDuration, "lockout_duration", time.Minute, "First lockout, doubled for each further failure")
+	flag.DurationVar(&l.LockoutMax, "lockout_max", time.Hour, "Longest lockout. Failures are forgotten this long after the last one")
+	trustedProxies := flag.String("trusted_proxies", "", "Comma separated IPs and CIDRs of proxies whose X-Forwarded-For header gives the client IP")
 	flag.Parse()
+	if *rsaVerify != "" {
+		r.Verify = strings.Split(*rsaVerify, ",")
+	}
+	if *trustedProxies != "" {
+		l.TrustedProxies = strings.Split(*trustedProxies, ",")
+	}
 	c.RSAConf = r
 	c.TLSConf = t
+	c.RateLimitConf = l
 	return
 }
 
@@ -93,6 +128,18 @@ func blockOnSignal(s *service.Service) {
 	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	logger.Trace.Println("Listening for signals")
 
+	// SIGHUP reloads the authorization data
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+	go func() {
+		for range reloadCh {
+			logger.Info.Println("SIGHUP received, reloading authorization config")
+			if err := s.Reload(); err != nil {
+				logger.Error.Println(err)
+			}
+		}
+	}()
+
 	// Block until one of the signals above is received
 	<-signalCh
 	logger.Info.Println("Signal received, initializing clean shutdown...")
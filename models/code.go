@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// AuthorizationCode - Stored state of an issued authorization code
+type AuthorizationCode struct {
+	// ID - SHA-256 hash of the code value. The code value itself is never stored
+	ID       string
+	ClientID string
+	Username string
+	Audience string
+	// Scope - Space separated scopes granted
+	Scope string
+	// RedirectURI - redirect_uri given in the authorization request, empty if not given
+	RedirectURI         string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           time.Time
+}
+
+// Expired - True if the code is past its expiry time
+func (c *AuthorizationCode) Expired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
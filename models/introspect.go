@@ -0,0 +1,21 @@
+package models
+
+// IntrospectionResponse - Token introspection response (RFC 7662 2.2)
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	// Cnf - Certificate or DPoP key the token is bound to
+	Cnf *Confirmation `json:"cnf,omitempty"`
+	// Act - Actor of a delegated token
+	Act *Actor `json:"act,omitempty"`
+}
@@ -7,12 +7,17 @@ type Jwks struct {
 
 // JSONWebKeys - JSON Web Key format
 type JSONWebKeys struct {
-	Alg string   `json:"alg"`
-	Kty string   `json:"kty"`
-	Kid string   `json:"kid"`
-	Use string   `json:"use"`
-	N   string   `json:"n"`
-	E   string   `json:"e"`
+	Alg string `json:"alg"`
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// N, E - RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv, X, Y - EC and OKP (Ed25519) keys. Y is not used for OKP
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
 	X5c []string `json:"x5c"`
 	X5t string   `json:"x5t"`
 }
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// RefreshToken - Stored state of an issued refresh token
+type RefreshToken struct {
+	// ID - SHA-256 hash of the token value. The token value itself is never stored
+	ID string `json:"id"`
+	// FamilyID - ID of the first refresh token in the rotation chain
+	FamilyID string `json:"family_id"`
+	ClientID string `json:"client_id"`
+	// Subject - Username for user tokens, empty for client tokens
+	Subject  string `json:"subject,omitempty"`
+	Audience string `json:"audience"`
+	// Scope - Space separated scopes granted. Empty for tokens stored before scopes were kept
+	Scope     string    `json:"scope,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Used      bool      `json:"used"`
+	// Jkt - DPoP key thumbprint the token is bound to. Refresh tokens of public clients are bound
+	// to the DPoP key they were issued for
+	Jkt string `json:"jkt,omitempty"`
+}
+
+// Expired - True if the refresh token is past its expiry time
+func (t *RefreshToken) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
@@ -1,23 +1,81 @@
 package models
 
+import "time"
+
 // ServiceConfig : Config for service
 type ServiceConfig struct {
-	Port     string
-	LogFile  string
-	RSAConf  *RSAConfig
+	Port    string
+	LogFile string
+	RSAConf *RSAConfig
+	// SigningAlg - Token signing algorithm: RS256, ES256, ES384 or EdDSA
+	SigningAlg string
+	// KeyDir - Directory the signing key is generated in and loaded from, if no key file is given
+	KeyDir   string
 	TLSConf  *TLSConfig
 	UserConf string
+	// UserConfWatch - Interval to check UserConf for changes. Disabled if 0, reload with SIGHUP instead
+	UserConfWatch time.Duration
+	// PasswordHash - Algorithm of new password hashes: bcrypt, argon2id or scrypt
+	PasswordHash string
+	// PasswordHashCost - bcrypt cost, argon2id iterations or scrypt log2(N). Algorithm default if 0
+	PasswordHashCost int
+	// VerifyWorkers, VerifyQueue, VerifyTimeout - Password verifications running at the same time,
+	// waiting for a worker, and the longest wait. Defaults if 0
+	VerifyWorkers int
+	VerifyQueue   int
+	VerifyTimeout time.Duration
+	// Metrics - Serve password verification metrics on /metrics
+	Metrics bool
+	// RehashSecrets - Upgrade client secret hashes to PasswordHash when the client authenticates,
+	// and write them to UserConf
+	RehashSecrets bool
+	// Dev - Development mode. Key problems give a temporary signing key instead of a startup failure
+	Dev bool
+	// RefreshStore - Path to refresh token store file. In-memory store is used if empty
+	RefreshStore string
+	// RevocationStore - Path to revoked token store file. In-memory store is used if empty
+	RevocationStore string
+	// DPoPNonce - Require a server provided nonce in DPoP proofs
+	DPoPNonce bool
+	// RateLimitConf - Token endpoint rate limits and lockout. No limits if nil
+	RateLimitConf *RateLimitConfig
+}
+
+// RateLimitConfig - Token endpoint rate limiting and brute-force lockout. Limits are disabled if 0
+type RateLimitConfig struct {
+	// IPRate, IPBurst - Requests per second, and burst size, from one source IP
+	IPRate  float64
+	IPBurst int
+	// ClientRate, ClientBurst - Requests per second, and burst size, for one client_id
+	ClientRate  float64
+	ClientBurst int
+	// LockoutThreshold - Failed authentications before a source IP, client_id or username is locked out
+	LockoutThreshold int
+	// LockoutDuration - First lockout. Doubled for each further failure, up to LockoutMax.
+	// Failures are forgotten LockoutMax after the last one
+	LockoutDuration time.Duration
+	LockoutMax      time.Duration
+	// TrustedProxies - IPs and CIDRs of proxies whose X-Forwarded-For header is used for the source IP
+	TrustedProxies []string
 }
 
-// RSAConfig - RSA filespaths for signing config
+// RSAConfig - Signing key filepaths. Private can be an RSA, EC or Ed25519 key, Public is only used for RSA
 type RSAConfig struct {
 	Private string
 	Public  string
 	Pass    string
+	// PassFile - File to read Pass from, or "-" for stdin
+	PassFile string
+	// Verify - Public keys of previous signing keys. Published in the JWKS and accepted for validation
+	Verify []string
 }
 
 // TLSConfig -  TLS filepaths
 type TLSConfig struct {
 	Key  string
 	Cert string
+	// ClientAuth - Request client certificates for mutual TLS client authentication
+	ClientAuth bool
+	// ClientCA - CA certificates for tls_client_auth. Only self-signed certificates can be used if empty
+	ClientCA string
 }
@@ -1,11 +1,58 @@
 package models
 
+import "crypto/x509"
+
 // TokenRequest - Request for new token
 type TokenRequest struct {
 	GrantType    string `json:"grant_type"`
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
 	Audience     string `json:"audience"`
+	// Scope - Space separated scopes requested, all granted scopes if empty
+	Scope        string `json:"scope"`
+	RefreshToken string `json:"refresh_token"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RedirectURI  string `json:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier"`
+	// Token and TokenTypeHint - Token to introspect or revoke
+	Token         string `json:"token"`
+	TokenTypeHint string `json:"token_type_hint"`
+	// Assertion - JWT from a trusted issuer, for the jwt-bearer grant (RFC 7523 2.1)
+	Assertion string `json:"assertion"`
+	// SubjectToken, ActorToken and the token types - Token exchange (RFC 8693 2.1)
+	SubjectToken       string `json:"subject_token"`
+	SubjectTokenType   string `json:"subject_token_type"`
+	ActorToken         string `json:"actor_token"`
+	ActorTokenType     string `json:"actor_token_type"`
+	RequestedTokenType string `json:"requested_token_type"`
+	// ClientAssertion and ClientAssertionType - Signed JWT client authentication (RFC 7523)
+	ClientAssertion     string `json:"client_assertion"`
+	ClientAssertionType string `json:"client_assertion_type"`
+	// EndpointPath - Path of the endpoint the request was sent to
+	EndpointPath string `json:"-"`
+	// ClientCertificates - Certificate chain presented in the TLS handshake, the client certificate first
+	ClientCertificates []*x509.Certificate `json:"-"`
+	// DPoPProof - DPoP header of the request (RFC 9449 4)
+	DPoPProof string `json:"-"`
+	// DPoPJkt - Thumbprint of the key of a valid DPoP proof. Empty if the request has none
+	DPoPJkt string `json:"-"`
+}
+
+// Actor - Actor of a delegated token. Prior actors are nested, the current actor is
+// the outermost (RFC 8693 4.1)
+type Actor struct {
+	Sub string `json:"sub"`
+	Act *Actor `json:"act,omitempty"`
+}
+
+// Confirmation - Key the token is bound to (RFC 7800)
+type Confirmation struct {
+	// X5tS256 - Client certificate SHA-256 thumbprint (RFC 8705 3.1)
+	X5tS256 string `json:"x5t#S256,omitempty"`
+	// Jkt - DPoP key JWK SHA-256 thumbprint (RFC 9449 6.1)
+	Jkt string `json:"jkt,omitempty"`
 }
 
 // TokenResponse - Response for new token
@@ -15,4 +62,12 @@ type TokenResponse struct {
 	ExpiresIn    int    `json:"expires_in"`
 	RefreshToken string `json:"refresh_token"`
 	Scope        string `json:"scope"`
+	// IssuedTokenType - Type of the issued token, for token exchange responses
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// ErrorResponse - Error response from the token endpoint
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
 }
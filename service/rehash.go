@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// UpdateClientSecret - Store an upgraded client secret hash in the authorization config file, and
+// replace it in the handlers. Nothing is changed if the secret in the file is no longer oldHash
+func (s *Service) UpdateClientSecret(clientID, oldHash, newHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Read the file again, so changes not reloaded yet are kept
+	a, err := s.parseAuthorizationData()
+	if err != nil {
+		return err
+	}
+	if !setClientSecret(a, clientID, oldHash, newHash) {
+		return nil
+	}
+	if err := writeAuthorizationData(s.config.UserConf, a); err != nil {
+		return err
+	}
+
+	current := proto.Clone(s.authorization).(*models.Authorization)
+	if setClientSecret(current, clientID, oldHash, newHash) {
+		for _, h := range s.authTargets {
+			h.SetAuthorization(current)
+		}
+		s.authorization = current
+	}
+	logger.Info.Printf("Client: %s secret rehashed, and written to: %s", clientID, s.config.UserConf)
+	return nil
+}
+
+// setClientSecret - Replace the client secret if it is oldHash. Returns false if nothing was replaced
+func setClientSecret(a *models.Authorization, clientID, oldHash, newHash string) bool {
+	for _, c := range a.GetClients() {
+		if c.GetClientId() == clientID && c.GetClientSecret() == oldHash {
+			c.ClientSecret = newHash
+			return true
+		}
+	}
+	return false
+}
+
+// writeAuthorizationData - Replace the authorization config file. The file permissions are kept
+func writeAuthorizationData(path string, a *models.Authorization) error {
+	var buf bytes.Buffer
+	m := jsonpb.Marshaler{OrigName: true, Indent: "    "}
+	if err := m.Marshal(&buf, a); err != nil {
+		return fmt.Errorf("Authorization config could not be written: %s", err)
+	}
+	buf.WriteString("\n")
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Authorization config could not be written: %s", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Authorization config could not be written: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Authorization config could not be written: %s", err)
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Authorization config could not be written: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Authorization config could not be written: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Authorization config could not be written: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/handlers"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func TestUpdateClientSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rehash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth_conf.json")
+	js := `{"issuer": "Test-Issuer", "clients": [
+		{"client_id": "cl1", "client_secret": "old1", "scopes": ["read"], "custom_claims": {"tenant": "t1"}},
+		{"client_id": "cl2", "client_secret": "old2"}]}`
+	if err := ioutil.WriteFile(path, []byte(js), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(&models.ServiceConfig{UserConf: path})
+	current, err := s.parseAuthorizationData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setter := &testSetter{authorization: current}
+	s.authorization = current
+	s.authTargets = []authorizationSetter{setter}
+
+	var testResp = []struct {
+		name     string
+		clientID string
+		oldHash  string
+		exp      string // expected secret of the client after the update
+	}{
+		{"changed secret", "cl1", "other", "old1"},
+		{"unknown client", "cl3", "old1", "old1"},
+		{"rehash", "cl1", "old1", "new1"},
+	}
+	for _, tc := range testResp {
+		if err := s.UpdateClientSecret(tc.clientID, tc.oldHash, "new1"); err != nil {
+			t.Errorf("%s, Got uinexpected error: %v", tc.name, err)
+		}
+		written, err := s.parseAuthorizationData()
+		if err != nil {
+			t.Fatalf("%s, Got uinexpected error: %v", tc.name, err)
+		}
+		for _, a := range []*models.Authorization{written, setter.authorization, s.authorization} {
+			if res := a.GetClients()[0].GetClientSecret(); res != tc.exp {
+				t.Errorf("%s, Expected secret: %s, Got: %s", tc.name, tc.exp, res)
+			}
+		}
+	}
+
+	// The rest of the config is kept
+	written, _ := s.parseAuthorizationData()
+	if err := handlers.ValidateAuthorization(written); err != nil {
+		t.Errorf("Got uinexpected error: %v", err)
+	}
+	if changes := diffAuthorization(current, written); len(changes) != 1 || changes[0] != "client changed: cl1" {
+		t.Errorf("Expected only cl1 changed, Got: %v", changes)
+	}
+	if current.GetClients()[0].GetClientSecret() != "old1" {
+		t.Error("Expected the previous config to be unchanged")
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("Expected file mode 0640, Got: %v, %v", fi.Mode().Perm(), err)
+	}
+}
+
+func TestUpdateClientSecretValid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rehash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth_conf.json")
+	js := `{"issuer": "https://auth.example.com", "token_endpoint_url": "https://auth.example.com/oauth/token",
+		"clients": [
+			{"client_id": "cl1", "client_secret": "$2a$10$85r4AxaXGAzh7G1nCsm7MOYmDfyORw/IuXu33OLY6rvtLEKkVI03G",
+				"grant_types": ["client_credentials", "urn:ietf:params:oauth:grant-type:token-exchange"],
+				"scopes": ["read", "write"], "allowed_audiences": ["api1", "api2"], "default_audience": "api1",
+				"token_lifetime_seconds": 600, "custom_claims": {"tenant": "t1", "limits": {"rps": 10, "tags": ["a", "b"]}},
+				"token_exchange": {"subject_clients": ["web"], "audiences": ["api2"], "max_delegation_depth": 2}},
+			{"client_id": "web", "public": true, "grant_types": ["authorization_code"],
+				"redirect_uris": ["https://web.example.com/cb"], "allow_refresh_token": true},
+			{"client_id": "mtls", "tls_client_auth_subject_dn": "CN=mtls", "tls_client_certificate_bound_access_tokens": true}],
+		"users": [{"username": "alice", "password": "$2a$10$0sxSR6FKk8msHgPSBN0Au.sGW3HQxRughWXsAZMq8GAVDcrTfFeLm", "roles": ["reader"]}]}`
+	if err := ioutil.WriteFile(path, []byte(js), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(&models.ServiceConfig{UserConf: path})
+	current, err := s.parseAuthorizationData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handlers.ValidateAuthorization(current); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	s.authorization = current
+	s.authTargets = []authorizationSetter{&testSetter{authorization: current}}
+
+	oldHash := current.GetClients()[0].GetClientSecret()
+	newHash, err := passwd.Hash("secret1", passwd.Config{Algorithm: passwd.Argon2id, Cost: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateClientSecret("cl1", oldHash, newHash); err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+
+	// The rewritten file is loaded and validated as on startup and reload
+	written, err := s.parseAuthorizationData()
+	if err != nil {
+		t.Fatalf("Rewritten config could not be parsed: %v", err)
+	}
+	if err := handlers.ValidateAuthorization(written); err != nil {
+		t.Fatalf("Rewritten config is not valid: %v", err)
+	}
+	if err := passwd.ComparePasswords("secret1", written.GetClients()[0].GetClientSecret()); err != nil {
+		t.Errorf("Rewritten secret does not match: %v", err)
+	}
+	expected := proto.Clone(current).(*models.Authorization)
+	expected.Clients[0].ClientSecret = newHash
+	if !proto.Equal(written, expected) {
+		t.Errorf("Expected: %v, Got: %v", expected, written)
+	}
+}
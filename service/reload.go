@@ -0,0 +1,148 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jafossum/go-auth-server/handlers"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// authorizationSetter - Handlers serving requests with the authorization data
+type authorizationSetter interface {
+	SetAuthorization(authorization *models.Authorization)
+}
+
+// Reload - Re-read the authorization data and replace it in all handlers. The current data is kept
+// if the file can not be parsed or is not valid
+func (s *Service) Reload() error {
+	a, err := s.parseAuthorizationData()
+	if err != nil {
+		return err
+	}
+	if err := handlers.ValidateAuthorization(a); err != nil {
+		return fmt.Errorf("Authorization config: %s is not valid, keeping current config: %s", s.config.UserConf, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.authorization == nil {
+		return errors.New("Service is not started, nothing to reload")
+	}
+	changes := diffAuthorization(s.authorization, a)
+	if len(changes) == 0 {
+		logger.Info.Printf("Authorization config: %s reloaded, no changes", s.config.UserConf)
+		return nil
+	}
+	for _, h := range s.authTargets {
+		h.SetAuthorization(a)
+	}
+	s.authorization = a
+	logger.Info.Printf("Authorization config: %s reloaded", s.config.UserConf)
+	for _, c := range changes {
+		logger.Info.Printf("  %s", c)
+	}
+	return nil
+}
+
+// watchAuthorizationData - Reload when the modification time of the file changes, until the service stops
+func (s *Service) watchAuthorizationData(interval time.Duration) {
+	modTime := func() time.Time {
+		fi, err := os.Stat(s.config.UserConf)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	last := modTime()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.forever:
+			return
+		case <-ticker.C:
+			if m := modTime(); !m.IsZero() && !m.Equal(last) {
+				last = m
+				if err := s.Reload(); err != nil {
+					logger.Error.Println(err)
+				}
+			}
+		}
+	}
+}
+
+// diffAuthorization - Human readable list of changes between two configs. Secrets are never included
+func diffAuthorization(old, new *models.Authorization) []string {
+	changes := []string{}
+	if old.GetIssuer() != new.GetIssuer() {
+		changes = append(changes, fmt.Sprintf("issuer changed: %s -> %s. Tokens issued before are no longer valid", old.GetIssuer(), new.GetIssuer()))
+	}
+	if old.GetTokenEndpointUrl() != new.GetTokenEndpointUrl() {
+		changes = append(changes, fmt.Sprintf("token_endpoint_url changed: %s -> %s", old.GetTokenEndpointUrl(), new.GetTokenEndpointUrl()))
+	}
+
+	oldClients := map[string]proto.Message{}
+	for _, c := range old.GetClients() {
+		oldClients[c.GetClientId()] = c
+	}
+	newClients := map[string]proto.Message{}
+	for _, c := range new.GetClients() {
+		newClients[c.GetClientId()] = c
+	}
+	changes = append(changes, diffMessages("client", oldClients, newClients)...)
+
+	oldUsers := map[string]proto.Message{}
+	for _, u := range old.GetUsers() {
+		oldUsers[u.GetUsername()] = u
+	}
+	newUsers := map[string]proto.Message{}
+	for _, u := range new.GetUsers() {
+		newUsers[u.GetUsername()] = u
+	}
+	changes = append(changes, diffMessages("user", oldUsers, newUsers)...)
+
+	oldIssuers := map[string]proto.Message{}
+	for _, ti := range old.GetTrustedIssuers() {
+		oldIssuers[ti.GetIssuer()] = ti
+	}
+	newIssuers := map[string]proto.Message{}
+	for _, ti := range new.GetTrustedIssuers() {
+		newIssuers[ti.GetIssuer()] = ti
+	}
+	return append(changes, diffMessages("trusted issuer", oldIssuers, newIssuers)...)
+}
+
+// diffMessages - Added, removed and changed entries, sorted by ID
+func diffMessages(kind string, old, new map[string]proto.Message) []string {
+	ids := []string{}
+	for id := range old {
+		ids = append(ids, id)
+	}
+	for id := range new {
+		if _, ok := old[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	changes := []string{}
+	for _, id := range ids {
+		o, inOld := old[id]
+		n, inNew := new[id]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("%s added: %s", kind, id))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("%s removed: %s", kind, id))
+		case !proto.Equal(o, n):
+			changes = append(changes, fmt.Sprintf("%s changed: %s", kind, id))
+		}
+	}
+	return changes
+}
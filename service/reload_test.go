@@ -0,0 +1,147 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jafossum/go-auth-server/handlers"
+	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/utils/logger"
+)
+
+// Important to not get nullpointer on logger!
+func init() {
+	logger.TestInit()
+}
+
+func TestValidateAuthorizationConfig(t *testing.T) {
+	s := NewService(&models.ServiceConfig{UserConf: "../config/auth_conf.json"})
+	a, err := s.parseAuthorizationData()
+	if err != nil {
+		t.Fatalf("Got uinexpected error: %v", err)
+	}
+	if err := handlers.ValidateAuthorization(a); err != nil {
+		t.Errorf("Example config not valid: %v", err)
+	}
+}
+
+func TestDiffAuthorization(t *testing.T) {
+	old := &models.Authorization{
+		Issuer: "https://auth.example.com",
+		Clients: []*models.Client{
+			{ClientId: "cl1", ClientSecret: "hash1"},
+			{ClientId: "cl2", ClientSecret: "hash2"},
+			{ClientId: "cl3", ClientSecret: "hash3"},
+		},
+		Users: []*models.User{{Username: "user1"}},
+	}
+	var testResp = []struct {
+		name string
+		new  *models.Authorization
+		exp  []string
+	}{
+		{"unchanged", old, []string{}},
+		{"clients", &models.Authorization{
+			Issuer: "https://auth.example.com",
+			Clients: []*models.Client{
+				{ClientId: "cl1", ClientSecret: "hash1"},
+				{ClientId: "cl3", ClientSecret: "changed"},
+				{ClientId: "cl4", ClientSecret: "hash4"},
+			},
+			Users: []*models.User{{Username: "user1"}},
+		}, []string{"client removed: cl2", "client changed: cl3", "client added: cl4"}},
+		{"issuer and users", &models.Authorization{
+			Issuer:  "https://other.example.com",
+			Clients: old.Clients,
+			Users:   []*models.User{{Username: "user2"}},
+		}, []string{
+			"issuer changed: https://auth.example.com -> https://other.example.com. Tokens issued before are no longer valid",
+			"user removed: user1",
+			"user added: user2",
+		}},
+	}
+	for _, tc := range testResp {
+		tc := tc // rebind
+		t.Run(tc.name, func(t *testing.T) {
+			res := diffAuthorization(old, tc.new)
+			if !reflect.DeepEqual(res, tc.exp) {
+				t.Errorf("Expected: %v, Got: %v", tc.exp, res)
+			}
+		})
+	}
+}
+
+// testSetter - Records the authorization data it is given
+type testSetter struct {
+	authorization *models.Authorization
+}
+
+func (t *testSetter) SetAuthorization(authorization *models.Authorization) {
+	t.authorization = authorization
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth_conf.json")
+	write := func(js string) {
+		if err := ioutil.WriteFile(path, []byte(js), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Not started
+	write(`{"clients": [{"client_id": "cl1", "client_secret": "hash1"}]}`)
+	s := NewService(&models.ServiceConfig{UserConf: path})
+	if err := s.Reload(); err == nil {
+		t.Error("Reload before start, Expected error")
+	}
+
+	current := &models.Authorization{Clients: []*models.Client{{ClientId: "cl1", ClientSecret: "hash1"}}}
+	setter := &testSetter{authorization: current}
+	s.authorization = current
+	s.authTargets = []authorizationSetter{setter}
+
+	var testResp = []struct {
+		name    string
+		js      string
+		err     bool   // expect error
+		clients int    // clients after reload
+		updated bool   // expect new data in the handlers
+		client  string // client_id of the last client
+	}{
+		{"unchanged", `{"clients": [{"client_id": "cl1", "client_secret": "hash1"}]}`, false, 1, false, "cl1"},
+		{"client added", `{"clients": [{"client_id": "cl1", "client_secret": "hash1"}, {"client_id": "cl2", "public": true}]}`, false, 2, true, "cl2"},
+		{"parse error", `{"clients": [{"client_id": "cl1",`, true, 2, false, "cl2"},
+		{"unknown field", `{"clients": [{"client_id": "cl3", "unknown": true}]}`, true, 2, false, "cl2"},
+		{"not valid", `{"clients": [{"client_id": "cl3"}]}`, true, 2, false, "cl2"},
+		{"client removed", `{"clients": [{"client_id": "cl3", "client_secret": "hash3"}]}`, false, 1, true, "cl3"},
+	}
+	for _, tc := range testResp {
+		write(tc.js)
+		before := setter.authorization
+		err := s.Reload()
+		if err == nil && tc.err {
+			t.Errorf("%s, Not getting expected error", tc.name)
+		}
+		if err != nil && !tc.err {
+			t.Errorf("%s, Got uinexpected error: %v", tc.name, err)
+		}
+		if (setter.authorization != before) != tc.updated {
+			t.Errorf("%s, Expected handler update: %v", tc.name, tc.updated)
+		}
+		clients := setter.authorization.GetClients()
+		if len(clients) != tc.clients || clients[len(clients)-1].GetClientId() != tc.client {
+			t.Errorf("%s, Expected %d clients ending with %s, Got: %v", tc.name, tc.clients, tc.client, clients)
+		}
+		if s.authorization != setter.authorization {
+			t.Errorf("%s, Service and handler data differ", tc.name)
+		}
+	}
+}
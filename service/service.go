@@ -2,23 +2,33 @@ package service
 
 import (
 	"context"
+	"crypto"
 	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/gorilla/mux"
+	"github.com/jafossum/go-auth-server/crypto/keydir"
+	"github.com/jafossum/go-auth-server/crypto/keyring"
+	"github.com/jafossum/go-auth-server/crypto/passwd"
+	"github.com/jafossum/go-auth-server/crypto/pkcs8"
 	rsaa "github.com/jafossum/go-auth-server/crypto/rsa"
+	"github.com/jafossum/go-auth-server/crypto/signing"
 	"github.com/jafossum/go-auth-server/handlers"
 	"github.com/jafossum/go-auth-server/handlers/middleware"
 	"github.com/jafossum/go-auth-server/models"
+	"github.com/jafossum/go-auth-server/store"
 	"github.com/jafossum/go-auth-server/utils/logger"
 )
 
@@ -26,6 +36,10 @@ import (
 type Service struct {
 	config  *models.ServiceConfig
 	forever chan struct{}
+
+	mu            sync.Mutex
+	authorization *models.Authorization
+	authTargets   []authorizationSetter
 }
 
 // NewService : Create a new service
@@ -57,30 +71,139 @@ func (s *Service) run() {
 	if err != nil {
 		logger.Error.Fatalln(err)
 	}
+	if err := handlers.ValidateAuthorization(authData); err != nil {
+		logger.Error.Fatalln("Authorization config is not valid:", err)
+	}
+	if err := passwd.SetDefault(passwd.Config{Algorithm: s.config.PasswordHash, Cost: s.config.PasswordHashCost}); err != nil {
+		logger.Error.Fatalln(err)
+	}
+	// Secrets are verified on a bounded pool, so a burst of token requests can not take every CPU
+	pool := passwd.NewPool(passwd.PoolConfig{Workers: s.config.VerifyWorkers, Queue: s.config.VerifyQueue, Timeout: s.config.VerifyTimeout})
+	stats := pool.Stats()
+	logger.Info.Printf("Password verification pool: %d workers, queue of %d", stats.Workers, stats.QueueLimit)
 
 	// TLS options. Can be used without, but only for testing!!
 	t := &tls.Config{}
 	s.setTLSConfig(t)
 
-	// Load ort generate RSA keys
-	privateKey, err := s.getRSAKeys()
+	// Load or generate signing keys
+	if err := s.readPassphrase(); err != nil {
+		logger.Error.Fatalln(err)
+	}
+	privateKey, err := s.getSigningKey()
 	if err != nil {
-		logger.Error.Fatalln("Load or Generation of RSA key failed")
+		logger.Error.Fatalln("Load or Generation of signing key failed:", err)
+	}
+	keys, err := s.getKeyRing(privateKey)
+	if err != nil {
+		logger.Error.Fatalln(err)
 	}
 
 	// Handlers
 	jwks := handlers.JwksHandler
-	jwks.SetCertificate(privateKey)
+	jwks.SetKeyRing(keys)
+
+	// Refresh token storage
+	refreshStore, err := s.getRefreshTokenStore()
+	if err != nil {
+		logger.Error.Fatalln(err)
+	}
+
+	// Revoked access tokens
+	revocations, err := s.getRevocationStore()
+	if err != nil {
+		logger.Error.Fatalln(err)
+	}
+
+	// Authorization codes are short lived, and only kept in memory
+	codeStore := store.NewMemoryAuthorizationCodeStore()
+
+	// Rate limits and lockouts, shared by the token endpoint and the login form
+	limits, err := handlers.NewRateLimits(s.config.RateLimitConf)
+	if err != nil {
+		logger.Error.Fatalln(err)
+	}
+
+	// Used client assertions and DPoP proofs, shared so an assertion can only be used once on any endpoint
+	replays := store.NewMemoryReplayCache()
 
 	token := handlers.TokenHandler
-	token.SetCertificate(privateKey)
+	token.SetKeyRing(keys)
 	token.SetAuthorization(authData)
+	token.SetRefreshTokenStore(refreshStore)
+	token.SetAuthorizationCodeStore(codeStore)
+	token.SetRevocationStore(revocations)
+	token.SetReplayCache(replays)
+	token.SetVerifyPool(pool)
+	token.SetDPoPNonceRequired(s.config.DPoPNonce)
+	token.SetRateLimits(limits)
+
+	introspect := handlers.IntrospectHandler
+	introspect.SetKeyRing(keys)
+	introspect.SetAuthorization(authData)
+	introspect.SetRevocationStore(revocations)
+	introspect.SetReplayCache(replays)
+	introspect.SetVerifyPool(pool)
+
+	revoke := handlers.RevokeHandler
+	revoke.SetKeyRing(keys)
+	revoke.SetAuthorization(authData)
+	revoke.SetRefreshTokenStore(refreshStore)
+	revoke.SetRevocationStore(revocations)
+	revoke.SetReplayCache(replays)
+	revoke.SetVerifyPool(pool)
+
+	admin := handlers.AdminHandler
+	admin.SetKeyRing(keys)
+	admin.SetAuthorization(authData)
+	admin.SetKeyDir(s.signingKeyDir())
+	admin.SetReplayCache(replays)
+	admin.SetVerifyPool(pool)
+
+	authorize := handlers.AuthorizeHandler
+	authorize.SetAuthorization(authData)
+	authorize.SetAuthorizationCodeStore(codeStore)
+	authorize.SetRateLimits(limits)
+	authorize.SetVerifyPool(pool)
 
 	r := mux.NewRouter()
-	r.HandleFunc("/.well-known/jwks.json", jwks.Handle).Methods("GET")
-	r.HandleFunc("/oauth/token", token.Handle).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", jwks.Handle).Methods("GET").Name(handlers.RouteJwks)
+	r.HandleFunc("/oauth/token", token.Handle).Methods("POST").Name(handlers.RouteToken)
+	r.HandleFunc("/oauth/introspect", introspect.Handle).Methods("POST").Name(handlers.RouteIntrospect)
+	r.HandleFunc("/oauth/revoke", revoke.Handle).Methods("POST").Name(handlers.RouteRevoke)
+	r.HandleFunc("/authorize", authorize.Handle).Methods("GET", "POST").Name(handlers.RouteAuthorize)
+	r.HandleFunc("/admin/keys/rotate", admin.HandleRotate).Methods("POST")
+	r.HandleFunc("/admin/keys", admin.HandleRetire).Methods("DELETE")
+
+	// Discovery metadata is built from the routes above
+	discovery := handlers.DiscoveryHandler
+	discovery.SetKeyRing(keys)
+	discovery.SetAuthorization(authData)
+	discovery.SetRouter(r)
+	r.HandleFunc("/.well-known/openid-configuration", discovery.Handle).Methods("GET")
+	r.HandleFunc("/.well-known/oauth-authorization-server", discovery.Handle).Methods("GET")
+	if s.config.Metrics {
+		handlers.MetricsHandler.SetVerifyPool(pool)
+		r.HandleFunc("/metrics", handlers.MetricsHandler.Handle).Methods("GET")
+	}
 	r.Use(middleware.LoggingMiddleware)
 
+	// Handlers to update when the authorization data is reloaded
+	s.mu.Lock()
+	s.authorization = authData
+	s.authTargets = []authorizationSetter{token, introspect, revoke, admin, authorize, discovery}
+	s.mu.Unlock()
+	if s.config.RehashSecrets {
+		logger.Info.Printf("Client secrets not hashed with %s are rehashed on authentication", passwd.Default().Algorithm)
+		for _, h := range []secretUpdaterSetter{token, introspect, revoke, admin} {
+			h.SetSecretUpdater(s)
+		}
+	}
+	if s.config.UserConfWatch > 0 {
+		logger.Info.Printf("Watching %s for changes every %s", s.config.UserConf, s.config.UserConfWatch)
+		go s.watchAuthorizationData(s.config.UserConfWatch)
+	}
+
 	srv := &http.Server{
 		Handler: r,
 		Addr:    fmt.Sprintf(":%s", s.config.Port),
@@ -115,6 +238,16 @@ func (s *Service) parseAuthorizationData() (*models.Authorization, error) {
 	return a, nil
 }
 
+// secretUpdaterSetter - Handlers that authenticate clients with secrets, and can rehash them
+type secretUpdaterSetter interface {
+	SetSecretUpdater(u handlers.SecretUpdater)
+}
+
+// clientCertificateSetter - Handlers that authenticate clients with certificates, or publish the methods
+type clientCertificateSetter interface {
+	SetClientCertificateAuth(roots *x509.CertPool)
+}
+
 // setTLSConfig - Set TLS confog
 func (s *Service) setTLSConfig(t *tls.Config) {
 	// TLS options. Can be used without, but only for testing!!
@@ -128,16 +261,160 @@ func (s *Service) setTLSConfig(t *tls.Config) {
 		}
 		t.Certificates = []tls.Certificate{cer}
 	}
+	if s.config.TLSConf.ClientAuth || s.config.TLSConf.ClientCA != "" {
+		if len(t.Certificates) == 0 {
+			logger.Error.Fatal("Client certificates can only be requested with TLS enabled")
+		}
+		// Certificates are checked by the handlers, so self-signed client certificates can be used
+		t.ClientAuth = tls.RequestClientCert
+		var roots *x509.CertPool
+		if s.config.TLSConf.ClientCA != "" {
+			pem, err := ioutil.ReadFile(s.config.TLSConf.ClientCA)
+			if err != nil {
+				logger.Error.Fatal("Load of TLS client CA failed: ", err)
+			}
+			roots = x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				logger.Error.Fatal("TLS client CA has no PEM certificates: ", s.config.TLSConf.ClientCA)
+			}
+		}
+		for _, h := range []clientCertificateSetter{handlers.TokenHandler, handlers.IntrospectHandler, handlers.RevokeHandler,
+			handlers.AdminHandler, handlers.DiscoveryHandler} {
+			h.SetClientCertificateAuth(roots)
+		}
+		logger.Info.Println("Mutual TLS client authentication enabled")
+	}
+}
+
+// readPassphrase - Read the private key passphrase from file or stdin, if configured
+func (s *Service) readPassphrase() error {
+	if s.config.RSAConf.PassFile == "" {
+		return nil
+	}
+	if s.config.RSAConf.Pass != "" {
+		return errors.New("Private key passphrase given both as rsa_pass and rsa_pass_file")
+	}
+	if s.config.RSAConf.PassFile == "-" {
+		logger.Info.Println("Reading private key passphrase from stdin")
+	}
+	pass, err := pkcs8.ReadPassphrase(s.config.RSAConf.PassFile, os.Stdin)
+	if err != nil {
+		return err
+	}
+	s.config.RSAConf.Pass = pass
+	return nil
 }
 
-// getRSAKeys - Read or Create and parse RSA keys
+// getSigningKey - Load the signing key for the configured algorithm, from rsa_private or the key directory.
+// A temporary key is only generated in development mode
+func (s *Service) getSigningKey() (crypto.Signer, error) {
+	alg := s.config.SigningAlg
+	if alg == "" {
+		alg = signing.RS256
+	}
+	if _, err := signing.Method(alg); err != nil {
+		return nil, err
+	}
+	if dir := s.signingKeyDir(); dir != "" {
+		return keydir.LoadOrGenerate(dir, alg, signing.GenerateKey)
+	}
+	if alg == signing.RS256 {
+		return s.getRSAKeys()
+	}
+	if s.config.RSAConf.Private == "" {
+		if !s.config.Dev {
+			return nil, errors.New("No private key given. Set rsa_private or key_dir, or use -dev to run with a temporary key")
+		}
+		logger.Warning.Printf("No %s Key given, generating temp one", alg)
+		return signing.GenerateKey(alg)
+	}
+	key, err := signing.ParsePrivateKey(s.config.RSAConf.Private, s.config.RSAConf.Pass)
+	if err != nil {
+		return nil, err
+	}
+	if keyAlg, _ := signing.Algorithm(key.Public()); keyAlg != alg {
+		return nil, fmt.Errorf("Private key: %s is a %s key, not %s", s.config.RSAConf.Private, keyAlg, alg)
+	}
+	return key, nil
+}
+
+// getRSAKeys - Read and parse RSA keys. A temporary key is only created on errors in development mode
 func (s *Service) getRSAKeys() (*rsa.PrivateKey, error) {
+	if !s.config.Dev {
+		if s.config.RSAConf.Private == "" {
+			return nil, errors.New("No RSA private key given. Set rsa_private or key_dir, or use -dev to run with a temporary key")
+		}
+		return rsaa.LoadRsaKeys(
+			s.config.RSAConf.Private,
+			s.config.RSAConf.Pass,
+			s.config.RSAConf.Public)
+	}
 	return rsaa.ParseRsaKeys(
 		s.config.RSAConf.Private,
 		s.config.RSAConf.Pass,
 		s.config.RSAConf.Public)
 }
 
+// signingKeyDir - The key directory, if the signing key is kept there. Empty if rsa_private is given
+func (s *Service) signingKeyDir() string {
+	if s.config.RSAConf.Private != "" {
+		return ""
+	}
+	return s.config.KeyDir
+}
+
+// getKeyRing - Key ring with privateKey as signing key, the previous signing keys in the key directory,
+// and the configured verification-only keys
+func (s *Service) getKeyRing(privateKey crypto.Signer) (*keyring.KeyRing, error) {
+	keys, err := keyring.New(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if dir := s.signingKeyDir(); dir != "" {
+		previous, err := keydir.LoadPrevious(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range previous {
+			k, err := keys.AddPreviousKey(p.PublicKey, p.RetireAt)
+			if err != nil {
+				return nil, err
+			}
+			logger.Info.Printf("Previous signing key: %s loaded, retires at: %s", k.ID, p.RetireAt.Format(time.RFC3339))
+		}
+	}
+	for _, path := range s.config.RSAConf.Verify {
+		pub, err := signing.ParsePublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		k, err := keys.AddVerificationKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info.Printf("Verification key: %s loaded from: %s", k.ID, path)
+	}
+	return keys, nil
+}
+
+// getRefreshTokenStore - File backed store if configured, or in-memory store
+func (s *Service) getRefreshTokenStore() (store.RefreshTokenStore, error) {
+	if s.config.RefreshStore == "" {
+		logger.Warning.Println("No Refresh Token store file given, refresh tokens will not survive restart")
+		return store.NewMemoryRefreshTokenStore(), nil
+	}
+	return store.NewFileRefreshTokenStore(s.config.RefreshStore)
+}
+
+// getRevocationStore - File backed store if configured, or in-memory store
+func (s *Service) getRevocationStore() (store.RevocationStore, error) {
+	if s.config.RevocationStore == "" {
+		logger.Warning.Println("No Revocation store file given, revoked tokens will be valid again after restart")
+		return store.NewMemoryRevocationStore(), nil
+	}
+	return store.NewFileRevocationStore(s.config.RevocationStore)
+}
+
 // serve - Start the HTTP Server
 func (s *Service) serve(srv *http.Server) {
 	if len(srv.TLSConfig.Certificates) > 0 {
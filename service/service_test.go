@@ -0,0 +1,75 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/jafossum/go-auth-server/crypto/signing"
+	"github.com/jafossum/go-auth-server/handlers"
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func TestKeyRotationRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := &models.ServiceConfig{KeyDir: dir, SigningAlg: signing.ES256, RSAConf: &models.RSAConfig{}}
+
+	// start - Load the key ring the way the service does on startup
+	start := func() *Service {
+		s := NewService(config)
+		key, err := s.getSigningKey()
+		if err != nil {
+			t.Fatalf("Got uinexpected error: %v", err)
+		}
+		keys, err := s.getKeyRing(key)
+		if err != nil {
+			t.Fatalf("Got uinexpected error: %v", err)
+		}
+		handlers.AdminHandler.SetKeyRing(keys)
+		handlers.AdminHandler.SetKeyDir(s.signingKeyDir())
+		handlers.JwksHandler.SetKeyRing(keys)
+		return s
+	}
+	jwksKids := func() []string {
+		rr := httptest.NewRecorder()
+		handlers.JwksHandler.Handle(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+		jwks := &models.Jwks{}
+		if err := json.NewDecoder(rr.Body).Decode(jwks); err != nil {
+			t.Fatalf("Got uinexpected error: %v", err)
+		}
+		kids := []string{}
+		for _, k := range jwks.Keys {
+			kids = append(kids, k.Kid)
+		}
+		return kids
+	}
+
+	start()
+	handlers.AdminHandler.SetAuthorization(&models.Authorization{Clients: []*models.Client{
+		{ClientId: "admin", ClientSecret: "$2a$10$0sxSR6FKk8msHgPSBN0Au.sGW3HQxRughWXsAZMq8GAVDcrTfFeLm", IsAdmin: true},
+	}})
+	old := jwksKids()
+	req := httptest.NewRequest("POST", "/admin/keys/rotate", nil)
+	req.SetBasicAuth("admin", "secret3")
+	rr := httptest.NewRecorder()
+	handlers.AdminHandler.HandleRotate(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Rotate, Expected: 200, Got: %d %s", rr.Code, rr.Body.String())
+	}
+	rotated := &models.JSONWebKeys{}
+	json.NewDecoder(rr.Body).Decode(rotated)
+
+	// After a restart the rotated key still signs, and the old key is still published
+	start()
+	kids := jwksKids()
+	if len(kids) != 2 || kids[0] != rotated.Kid || kids[1] != old[0] {
+		t.Errorf("JWKS after restart, Expected: [%s %s], Got: %v", rotated.Kid, old[0], kids)
+	}
+}
@@ -0,0 +1,14 @@
+package store
+
+import "github.com/jafossum/go-auth-server/models"
+
+//go:generate mockgen -destination=../mocks/code_store_mock.go -package=mocks github.com/jafossum/go-auth-server/store AuthorizationCodeStore
+
+// AuthorizationCodeStore : Storage for short-lived authorization codes
+type AuthorizationCodeStore interface {
+	// Save - Store a new authorization code
+	Save(code *models.AuthorizationCode) error
+	// Consume - Remove and return the code. Returns ErrNotFound for unknown,
+	// expired or already used codes
+	Consume(id string) (*models.AuthorizationCode, error)
+}
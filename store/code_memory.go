@@ -0,0 +1,49 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// MemoryAuthorizationCodeStore - In-memory AuthorizationCodeStore
+type MemoryAuthorizationCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]*models.AuthorizationCode
+}
+
+// NewMemoryAuthorizationCodeStore - Create a new empty in-memory store
+func NewMemoryAuthorizationCodeStore() *MemoryAuthorizationCodeStore {
+	return &MemoryAuthorizationCodeStore{codes: make(map[string]*models.AuthorizationCode)}
+}
+
+// Save - Store a new authorization code, and purge expired codes
+func (s *MemoryAuthorizationCodeStore) Save(code *models.AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, c := range s.codes {
+		if c.Expired(now) {
+			delete(s.codes, id)
+		}
+	}
+	c := *code
+	s.codes[c.ID] = &c
+	return nil
+}
+
+// Consume - Remove and return the code
+func (s *MemoryAuthorizationCodeStore) Consume(id string) (*models.AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.codes[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.codes, id)
+	if c.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func TestMemoryAuthorizationCodeStore(t *testing.T) {
+	s := NewMemoryAuthorizationCodeStore()
+	s.Save(&models.AuthorizationCode{ID: "c1", ClientID: "cl1", ExpiresAt: time.Now().Add(time.Minute)})
+	s.Save(&models.AuthorizationCode{ID: "expired", ClientID: "cl1", ExpiresAt: time.Now().Add(-time.Second)})
+
+	var testResp = []struct {
+		id  string // input
+		err error  // expected error
+	}{
+		{"c1", nil},
+		{"c1", ErrNotFound},
+		{"expired", ErrNotFound},
+		{"unknown", ErrNotFound},
+	}
+	for _, tc := range testResp {
+		res, err := s.Consume(tc.id)
+		if err != tc.err {
+			t.Errorf("Consume(%s), Expected: %v, Got: %v", tc.id, tc.err, err)
+		}
+		if err == nil && res.ClientID != "cl1" {
+			t.Errorf("Consume(%s), Expected code for cl1, Got: %v", tc.id, res)
+		}
+	}
+}
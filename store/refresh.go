@@ -0,0 +1,29 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+//go:generate mockgen -destination=../mocks/refresh_store_mock.go -package=mocks github.com/jafossum/go-auth-server/store RefreshTokenStore
+
+var (
+	// ErrNotFound - Token does not exist, is expired or revoked
+	ErrNotFound = errors.New("token not found")
+	// ErrReused - Refresh token has already been used
+	ErrReused = errors.New("refresh token already used")
+)
+
+// RefreshTokenStore : Storage for refresh token state
+type RefreshTokenStore interface {
+	// Save - Store a new refresh token
+	Save(token *models.RefreshToken) error
+	// Get - Return the token without using it. Returns ErrNotFound for unknown or expired tokens
+	Get(id string) (*models.RefreshToken, error)
+	// Consume - Mark token as used and return it. Returns ErrNotFound for unknown or expired
+	// tokens, and ErrReused together with the token if it has already been used
+	Consume(id string) (*models.RefreshToken, error)
+	// RevokeFamily - Remove all tokens in a rotation family
+	RevokeFamily(familyID string) error
+}
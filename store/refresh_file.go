@@ -0,0 +1,104 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// FileRefreshTokenStore - RefreshTokenStore persisted to a JSON file, so state survives restarts.
+// The file is rewritten on every change
+type FileRefreshTokenStore struct {
+	*MemoryRefreshTokenStore
+	path string
+}
+
+// NewFileRefreshTokenStore - Create a store backed by the file at path, loading any existing state
+func NewFileRefreshTokenStore(path string) (*FileRefreshTokenStore, error) {
+	s := &FileRefreshTokenStore{
+		MemoryRefreshTokenStore: NewMemoryRefreshTokenStore(),
+		path:                    path,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("Refresh token store: %s could not be created: %s", path, err)
+	}
+	js, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Refresh token store: %s could not be read: %s", path, err)
+	}
+	var tokens []*models.RefreshToken
+	if err := json.Unmarshal(js, &tokens); err != nil {
+		return nil, fmt.Errorf("Refresh token store: %s could not be parsed: %s", path, err)
+	}
+	for _, t := range tokens {
+		s.tokens[t.ID] = t
+	}
+	return s, nil
+}
+
+// Save - Store a new refresh token
+func (s *FileRefreshTokenStore) Save(token *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.save(token)
+	return s.persist()
+}
+
+// Consume - Mark token as used and return it
+func (s *FileRefreshTokenStore) Consume(id string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.consume(id)
+	if err == nil {
+		if perr := s.persist(); perr != nil {
+			return nil, perr
+		}
+	}
+	return t, err
+}
+
+// RevokeFamily - Remove all tokens in a rotation family
+func (s *FileRefreshTokenStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeFamily(familyID)
+	return s.persist()
+}
+
+// persist - Write all tokens to a temp file and rename it into place. Caller must hold the lock
+func (s *FileRefreshTokenStore) persist() error {
+	tokens := make([]*models.RefreshToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+	js, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, js)
+}
+
+// writeFileAtomic - Write data to a temp file (mode 0600) in the same directory, and rename it to path
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileRefreshTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileRefreshTokenStore(filepath.Join(dir, "tokens.json"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testRefreshTokenStore(t, s)
+}
+
+func TestFileRefreshTokenStoreSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "tokens.json")
+
+	s, err := NewFileRefreshTokenStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s.Save(newTestToken("t1", "t1", time.Hour))
+	s.Save(newTestToken("t2", "t2", time.Hour))
+	s.Consume("t1")
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected file mode 0600, Got: %v", fi.Mode().Perm())
+	}
+
+	s, err = NewFileRefreshTokenStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.Consume("t1"); err != ErrReused {
+		t.Errorf("Consume(t1) after restart, Expected: %v, Got: %v", ErrReused, err)
+	}
+	if _, err := s.Consume("t2"); err != nil {
+		t.Errorf("Consume(t2) after restart, Unexpected error: %v", err)
+	}
+}
+
+func TestNewFileRefreshTokenStoreInvalidFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("not json")
+	f.Close()
+
+	if _, err := NewFileRefreshTokenStore(f.Name()); err == nil {
+		t.Error("Expected error on invalid store file")
+	}
+}
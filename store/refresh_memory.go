@@ -0,0 +1,93 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+// MemoryRefreshTokenStore - In-memory RefreshTokenStore. State is lost on restart
+type MemoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*models.RefreshToken
+}
+
+// NewMemoryRefreshTokenStore - Create a new empty in-memory store
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{tokens: make(map[string]*models.RefreshToken)}
+}
+
+// Save - Store a new refresh token
+func (s *MemoryRefreshTokenStore) Save(token *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.save(token)
+	return nil
+}
+
+// Get - Return the token without using it
+func (s *MemoryRefreshTokenStore) Get(id string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || t.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	res := *t
+	return &res, nil
+}
+
+// Consume - Mark token as used and return it
+func (s *MemoryRefreshTokenStore) Consume(id string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.consume(id)
+}
+
+// RevokeFamily - Remove all tokens in a rotation family
+func (s *MemoryRefreshTokenStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeFamily(familyID)
+	return nil
+}
+
+// save - Store token and purge expired tokens. Caller must hold the lock
+func (s *MemoryRefreshTokenStore) save(token *models.RefreshToken) {
+	now := time.Now()
+	for id, t := range s.tokens {
+		if t.Expired(now) {
+			delete(s.tokens, id)
+		}
+	}
+	t := *token
+	s.tokens[t.ID] = &t
+}
+
+// consume - Caller must hold the lock
+func (s *MemoryRefreshTokenStore) consume(id string) (*models.RefreshToken, error) {
+	t, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if t.Expired(time.Now()) {
+		delete(s.tokens, id)
+		return nil, ErrNotFound
+	}
+	res := *t
+	if t.Used {
+		return &res, ErrReused
+	}
+	t.Used = true
+	return &res, nil
+}
+
+// revokeFamily - Caller must hold the lock
+func (s *MemoryRefreshTokenStore) revokeFamily(familyID string) {
+	for id, t := range s.tokens {
+		if t.FamilyID == familyID {
+			delete(s.tokens, id)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jafossum/go-auth-server/models"
+)
+
+func newTestToken(id, family string, ttl time.Duration) *models.RefreshToken {
+	now := time.Now()
+	return &models.RefreshToken{ID: id, FamilyID: family, ClientID: "cl1", IssuedAt: now, ExpiresAt: now.Add(ttl)}
+}
+
+// testRefreshTokenStore - Shared behaviour tests for RefreshTokenStore implementations
+func testRefreshTokenStore(t *testing.T, s RefreshTokenStore) {
+	s.Save(newTestToken("t1", "t1", time.Hour))
+	s.Save(newTestToken("t2", "t1", time.Hour))
+	s.Save(newTestToken("t3", "t3", time.Hour))
+	s.Save(newTestToken("expired", "expired", -time.Second))
+
+	if res, err := s.Get("t1"); err != nil || res.Used {
+		t.Errorf("Get(t1), Expected unused token, Got: %v, %v", res, err)
+	}
+	if _, err := s.Get("expired"); err != ErrNotFound {
+		t.Errorf("Get(expired), Expected: %v, Got: %v", ErrNotFound, err)
+	}
+
+	var testResp = []struct {
+		id  string // input
+		err error  // expected error
+	}{
+		{"t1", nil},
+		{"t1", ErrReused},
+		{"t2", nil},
+		{"unknown", ErrNotFound},
+		{"expired", ErrNotFound},
+	}
+	for _, tc := range testResp {
+		res, err := s.Consume(tc.id)
+		if err != tc.err {
+			t.Errorf("Consume(%s), Expected: %v, Got: %v", tc.id, tc.err, err)
+		}
+		if tc.err != ErrNotFound && (res == nil || res.ID != tc.id) {
+			t.Errorf("Consume(%s), Expected token, Got: %v", tc.id, res)
+		}
+	}
+
+	if err := s.RevokeFamily("t1"); err != nil {
+		t.Errorf("RevokeFamily(t1), Unexpected error: %v", err)
+	}
+	if _, err := s.Consume("t1"); err != ErrNotFound {
+		t.Errorf("Consume(t1) after revoke, Expected: %v, Got: %v", ErrNotFound, err)
+	}
+	if _, err := s.Consume("t3"); err != nil {
+		t.Errorf("Consume(t3) after revoke of other family, Unexpected error: %v", err)
+	}
+}
+
+func TestMemoryRefreshTokenStore(t *testing.T) {
+	testRefreshTokenStore(t, NewMemoryRefreshTokenStore())
+}
+
+func TestMemoryRefreshTokenStorePurgeExpired(t *testing.T) {
+	s := NewMemoryRefreshTokenStore()
+	s.Save(newTestToken("expired", "expired", -time.Second))
+	s.Save(newTestToken("t1", "t1", time.Hour))
+	if len(s.tokens) != 1 {
+		t.Errorf("Expected expired token to be purged, Got: %v tokens", len(s.tokens))
+	}
+}
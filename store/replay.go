@@ -0,0 +1,12 @@
+package store
+
+import "time"
+
+//go:generate mockgen -destination=../mocks/replay_cache_mock.go -package=mocks github.com/jafossum/go-auth-server/store ReplayCache
+
+// ReplayCache : Storage for IDs of single use tokens, like client assertions. Entries are
+// only kept until the token would have expired anyway
+type ReplayCache interface {
+	// Use - Mark the ID as used until expiresAt. Returns ErrReused if it is already used
+	Use(id string, expiresAt time.Time) error
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryReplayCache - In-memory ReplayCache. State is lost on restart
+type MemoryReplayCache struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// NewMemoryReplayCache - Create a new empty in-memory cache
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{used: make(map[string]time.Time)}
+}
+
+// Use - Mark the ID as used until expiresAt, and purge expired entries
+func (s *MemoryReplayCache) Use(id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, exp := range s.used {
+		if !now.Before(exp) {
+			delete(s.used, k)
+		}
+	}
+	if _, ok := s.used[id]; ok {
+		return ErrReused
+	}
+	s.used[id] = expiresAt
+	return nil
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryReplayCache(t *testing.T) {
+	s := NewMemoryReplayCache()
+	s.used["expired"] = time.Now().Add(-time.Second)
+
+	var testResp = []struct {
+		id  string // input
+		err error  // expected result
+	}{
+		{"a1", nil},
+		{"a2", nil},
+		{"a1", ErrReused},
+		{"expired", nil},
+		{"expired", ErrReused},
+	}
+	for _, tc := range testResp {
+		if err := s.Use(tc.id, time.Now().Add(time.Minute)); err != tc.err {
+			t.Errorf("Use(%s), Expected: %v, Got: %v", tc.id, tc.err, err)
+		}
+	}
+	if len(s.used) != 3 {
+		t.Errorf("Expected 3 entries, Got: %d", len(s.used))
+	}
+}
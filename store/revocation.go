@@ -0,0 +1,14 @@
+package store
+
+import "time"
+
+//go:generate mockgen -destination=../mocks/revocation_store_mock.go -package=mocks github.com/jafossum/go-auth-server/store RevocationStore
+
+// RevocationStore : Storage for revoked access token IDs (jti). Entries are
+// only kept until the token would have expired anyway
+type RevocationStore interface {
+	// Revoke - Revoke the token with ID jti, which expires at expiresAt
+	Revoke(jti string, expiresAt time.Time) error
+	// IsRevoked - Check if the token with ID jti is revoked
+	IsRevoked(jti string) (bool, error)
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileRevocationStore - RevocationStore persisted to a JSON file, so revocations survive restarts.
+// The file is rewritten on every revocation
+type FileRevocationStore struct {
+	*MemoryRevocationStore
+	path string
+}
+
+// NewFileRevocationStore - Create a store backed by the file at path, loading any existing state
+func NewFileRevocationStore(path string) (*FileRevocationStore, error) {
+	s := &FileRevocationStore{
+		MemoryRevocationStore: NewMemoryRevocationStore(),
+		path:                  path,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("Revocation store: %s could not be created: %s", path, err)
+	}
+	js, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Revocation store: %s could not be read: %s", path, err)
+	}
+	revoked := map[string]time.Time{}
+	if err := json.Unmarshal(js, &revoked); err != nil {
+		return nil, fmt.Errorf("Revocation store: %s could not be parsed: %s", path, err)
+	}
+	for jti, exp := range revoked {
+		s.revoke(jti, exp)
+	}
+	return s, nil
+}
+
+// Revoke - Revoke the token with ID jti
+func (s *FileRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoke(jti, expiresAt)
+	js, err := json.Marshal(s.revoked)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, js)
+}
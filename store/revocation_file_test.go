@@ -0,0 +1,46 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileRevocationStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileRevocationStore(filepath.Join(dir, "revoked.json"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testRevocationStore(t, s)
+}
+
+func TestFileRevocationStoreSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revoked.json")
+
+	s, err := NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s.Revoke("t1", time.Now().Add(time.Hour))
+
+	s, err = NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if revoked, _ := s.IsRevoked("t1"); !revoked {
+		t.Error("Expected t1 to be revoked after restart")
+	}
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryRevocationStore - In-memory RevocationStore. State is lost on restart
+type MemoryRevocationStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+// NewMemoryRevocationStore - Create a new empty in-memory store
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{revoked: make(map[string]time.Time)}
+}
+
+// Revoke - Revoke the token with ID jti
+func (s *MemoryRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoke(jti, expiresAt)
+	return nil
+}
+
+// IsRevoked - Check if the token with ID jti is revoked
+func (s *MemoryRevocationStore) IsRevoked(jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.revoked[jti]
+	return ok && time.Now().Before(exp), nil
+}
+
+// revoke - Store the revocation and purge expired entries. Caller must hold the lock
+func (s *MemoryRevocationStore) revoke(jti string, expiresAt time.Time) {
+	now := time.Now()
+	for id, exp := range s.revoked {
+		if !now.Before(exp) {
+			delete(s.revoked, id)
+		}
+	}
+	if now.Before(expiresAt) {
+		s.revoked[jti] = expiresAt
+	}
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+// testRevocationStore - Shared behaviour tests for RevocationStore implementations
+func testRevocationStore(t *testing.T, s RevocationStore) {
+	s.Revoke("t1", time.Now().Add(time.Hour))
+	s.Revoke("expired", time.Now().Add(-time.Second))
+
+	var testResp = []struct {
+		jti string // input
+		exp bool   // expected result
+	}{
+		{"t1", true},
+		{"expired", false},
+		{"unknown", false},
+	}
+	for _, tc := range testResp {
+		res, err := s.IsRevoked(tc.jti)
+		if err != nil {
+			t.Errorf("IsRevoked(%s), Unexpected error: %v", tc.jti, err)
+		}
+		if res != tc.exp {
+			t.Errorf("IsRevoked(%s), Expected: %v, Got: %v", tc.jti, tc.exp, res)
+		}
+	}
+}
+
+func TestMemoryRevocationStore(t *testing.T) {
+	testRevocationStore(t, NewMemoryRevocationStore())
+}
+
+func TestMemoryRevocationStorePurgeExpired(t *testing.T) {
+	s := NewMemoryRevocationStore()
+	s.revoked["old"] = time.Now().Add(-time.Second)
+	s.Revoke("t1", time.Now().Add(time.Hour))
+	if len(s.revoked) != 1 {
+		t.Errorf("Expected expired revocation to be purged, Got: %v entries", len(s.revoked))
+	}
+}
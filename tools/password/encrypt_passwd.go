@@ -1,10 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jafossum/go-auth-server/crypto/passwd"
 )
 
 const pwdMinLen = 6
@@ -12,12 +13,16 @@ const pwdMinLen = 6
 // Encrypt and salt
 // Reference https://medium.com/@jcox250/password-hash-salt-using-golang-b041dc94cb72
 func main() {
+	alg := flag.String("alg", passwd.Bcrypt, "Hash algorithm: bcrypt, argon2id or scrypt")
+	cost := flag.Int("cost", 0, "bcrypt cost, argon2id iterations or scrypt log2(N). Algorithm default if 0")
+	flag.Parse()
+
 	// Enter a password and generate a salted hash
 	pwd := getConsoleInput("password")
 	if pwd == "" {
 		return
 	}
-	hash := hashAndSalt(pwd)
+	hash := hashAndSalt(pwd, passwd.Config{Algorithm: *alg, Cost: *cost})
 	fmt.Println("Salted Hash", hash)
 }
 
@@ -41,18 +46,12 @@ func getConsoleInput(t string) string {
 	return pwd
 }
 
-func hashAndSalt(pwd string) string {
-
-	// Use GenerateFromPassword to hash & salt pwd.
-	// MinCost is just an integer constant provided by the bcrypt
-	// package along with DefaultCost & MaxCost.
-	// The cost can be any value you want provided it isn't lower
-	// than the MinCost (4)
-	hash, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.DefaultCost)
+func hashAndSalt(pwd string, c passwd.Config) string {
+	// The hash is a PHC string for argon2id and scrypt, with the
+	// algorithm and parameters, so it can be verified without config
+	hash, err := passwd.Hash(pwd, c)
 	if err != nil {
 		log.Println(err)
 	}
-	// GenerateFromPassword returns a byte slice so we need to
-	// convert the bytes to a string and return it
-	return string(hash)
+	return hash
 }
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"log"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jafossum/go-auth-server/crypto/passwd"
 )
 
 const pwdMinLen = 6
@@ -42,10 +42,8 @@ func getConsoleInput(t string) string {
 }
 
 func comparePasswords(hashedPwd, plainPwd string) bool {
-	// Since we'll be getting the hashed password from the DB it
-	// will be a string so we'll need to convert it to a byte slice
-	byteHash := []byte(hashedPwd)
-	err := bcrypt.CompareHashAndPassword(byteHash, []byte(plainPwd))
+	// The algorithm is given by the hash: bcrypt, argon2id or scrypt
+	err := passwd.ComparePasswords(plainPwd, hashedPwd)
 	if err != nil {
 		log.Println(err)
 		return false